@@ -25,6 +25,9 @@ Commands:
   block <height>                Fetch block by height.
   balance <hex-address>         Show account balance.
   send --from A --to B --amount N  Submit a transaction.
+       [--type bond|unbond]          Bond to or unbond from validator B instead.
+  validators                    List validators ordered by stake.
+  supply                        Show total token supply.
 `)
 	}
 	flag.Parse()
@@ -56,11 +59,16 @@ Commands:
 			exitErr("balance requires address argument")
 		}
 		getAndPrint(client, fmt.Sprintf("%s/balance/%s", *rpcAddr, cmdArgs[0]))
+	case "supply":
+		getAndPrint(client, fmt.Sprintf("%s/supply", *rpcAddr))
+	case "validators":
+		getAndPrint(client, fmt.Sprintf("%s/validators", *rpcAddr))
 	case "send":
 		sendFlags := flag.NewFlagSet("send", flag.ExitOnError)
 		from := sendFlags.String("from", "", "hex sender address")
 		to := sendFlags.String("to", "", "hex recipient address")
 		amount := sendFlags.Uint64("amount", 0, "transfer amount")
+		txType := sendFlags.String("type", "", "transaction type (transfer, bond, unbond)")
 		sendFlags.Parse(cmdArgs)
 
 		if *from == "" || *to == "" || *amount == 0 {
@@ -72,6 +80,9 @@ Commands:
 			"to":     *to,
 			"amount": *amount,
 		}
+		if *txType != "" {
+			payload["type"] = *txType
+		}
 		data, _ := json.Marshal(payload)
 		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/tx", *rpcAddr), bytes.NewReader(data))
 		if err != nil {
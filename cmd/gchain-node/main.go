@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"flag"
@@ -17,6 +19,7 @@ import (
 
 	"github.com/0xphantomotr/gchain/pkg/chain"
 	"github.com/0xphantomotr/gchain/pkg/consensus"
+	"github.com/0xphantomotr/gchain/pkg/events"
 	"github.com/0xphantomotr/gchain/pkg/mempool"
 	"github.com/0xphantomotr/gchain/pkg/p2p"
 	"github.com/0xphantomotr/gchain/pkg/rpc"
@@ -26,16 +29,58 @@ import (
 
 func main() {
 	rpcAddr := flag.String("rpc-listen", ":8000", "RPC listen address")
+	wsOrigins := flag.String("ws-origins", "", "comma-separated extra origins allowed to open /ws (same-host origins always are; * allows any)")
 	p2pAddr := flag.String("p2p-listen", ":9000", "P2P listen address")
 	seedsFlag := flag.String("p2p-seeds", "", "comma-separated list of peer addresses")
+	persistentFlag := flag.String("persistent-peers", "", "comma-separated peer addresses to keep connected, redialing with backoff")
 	nodeIDFlag := flag.String("node-id", "0101010101010101010101010101010101010101010101010101010101010101", "validator address (64 hex chars)")
+	consensusFlag := flag.String("consensus", "leader", "consensus engine: leader, clique or raft")
+	validatorKeyFlag := flag.String("validator-key", "", "ed25519 seed (64 hex chars) used to seal blocks; required for clique")
+	cliqueSigners := flag.String("clique-signers", "", "comma-separated genesis signer addresses for clique (defaults to this node)")
+	cliquePeriod := flag.Duration("clique-period", 2*time.Second, "clique block period")
+	raftMembers := flag.String("raft-members", "", "comma-separated raft member addresses (defaults to this node)")
 	genesisFlag := flag.String("genesis", "", "comma-separated list of addr:balance pairs (hex:amount)")
+	chainIDFlag := flag.String("chain-id", "gchain-dev", "chain ID peers must share")
+	addrBookFlag := flag.String("addrbook", "", "file to persist the peer address book in (memory only when empty)")
+	addrBookSize := flag.Int("addrbook-size", p2p.DefaultAddrBookSize, "maximum addresses kept in the address book")
+	seedMode := flag.Bool("seed-mode", false, "run as a seed node that crawls for peer addresses and hands them out")
+	maxInbound := flag.Int("max-inbound", 40, "maximum inbound peers (0 for no separate limit)")
+	maxOutbound := flag.Int("max-outbound", 10, "maximum outbound peers (0 for no separate limit)")
+	maxInboundPerIP := flag.Int("max-inbound-per-ip", 0, "maximum inbound peers from one IP (0 for no limit)")
+	maxInboundPerSubnet := flag.Int("max-inbound-per-subnet", 0, "maximum inbound peers from one /24 or /64 subnet (0 for no limit)")
+	reservedPeers := flag.String("reserved-peers", "", "comma-separated node IDs to hold connection slots for within the peer limit")
+	unconditionalPeers := flag.String("unconditional-peer-ids", "", "comma-separated node IDs exempt from connection limits")
+	privatePeers := flag.String("private-peer-ids", "", "comma-separated node IDs never advertised to other peers")
+	sentryOnly := flag.Bool("sentry-only", false, "only connect to --persistent-peers (sentries) and --unconditional-peer-ids")
+	compressionFlag := flag.String("p2p-compression", "", "comma-separated frame compression algorithms to offer peers, preferred first (zstd, snappy)")
+	targetOutbound := flag.Int("target-outbound", p2p.DefaultTargetOutbound, "outbound peer count to maintain from the address book")
+	discoveryAddr := flag.String("discovery-listen", "", "UDP address for the Kademlia peer discovery DHT (disabled when empty)")
+	bootnodesFlag := flag.String("bootnodes", "", "comma-separated UDP addresses of discovery bootnodes")
+	p2pKeyFlag := flag.String("p2p-key", "", "ed25519 seed (64 hex chars) for the P2P node identity; random when empty")
+	stakingEpoch := flag.Uint64("staking-epoch", 0, "recompute the validator set from stake every N blocks (0 disables)")
+	unbondingPeriod := flag.Uint64("unbonding-period", state.DefaultStakingParams().UnbondingPeriod, "blocks before unbonded tokens are released")
+	maxValidators := flag.Int("max-validators", 100, "maximum number of active validators")
+	rewardPolicy := flag.String("reward-policy", "none", "block reward policy: none, fixed, halving or inflation")
+	blockReward := flag.Uint64("block-reward", 10, "reward per block for the fixed and halving policies")
+	halvingInterval := flag.Uint64("halving-interval", 100_000, "blocks between reward halvings")
+	inflationBps := flag.Uint64("inflation-bps", 500, "annual inflation in basis points for the inflation policy")
+	blocksPerYear := flag.Uint64("blocks-per-year", 15_768_000, "expected blocks per year for the inflation policy")
+	voterShareBps := flag.Uint64("voter-share-bps", 0, "basis points of each reward split among the validators that voted for the previous block")
 	flag.Parse()
 
 	nodeID, err := parseHexAddress(*nodeIDFlag)
 	if err != nil {
 		log.Fatalf("invalid node-id: %v", err)
 	}
+	var validatorKey ed25519.PrivateKey
+	if *validatorKeyFlag != "" {
+		seed, err := parseHexAddress(*validatorKeyFlag)
+		if err != nil {
+			log.Fatalf("invalid validator-key: %v", err)
+		}
+		validatorKey = ed25519.NewKeyFromSeed(seed[:])
+		nodeID = consensus.SignerAddress(validatorKey)
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
@@ -47,6 +92,14 @@ func main() {
 	}
 
 	stateMgr := state.NewManager(state.NewMemoryStore())
+	stakingParams := state.DefaultStakingParams()
+	stakingParams.UnbondingPeriod = *unbondingPeriod
+	stateMgr.SetStakingParams(stakingParams)
+	policy, err := parseRewardPolicy(*rewardPolicy, *blockReward, *halvingInterval, *inflationBps, *blocksPerYear)
+	if err != nil {
+		log.Fatalf("invalid reward policy: %v", err)
+	}
+	stateMgr.SetRewardParams(state.RewardParams{Policy: policy, VoterShareBps: *voterShareBps})
 	if err := applyGenesis(stateMgr, *genesisFlag); err != nil {
 		log.Fatalf("apply genesis: %v", err)
 	}
@@ -56,54 +109,155 @@ func main() {
 		}
 	}
 	pool := mempool.New(1024, nil)
+	bus := events.NewBus()
+	pool.SetEventBus(bus)
 
-	var seeds []string
-	if *seedsFlag != "" {
-		for _, entry := range strings.Split(*seedsFlag, ",") {
-			addr := strings.TrimSpace(entry)
-			if addr != "" {
-				seeds = append(seeds, addr)
-			}
+	seeds := splitList(*seedsFlag)
+
+	var nodeKey ed25519.PrivateKey
+	if *p2pKeyFlag != "" {
+		seed, err := parseHexAddress(*p2pKeyFlag)
+		if err != nil {
+			log.Fatalf("invalid p2p-key: %v", err)
 		}
+		nodeKey = ed25519.NewKeyFromSeed(seed[:])
+	}
+
+	var compression []p2p.Compression
+	for _, name := range splitList(*compressionFlag) {
+		compression = append(compression, p2p.Compression(name))
 	}
 
 	p2pServer := p2p.NewServer(p2p.Config{
-		ListenAddr:       *p2pAddr,
-		Seeds:            seeds,
-		HandshakeTimeout: 5 * time.Second,
-		MaxPeers:         50,
+		ListenAddr:           *p2pAddr,
+		Seeds:                seeds,
+		PersistentPeers:      splitList(*persistentFlag),
+		HandshakeTimeout:     5 * time.Second,
+		MaxPeers:             50,
+		MaxInbound:           *maxInbound,
+		MaxOutbound:          *maxOutbound,
+		MaxInboundPerIP:      *maxInboundPerIP,
+		MaxInboundPerSubnet:  *maxInboundPerSubnet,
+		ReservedPeers:        splitList(*reservedPeers),
+		UnconditionalPeerIDs: splitList(*unconditionalPeers),
+		PrivatePeerIDs:       splitList(*privatePeers),
+		Compression:          compression,
+		SentryOnly:           *sentryOnly,
+		AddrBookPath:         *addrBookFlag,
+		MaxAddrBookSize:      *addrBookSize,
+		TargetOutbound:       *targetOutbound,
+		SeedMode:             *seedMode,
+		DiscoveryAddr:        *discoveryAddr,
+		Bootnodes:            splitList(*bootnodesFlag),
+		NodeKey:              nodeKey,
+		ChainID:              *chainIDFlag,
+		GenesisHash:          sha256.Sum256([]byte(*genesisFlag)),
+		BestHeight: func() uint64 {
+			height, _ := chainMgr.Tip()
+			return height
+		},
 	})
 
 	p2pServer.RegisterHandler(p2p.MessageTypeTx, func(peer p2p.PeerInfo, payload []byte) {
 		var tx types.Transaction
 		if err := json.Unmarshal(payload, &tx); err != nil {
 			log.Printf("p2p: invalid tx payload from %s: %v", peer.ID, err)
+			p2pServer.ReportPeer(peer.ID, p2p.PenaltyBadPayload, "undecodable tx")
 			return
 		}
 		if err := pool.Add(tx); err != nil {
+			p2pServer.ReportPeer(peer.ID, p2p.PenaltyInvalidTx, err.Error())
 			return
 		}
 		p2pServer.BroadcastExcept(peer.ID, p2p.NewEnvelope(p2p.MessageTypeTx, payload, ""))
 	})
 
-	if err := p2pServer.Start(); err != nil {
-		log.Fatalf("start p2p server: %v", err)
+	// The engine and every consensus handler must be in place before the
+	// server starts, since peers may deliver blocks as soon as it does.
+	var engine consensus.Engine
+	deliverConsensus := func(peer p2p.PeerInfo, msg consensus.Message) {
+		if msg.Block != nil && msg.Block.Header.TxRoot != msg.Block.CalculateTxRoot() {
+			p2pServer.ReportPeer(peer.ID, p2p.PenaltyInvalidBlock, "block tx root mismatch")
+			return
+		}
+		engine.HandleMessage(msg)
+	}
+	consensusBroadcaster := consensus.NewCompactRelay(p2pServer, pool, deliverConsensus)
+	switch *consensusFlag {
+	case "leader":
+		validatorSet := singleValidatorSet{id: nodeID}
+		leader := consensus.NewLeaderEngine(chainMgr, pool, stateMgr, validatorSet, consensusBroadcaster, nodeID, 2*time.Second, 64)
+		if *stakingEpoch > 0 {
+			leader.SetEndBlocker(&consensus.StakingEndBlocker{
+				State:           stateMgr,
+				EpochLength:     *stakingEpoch,
+				ActivationDelay: 1,
+				MaxValidators:   *maxValidators,
+			})
+		}
+		engine = leader
+	case "clique":
+		if validatorKey == nil {
+			log.Fatal("clique requires --validator-key")
+		}
+		signers := []types.Address{nodeID}
+		if *cliqueSigners != "" {
+			signers, err = parseAddressList(*cliqueSigners)
+			if err != nil {
+				log.Fatalf("invalid clique-signers: %v", err)
+			}
+		}
+		engine, err = consensus.NewCliqueEngine(chainMgr, pool, stateMgr, consensusBroadcaster, validatorKey, signers, consensus.CliqueConfig{
+			Period:         *cliquePeriod,
+			Wiggle:         1,
+			MaxTxsPerBlock: 64,
+		})
+		if err != nil {
+			log.Fatalf("init clique engine: %v", err)
+		}
+	case "raft":
+		members := []types.Address{nodeID}
+		if *raftMembers != "" {
+			members, err = parseAddressList(*raftMembers)
+			if err != nil {
+				log.Fatalf("invalid raft-members: %v", err)
+			}
+		}
+		engine, err = consensus.NewRaftEngine(chainMgr, pool, stateMgr, p2pServer, nodeID, members, consensus.RaftConfig{
+			TickInterval:      100 * time.Millisecond,
+			ElectionTicks:     10,
+			HeartbeatTicks:    1,
+			BlockTicks:        20,
+			SnapshotThreshold: 1024,
+			MaxTxsPerBlock:    64,
+			Seed:              time.Now().UnixNano(),
+		})
+		if err != nil {
+			log.Fatalf("init raft engine: %v", err)
+		}
+	default:
+		log.Fatalf("unknown consensus engine %q", *consensusFlag)
 	}
-	defer p2pServer.Close()
-
-	validatorSet := singleValidatorSet{id: nodeID}
-	consensusBroadcaster := &p2pConsensusBroadcaster{transport: p2pServer}
-	engine := consensus.NewLeaderEngine(chainMgr, pool, stateMgr, validatorSet, consensusBroadcaster, nodeID, 2*time.Second, 64)
 
 	p2pServer.RegisterHandler(p2p.MessageTypeConsensus, func(peer p2p.PeerInfo, payload []byte) {
 		var msg consensus.Message
 		if err := json.Unmarshal(payload, &msg); err != nil {
 			log.Printf("p2p: invalid consensus payload from %s: %v", peer.ID, err)
+			p2pServer.ReportPeer(peer.ID, p2p.PenaltyBadPayload, "undecodable consensus message")
 			return
 		}
-		engine.HandleMessage(msg)
+		deliverConsensus(peer, msg)
 	})
 
+	if publisher, ok := engine.(interface{ SetEventBus(*events.Bus) }); ok {
+		publisher.SetEventBus(bus)
+	}
+
+	if err := p2pServer.Start(); err != nil {
+		log.Fatalf("start p2p server: %v", err)
+	}
+	defer p2pServer.Close()
+
 	go func() {
 		if err := engine.Start(ctx); err != nil && err != context.Canceled {
 			log.Printf("consensus stopped: %v", err)
@@ -111,13 +265,15 @@ func main() {
 	}()
 
 	rpcServer := rpc.NewServer(chainMgr, stateMgr, pool, p2pServer, *rpcAddr)
+	rpcServer.SetEventBus(bus)
+	rpcServer.SetWSOrigins(splitList(*wsOrigins))
 
 	go func() {
 		if err := rpcServer.Start(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("rpc server error: %v", err)
 		}
 	}()
-	log.Printf("gchain node started; RPC on %s, P2P on %s\n", *rpcAddr, *p2pAddr)
+	log.Printf("gchain node started; RPC on %s, P2P on %s (node %s)\n", *rpcAddr, *p2pAddr, p2pServer.ID())
 
 	<-ctx.Done()
 	log.Println("shutting down...")
@@ -139,19 +295,6 @@ func (s singleValidatorSet) Proposer(height, round uint64) types.Address { retur
 func (s singleValidatorSet) Size() int                                   { return 1 }
 func (s singleValidatorSet) Has(addr types.Address) bool                 { return addr == s.id }
 
-type p2pConsensusBroadcaster struct {
-	transport p2p.Transport
-}
-
-func (b *p2pConsensusBroadcaster) Broadcast(msg consensus.Message) error {
-	payload, err := json.Marshal(msg)
-	if err != nil {
-		return err
-	}
-	b.transport.Broadcast(p2p.NewEnvelope(p2p.MessageTypeConsensus, payload, ""))
-	return nil
-}
-
 func parseHexAddress(input string) (types.Address, error) {
 	var addr types.Address
 	data, err := hex.DecodeString(strings.TrimPrefix(input, "0x"))
@@ -165,6 +308,47 @@ func parseHexAddress(input string) (types.Address, error) {
 	return addr, nil
 }
 
+func splitList(raw string) []string {
+	var out []string
+	for _, entry := range strings.Split(raw, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+func parseAddressList(input string) ([]types.Address, error) {
+	var out []types.Address
+	for _, entry := range strings.Split(input, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		addr, err := parseHexAddress(entry)
+		if err != nil {
+			return nil, fmt.Errorf("parse addr %q: %w", entry, err)
+		}
+		out = append(out, addr)
+	}
+	return out, nil
+}
+
+func parseRewardPolicy(name string, reward, halvingInterval, inflationBps, blocksPerYear uint64) (state.IssuancePolicy, error) {
+	switch name {
+	case "", "none":
+		return nil, nil
+	case "fixed":
+		return state.FixedReward{Amount: reward}, nil
+	case "halving":
+		return state.HalvingReward{Initial: reward, Interval: halvingInterval}, nil
+	case "inflation":
+		return state.InflationReward{RateBps: inflationBps, BlocksPerYear: blocksPerYear}, nil
+	default:
+		return nil, fmt.Errorf("unknown policy %q", name)
+	}
+}
+
 func applyGenesis(stateMgr *state.Manager, cfg string) error {
 	if cfg == "" {
 		return nil
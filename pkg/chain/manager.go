@@ -12,14 +12,28 @@ var (
 	ErrBlockNotFound    = errors.New("chain: block not found")
 	ErrUnexpectedHeight = errors.New("chain: unexpected block height")
 	ErrBadPrevHash      = errors.New("chain: previous hash mismatch")
+	ErrTxNotFound       = errors.New("chain: transaction not found")
+	ErrReceiptNotFound  = errors.New("chain: receipt not found")
+	ErrReceiptMismatch  = errors.New("chain: receipts do not match block transactions")
 )
 
+// TxLocation places a committed transaction within the chain.
+type TxLocation struct {
+	Height uint64 `json:"height"`
+	Index  int    `json:"index"`
+}
+
 type Store interface {
 	SaveBlock(block *types.Block) error
 	GetBlockByHeight(height uint64) (*types.Block, error)
 	GetBlockByHash(hash types.Hash) (*types.Block, error)
 	SetCannoicalHeight(height uint64) error
 	GetCannoicalHeight() (uint64, error)
+	// SaveReceipts stores the receipts of the block at height and indexes
+	// every transaction hash in it.
+	SaveReceipts(height uint64, txs []types.Hash, receipts []types.Receipt) error
+	GetReceipts(height uint64) ([]types.Receipt, error)
+	GetTxLocation(hash types.Hash) (TxLocation, error)
 }
 
 type Manager struct {
@@ -51,6 +65,16 @@ func NewManager(store Store) (*Manager, error) {
 }
 
 func (m *Manager) AddBlock(block *types.Block) error {
+	return m.AddBlockWithReceipts(block, nil)
+}
+
+// AddBlockWithReceipts appends block with the receipts from executing it.
+// receipts may be nil when the outcome is unknown; the block's transactions
+// are indexed either way.
+func (m *Manager) AddBlockWithReceipts(block *types.Block, receipts []types.Receipt) error {
+	if receipts != nil && len(receipts) != len(block.Transactions) {
+		return fmt.Errorf("%w: %d receipts for %d transactions", ErrReceiptMismatch, len(receipts), len(block.Transactions))
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -68,6 +92,13 @@ func (m *Manager) AddBlock(block *types.Block) error {
 	if err := m.store.SaveBlock(block); err != nil {
 		return fmt.Errorf("save block: %w", err)
 	}
+	hashes := make([]types.Hash, len(block.Transactions))
+	for i := range block.Transactions {
+		hashes[i] = block.Transactions[i].ID()
+	}
+	if err := m.store.SaveReceipts(block.Header.Height, hashes, receipts); err != nil {
+		return fmt.Errorf("save receipts: %w", err)
+	}
 	if err := m.store.SetCannoicalHeight(block.Header.Height); err != nil {
 		return fmt.Errorf("persist cannoical height: %w", err)
 	}
@@ -85,6 +116,38 @@ func (m *Manager) GetBlockByHash(hash types.Hash) (*types.Block, error) {
 	return m.store.GetBlockByHash(hash)
 }
 
+// GetTransaction returns a committed transaction and where it sits.
+func (m *Manager) GetTransaction(hash types.Hash) (*types.Transaction, TxLocation, error) {
+	loc, err := m.store.GetTxLocation(hash)
+	if err != nil {
+		return nil, TxLocation{}, err
+	}
+	block, err := m.store.GetBlockByHeight(loc.Height)
+	if err != nil {
+		return nil, TxLocation{}, err
+	}
+	if loc.Index >= len(block.Transactions) {
+		return nil, TxLocation{}, ErrTxNotFound
+	}
+	return &block.Transactions[loc.Index], loc, nil
+}
+
+// GetReceipt returns the receipt of a committed transaction.
+func (m *Manager) GetReceipt(hash types.Hash) (*types.Receipt, error) {
+	loc, err := m.store.GetTxLocation(hash)
+	if err != nil {
+		return nil, err
+	}
+	receipts, err := m.store.GetReceipts(loc.Height)
+	if err != nil {
+		return nil, err
+	}
+	if loc.Index >= len(receipts) {
+		return nil, ErrReceiptNotFound
+	}
+	return &receipts[loc.Index], nil
+}
+
 func (m *Manager) Tip() (uint64, types.Hash) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
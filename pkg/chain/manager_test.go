@@ -1,6 +1,7 @@
 package chain
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -107,3 +108,30 @@ func TestCanonicalHeightPersists(t *testing.T) {
 		t.Fatalf("expected persisted height 2, got %d", reloadedHeight)
 	}
 }
+
+func TestTxIndexAndReceipts(t *testing.T) {
+	mgr, _ := NewManager(NewMemoryStore())
+	block := makeBlock(1, types.Hash{})
+	hash := block.Transactions[0].CalculateHash()
+	receipts := []types.Receipt{{TxHash: hash, Height: 1, Success: false, Error: "state: nonce mismatch"}}
+	if err := mgr.AddBlockWithReceipts(block, receipts); err != nil {
+		t.Fatalf("add block: %v", err)
+	}
+
+	tx, loc, err := mgr.GetTransaction(hash)
+	if err != nil || loc.Height != 1 || loc.Index != 0 || tx.Amount != 1 {
+		t.Fatalf("unexpected lookup: %+v %+v %v", tx, loc, err)
+	}
+	receipt, err := mgr.GetReceipt(hash)
+	if err != nil || receipt.Success || receipt.Error == "" {
+		t.Fatalf("unexpected receipt: %+v %v", receipt, err)
+	}
+	if _, _, err := mgr.GetTransaction(types.Hash{9}); !errors.Is(err, ErrTxNotFound) {
+		t.Fatalf("expected ErrTxNotFound, got %v", err)
+	}
+
+	next := makeBlock(2, block.Header.Hash())
+	if err := mgr.AddBlockWithReceipts(next, []types.Receipt{}); !errors.Is(err, ErrReceiptMismatch) {
+		t.Fatalf("expected ErrReceiptMismatch, got %v", err)
+	}
+}
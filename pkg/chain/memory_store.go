@@ -13,12 +13,16 @@ type MemoryStore struct {
 	blocksByHeight    map[uint64]*types.Block
 	blocksByHash      map[types.Hash]*types.Block
 	cannoicalByHeight uint64
+	receipts          map[uint64][]types.Receipt
+	txIndex           map[types.Hash]TxLocation
 }
 
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
 		blocksByHeight: make(map[uint64]*types.Block),
 		blocksByHash:   make(map[types.Hash]*types.Block),
+		receipts:       make(map[uint64][]types.Receipt),
+		txIndex:        make(map[types.Hash]TxLocation),
 	}
 }
 
@@ -83,3 +87,35 @@ func (s *MemoryStore) GetCannoicalHeight() (uint64, error) {
 	defer s.mu.Unlock()
 	return s.cannoicalByHeight, nil
 }
+
+func (s *MemoryStore) SaveReceipts(height uint64, txs []types.Hash, receipts []types.Receipt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, hash := range txs {
+		s.txIndex[hash] = TxLocation{Height: height, Index: i}
+	}
+	if receipts != nil {
+		s.receipts[height] = append([]types.Receipt(nil), receipts...)
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetReceipts(height uint64) ([]types.Receipt, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	receipts, ok := s.receipts[height]
+	if !ok {
+		return nil, ErrReceiptNotFound
+	}
+	return append([]types.Receipt(nil), receipts...), nil
+}
+
+func (s *MemoryStore) GetTxLocation(hash types.Hash) (TxLocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	loc, ok := s.txIndex[hash]
+	if !ok {
+		return TxLocation{}, ErrTxNotFound
+	}
+	return loc, nil
+}
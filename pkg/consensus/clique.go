@@ -0,0 +1,403 @@
+package consensus
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/chain"
+	"github.com/0xphantomotr/gchain/pkg/events"
+	"github.com/0xphantomotr/gchain/pkg/mempool"
+	"github.com/0xphantomotr/gchain/pkg/state"
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+const (
+	// DifficultyInTurn is the difficulty of a block sealed by the signer whose
+	// turn it is.
+	DifficultyInTurn uint64 = 2
+	// DifficultyNoTurn is the difficulty of a block sealed out of turn.
+	DifficultyNoTurn uint64 = 1
+)
+
+var (
+	ErrUnauthorizedSigner = errors.New("clique: unauthorized signer")
+	ErrRecentlySigned     = errors.New("clique: signer signed recently")
+	ErrInvalidDifficulty  = errors.New("clique: invalid difficulty")
+	ErrInvalidSeal        = errors.New("clique: invalid seal")
+)
+
+type CliqueConfig struct {
+	// Period is the target time between blocks.
+	Period time.Duration
+	// Wiggle is how many periods an out-of-turn signer waits per position it
+	// is away from the in-turn signer before sealing.
+	Wiggle         int
+	MaxTxsPerBlock int
+}
+
+// CliqueEngine is a proof-of-authority engine modelled on Clique. A fixed set
+// of authorized signers take turns sealing blocks; signers vote in block
+// headers to add or remove authorities, and no signer may seal more than one
+// of any len(signers)/2+1 consecutive blocks.
+//
+// chain.Manager cannot reorganize, so the first valid block at a height wins.
+// The out-of-turn wiggle keeps competing blocks rare.
+type CliqueEngine struct {
+	mu          sync.Mutex
+	chain       *chain.Manager
+	mempool     *mempool.Mempool
+	state       *state.Manager
+	events      *events.Bus
+	broadcaster Broadcaster
+	cfg         CliqueConfig
+
+	key       ed25519.PrivateKey
+	signer    types.Address
+	snap      *cliqueSnapshot
+	proposals map[types.Address]bool
+	idleTicks int
+	now       func() time.Time
+}
+
+func NewCliqueEngine(chainMgr *chain.Manager, mem *mempool.Mempool, stateMgr *state.Manager, broadcaster Broadcaster, key ed25519.PrivateKey, signers []types.Address, cfg CliqueConfig) (*CliqueEngine, error) {
+	if cfg.Wiggle <= 0 {
+		cfg.Wiggle = 1
+	}
+	e := &CliqueEngine{
+		chain:       chainMgr,
+		mempool:     mem,
+		state:       stateMgr,
+		broadcaster: broadcaster,
+		cfg:         cfg,
+		key:         key,
+		signer:      SignerAddress(key),
+		snap:        newCliqueSnapshot(signers),
+		proposals:   make(map[types.Address]bool),
+		now:         time.Now,
+	}
+
+	// Rebuild the signer set by replaying the headers already on disk.
+	tip, _ := chainMgr.Tip()
+	for h := uint64(1); h <= tip; h++ {
+		block, err := chainMgr.GetBlockByHeight(h)
+		if err != nil {
+			return nil, fmt.Errorf("load block %d: %w", h, err)
+		}
+		e.snap.apply(&block.Header)
+	}
+	return e, nil
+}
+
+// SetClock replaces the clock used to timestamp sealed blocks.
+func (e *CliqueEngine) SetClock(now func() time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.now = now
+}
+
+// SignerAddress returns the address that identifies key's owner as a signer.
+func SignerAddress(key ed25519.PrivateKey) types.Address {
+	var addr types.Address
+	copy(addr[:], key.Public().(ed25519.PublicKey))
+	return addr
+}
+
+// Propose queues a vote to add (authorize) or remove target from the signer
+// set. The vote is embedded in every block this node seals until it passes or
+// is discarded.
+func (e *CliqueEngine) Propose(target types.Address, authorize bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.proposals[target] = authorize
+}
+
+func (e *CliqueEngine) Discard(target types.Address) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.proposals, target)
+}
+
+// Signers returns the currently authorized signers in sorted order.
+func (e *CliqueEngine) Signers() []types.Address {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]types.Address, len(e.snap.signers))
+	copy(out, e.snap.signers)
+	return out
+}
+
+// SetEventBus publishes every committed block to bus.
+func (e *CliqueEngine) SetEventBus(bus *events.Bus) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = bus
+}
+
+func (e *CliqueEngine) Start(ctx context.Context) error {
+	ticker := time.NewTicker(e.cfg.Period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := e.tick(ctx); err != nil {
+				log.Printf("clique seal error: %v", err)
+			}
+		}
+	}
+}
+
+func (e *CliqueEngine) tick(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	tipHeight, tipHash := e.chain.Tip()
+	height := tipHeight + 1
+	if !e.snap.authorized(e.signer) || e.snap.recentlySigned(e.signer, height) {
+		return nil
+	}
+
+	distance := e.snap.distance(e.signer, height)
+	if distance > 0 {
+		e.idleTicks++
+		if e.idleTicks <= distance*e.cfg.Wiggle {
+			return nil
+		}
+	}
+
+	block := &types.Block{
+		Header: types.BlockHeader{
+			Height:       height,
+			PreviousHash: tipHash,
+			Proposer:     e.signer,
+			Timestamp:    e.now(),
+			Difficulty:   e.snap.difficulty(e.signer, height),
+			Vote:         e.pickVoteLocked(),
+		},
+		Transactions: executableTxs(e.mempool, e.state, height, e.cfg.MaxTxsPerBlock),
+	}
+	block.Header.TxRoot = block.CalculateTxRoot()
+	sealHash := block.Header.SealHash()
+	block.Header.Seal = ed25519.Sign(e.key, sealHash[:])
+
+	if err := e.commitLocked(block); err != nil {
+		return err
+	}
+	return e.broadcaster.Broadcast(Message{
+		From:   e.signer,
+		Height: height,
+		Type:   MessageTypeProposal,
+		Block:  block,
+	})
+}
+
+// pickVoteLocked returns one of our pending proposals that would still change
+// the signer set, choosing the lowest address so the pick is stable.
+func (e *CliqueEngine) pickVoteLocked() *types.AuthorityVote {
+	var picked *types.AuthorityVote
+	for target, authorize := range e.proposals {
+		if !e.snap.validVote(target, authorize) {
+			continue
+		}
+		if picked == nil || bytes.Compare(target[:], picked.Target[:]) < 0 {
+			picked = &types.AuthorityVote{Target: target, Authorize: authorize}
+		}
+	}
+	return picked
+}
+
+func (e *CliqueEngine) HandleMessage(msg Message) {
+	if msg.Type != MessageTypeProposal || msg.Block == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.verifyLocked(msg.Block); err != nil {
+		return
+	}
+	if err := e.commitLocked(msg.Block); err != nil {
+		log.Printf("clique commit error: %v", err)
+	}
+}
+
+func (e *CliqueEngine) verifyLocked(block *types.Block) error {
+	header := &block.Header
+	tipHeight, tipHash := e.chain.Tip()
+	if header.Height != tipHeight+1 {
+		return fmt.Errorf("unexpected height: got %d, want %d", header.Height, tipHeight+1)
+	}
+	if header.PreviousHash != tipHash {
+		return fmt.Errorf("previous hash mismatch")
+	}
+	if header.TxRoot != block.CalculateTxRoot() {
+		return fmt.Errorf("tx root mismatch")
+	}
+	signer := header.Proposer
+	if !e.snap.authorized(signer) {
+		return ErrUnauthorizedSigner
+	}
+	if e.snap.recentlySigned(signer, header.Height) {
+		return ErrRecentlySigned
+	}
+	if header.Difficulty != e.snap.difficulty(signer, header.Height) {
+		return ErrInvalidDifficulty
+	}
+	sealHash := header.SealHash()
+	if !ed25519.Verify(ed25519.PublicKey(signer[:]), sealHash[:], header.Seal) {
+		return ErrInvalidSeal
+	}
+	return nil
+}
+
+func (e *CliqueEngine) commitLocked(block *types.Block) error {
+	if err := commitBlock(e.chain, e.state, e.mempool, e.events, block); err != nil {
+		return err
+	}
+	e.snap.apply(&block.Header)
+	if vote := block.Header.Vote; vote != nil && !e.snap.validVote(vote.Target, vote.Authorize) {
+		// The vote passed; stop proposing it.
+		delete(e.proposals, vote.Target)
+	}
+	e.idleTicks = 0
+	return nil
+}
+
+type cliqueVote struct {
+	signer    types.Address
+	target    types.Address
+	authorize bool
+}
+
+// cliqueSnapshot is the signer set and voting state as of the chain tip.
+type cliqueSnapshot struct {
+	signers []types.Address
+	recents map[uint64]types.Address
+	votes   []cliqueVote
+}
+
+func newCliqueSnapshot(signers []types.Address) *cliqueSnapshot {
+	snap := &cliqueSnapshot{
+		signers: make([]types.Address, len(signers)),
+		recents: make(map[uint64]types.Address),
+	}
+	copy(snap.signers, signers)
+	snap.sort()
+	return snap
+}
+
+func (s *cliqueSnapshot) sort() {
+	sort.Slice(s.signers, func(i, j int) bool {
+		return bytes.Compare(s.signers[i][:], s.signers[j][:]) < 0
+	})
+}
+
+func (s *cliqueSnapshot) index(addr types.Address) int {
+	for i, signer := range s.signers {
+		if signer == addr {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *cliqueSnapshot) authorized(addr types.Address) bool {
+	return s.index(addr) >= 0
+}
+
+func (s *cliqueSnapshot) limit() uint64 {
+	return uint64(len(s.signers)/2 + 1)
+}
+
+func (s *cliqueSnapshot) recentlySigned(addr types.Address, height uint64) bool {
+	for seen, signer := range s.recents {
+		if signer == addr && seen+s.limit() > height {
+			return true
+		}
+	}
+	return false
+}
+
+// distance is how many positions signer is behind the in-turn signer for
+// height; zero means signer is in turn.
+func (s *cliqueSnapshot) distance(signer types.Address, height uint64) int {
+	n := len(s.signers)
+	if n == 0 {
+		return 0
+	}
+	inTurn := int(height % uint64(n))
+	return (s.index(signer) - inTurn + n) % n
+}
+
+func (s *cliqueSnapshot) difficulty(signer types.Address, height uint64) uint64 {
+	if s.distance(signer, height) == 0 {
+		return DifficultyInTurn
+	}
+	return DifficultyNoTurn
+}
+
+func (s *cliqueSnapshot) validVote(target types.Address, authorize bool) bool {
+	return s.authorized(target) != authorize
+}
+
+func (s *cliqueSnapshot) apply(header *types.BlockHeader) {
+	s.recents[header.Height] = header.Proposer
+	for seen := range s.recents {
+		if seen+s.limit() <= header.Height {
+			delete(s.recents, seen)
+		}
+	}
+
+	vote := header.Vote
+	if vote == nil || !s.validVote(vote.Target, vote.Authorize) {
+		return
+	}
+
+	// A signer only gets one live vote per target.
+	kept := s.votes[:0]
+	for _, v := range s.votes {
+		if v.signer != header.Proposer || v.target != vote.Target {
+			kept = append(kept, v)
+		}
+	}
+	s.votes = append(kept, cliqueVote{signer: header.Proposer, target: vote.Target, authorize: vote.Authorize})
+
+	tally := 0
+	for _, v := range s.votes {
+		if v.target == vote.Target && v.authorize == vote.Authorize {
+			tally++
+		}
+	}
+	if tally <= len(s.signers)/2 {
+		return
+	}
+
+	if vote.Authorize {
+		s.signers = append(s.signers, vote.Target)
+		s.sort()
+	} else {
+		i := s.index(vote.Target)
+		s.signers = append(s.signers[:i], s.signers[i+1:]...)
+	}
+
+	// Drop every vote about the target, and every vote cast by it if it was
+	// just removed.
+	kept = s.votes[:0]
+	for _, v := range s.votes {
+		if v.target == vote.Target || (!vote.Authorize && v.signer == vote.Target) {
+			continue
+		}
+		kept = append(kept, v)
+	}
+	s.votes = kept
+}
@@ -0,0 +1,162 @@
+package consensus
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/mempool"
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+// relayBroadcaster hands every message straight to the other engines.
+type relayBroadcaster struct {
+	self    int
+	engines *[]*CliqueEngine
+}
+
+func (r *relayBroadcaster) Broadcast(msg Message) error {
+	for i, e := range *r.engines {
+		if i != r.self && e != nil {
+			e.HandleMessage(msg)
+		}
+	}
+	return nil
+}
+
+func newCliqueNetwork(t *testing.T, n int) ([]*CliqueEngine, []ed25519.PrivateKey) {
+	t.Helper()
+	keys := make([]ed25519.PrivateKey, n)
+	signers := make([]types.Address, n)
+	for i := range keys {
+		keys[i] = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{byte(i + 1)}, ed25519.SeedSize))
+		signers[i] = SignerAddress(keys[i])
+	}
+
+	engines := make([]*CliqueEngine, n)
+	for i := range engines {
+		e, err := NewCliqueEngine(newChainManager(t), mempool.New(10, nil), newStateManager(t), &relayBroadcaster{self: i, engines: &engines}, keys[i], signers, CliqueConfig{Period: time.Millisecond, Wiggle: 1, MaxTxsPerBlock: 5})
+		if err != nil {
+			t.Fatalf("new clique engine: %v", err)
+		}
+		engines[i] = e
+	}
+	return engines, keys
+}
+
+func tickAll(t *testing.T, engines []*CliqueEngine, skip int) {
+	t.Helper()
+	for i, e := range engines {
+		if i == skip {
+			continue
+		}
+		if err := e.tick(context.Background()); err != nil {
+			t.Fatalf("tick engine %d: %v", i, err)
+		}
+	}
+}
+
+func TestCliqueInTurnSigning(t *testing.T) {
+	engines, _ := newCliqueNetwork(t, 3)
+
+	for round := 0; round < 3; round++ {
+		tickAll(t, engines, -1)
+	}
+
+	tip, tipHash := engines[0].chain.Tip()
+	if tip < 3 {
+		t.Fatalf("expected at least 3 blocks, got %d", tip)
+	}
+	for i, e := range engines {
+		height, hash := e.chain.Tip()
+		if height != tip || hash != tipHash {
+			t.Fatalf("engine %d diverged: height %d", i, height)
+		}
+	}
+	for h := uint64(1); h <= tip; h++ {
+		block, err := engines[0].chain.GetBlockByHeight(h)
+		if err != nil {
+			t.Fatalf("get block %d: %v", h, err)
+		}
+		if block.Header.Difficulty != DifficultyInTurn {
+			t.Fatalf("block %d sealed out of turn", h)
+		}
+	}
+}
+
+func TestCliqueOutOfTurnWhenSignerOffline(t *testing.T) {
+	engines, _ := newCliqueNetwork(t, 3)
+	signers := engines[0].Signers()
+	// Height 1 belongs to signers[1]; take that signer offline.
+	offline := -1
+	for i, e := range engines {
+		if e.signer == signers[1] {
+			offline = i
+		}
+	}
+
+	for round := 0; round < 4; round++ {
+		tickAll(t, engines, offline)
+	}
+
+	block, err := engines[(offline+1)%3].chain.GetBlockByHeight(1)
+	if err != nil {
+		t.Fatalf("expected height 1 to be sealed out of turn: %v", err)
+	}
+	if block.Header.Difficulty != DifficultyNoTurn {
+		t.Fatalf("expected out-of-turn difficulty, got %d", block.Header.Difficulty)
+	}
+}
+
+func TestCliqueRejectsRecentSigner(t *testing.T) {
+	engines, keys := newCliqueNetwork(t, 3)
+	tickAll(t, engines, -1)
+
+	tipHeight, tipHash := engines[0].chain.Tip()
+	first, err := engines[0].chain.GetBlockByHeight(tipHeight)
+	if err != nil {
+		t.Fatalf("get block: %v", err)
+	}
+	var key ed25519.PrivateKey
+	for _, k := range keys {
+		if SignerAddress(k) == first.Header.Proposer {
+			key = k
+		}
+	}
+
+	block := &types.Block{Header: types.BlockHeader{
+		Height:       tipHeight + 1,
+		PreviousHash: tipHash,
+		Proposer:     first.Header.Proposer,
+		Timestamp:    time.Now(),
+		Difficulty:   engines[0].snap.difficulty(first.Header.Proposer, tipHeight+1),
+	}}
+	block.Header.TxRoot = block.CalculateTxRoot()
+	sealHash := block.Header.SealHash()
+	block.Header.Seal = ed25519.Sign(key, sealHash[:])
+
+	if err := engines[0].verifyLocked(block); !errors.Is(err, ErrRecentlySigned) {
+		t.Fatalf("expected recently signed error, got %v", err)
+	}
+}
+
+func TestCliqueVoteAddsSigner(t *testing.T) {
+	engines, _ := newCliqueNetwork(t, 3)
+	newcomer := SignerAddress(ed25519.NewKeyFromSeed(bytes.Repeat([]byte{9}, ed25519.SeedSize)))
+
+	engines[0].Propose(newcomer, true)
+	engines[1].Propose(newcomer, true)
+
+	for round := 0; round < 6; round++ {
+		tickAll(t, engines, -1)
+	}
+
+	for i, e := range engines {
+		if !e.snap.authorized(newcomer) {
+			t.Fatalf("engine %d: expected newcomer to be authorized, signers %v", i, e.Signers())
+		}
+	}
+}
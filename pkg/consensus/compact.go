@@ -0,0 +1,270 @@
+package consensus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/mempool"
+	"github.com/0xphantomotr/gchain/pkg/p2p"
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+const (
+	// compactRecentBlocks is how many announced blocks are kept to serve
+	// missing transactions from.
+	compactRecentBlocks = 32
+	compactFetchTimeout = 5 * time.Second
+	// compactFetchPeers is how many peers besides the announcer are asked
+	// for missing transactions when the announcer cannot serve them.
+	compactFetchPeers = 2
+	// compactMaxFetches caps the blocks whose transactions are being
+	// fetched at once; announcements beyond it are dropped.
+	compactMaxFetches = 8
+)
+
+var ErrUnknownCompactBlock = errors.New("compact: block not available")
+
+// compactMessage is a Message whose block travels as a CompactBlock.
+type compactMessage struct {
+	From    types.Address
+	Height  uint64
+	Round   uint64
+	Type    MessageType
+	Block   *types.CompactBlock
+	Attempt uint64 `json:",omitempty"`
+}
+
+// latencyLister is implemented by transports that can rank their peers by
+// round-trip time, such as p2p.Server.
+type latencyLister interface {
+	PeersByLatency() []p2p.PeerInfo
+}
+
+type blockTxsRequest struct {
+	BlockHash types.Hash `json:"block_hash"`
+	Indexes   []int      `json:"indexes"`
+}
+
+type blockTxsResponse struct {
+	Transactions []types.Transaction `json:"transactions"`
+}
+
+// CompactRelay is a Broadcaster that announces blocks as compact blocks.
+// Receivers rebuild them from their mempool and fetch only the missing
+// transactions, from the announcing peer or else the lowest-latency others,
+// before handing the full Message to deliver. Messages without a block go
+// out as MessageTypeConsensus as before.
+type CompactRelay struct {
+	transport p2p.Transport
+	pool      *mempool.Mempool
+	deliver   func(peer p2p.PeerInfo, msg Message)
+	fetches   chan struct{}
+
+	mu     sync.Mutex
+	recent map[types.Hash]*types.Block
+	order  []types.Hash
+}
+
+func NewCompactRelay(transport p2p.Transport, pool *mempool.Mempool, deliver func(peer p2p.PeerInfo, msg Message)) *CompactRelay {
+	r := &CompactRelay{
+		transport: transport,
+		pool:      pool,
+		deliver:   deliver,
+		fetches:   make(chan struct{}, compactMaxFetches),
+		recent:    make(map[types.Hash]*types.Block),
+	}
+	transport.RegisterHandler(p2p.MessageTypeCompactBlock, r.handleCompact)
+	transport.RegisterRequestHandler(p2p.MessageTypeBlockTxs, r.serveBlockTxs)
+	return r
+}
+
+func (r *CompactRelay) Broadcast(msg Message) error {
+	if msg.Block == nil {
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		r.transport.Broadcast(p2p.NewEnvelope(p2p.MessageTypeConsensus, payload, ""))
+		return nil
+	}
+	r.remember(msg.Block)
+	return r.announce("", msg)
+}
+
+func (r *CompactRelay) announce(except string, msg Message) error {
+	payload, err := json.Marshal(compactMessage{
+		From:    msg.From,
+		Height:  msg.Height,
+		Round:   msg.Round,
+		Type:    msg.Type,
+		Block:   types.NewCompactBlock(msg.Block),
+		Attempt: msg.Attempt,
+	})
+	if err != nil {
+		return err
+	}
+	r.transport.BroadcastExcept(except, p2p.NewEnvelope(p2p.MessageTypeCompactBlock, payload, ""))
+	return nil
+}
+
+func (r *CompactRelay) remember(block *types.Block) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	hash := block.Header.Hash()
+	if _, ok := r.recent[hash]; ok {
+		return
+	}
+	if len(r.order) >= compactRecentBlocks {
+		delete(r.recent, r.order[0])
+		r.order = r.order[1:]
+	}
+	r.recent[hash] = block
+	r.order = append(r.order, hash)
+}
+
+func (r *CompactRelay) handleCompact(peer p2p.PeerInfo, payload []byte) {
+	var msg compactMessage
+	if err := json.Unmarshal(payload, &msg); err != nil || msg.Block == nil {
+		log.Printf("compact: invalid payload from %s", peer.ID)
+		r.transport.ReportPeer(peer.ID, p2p.PenaltyBadPayload, "undecodable compact block")
+		return
+	}
+	txs, missing := msg.Block.Fill(r.pool.Hashes(), r.pool.Get)
+	if len(missing) == 0 && txRootMatches(msg.Block, txs) {
+		r.complete(peer, msg, txs)
+		return
+	}
+	select {
+	case r.fetches <- struct{}{}:
+	default:
+		log.Printf("compact: dropping block %d from %s: too many fetches in flight", msg.Height, peer.ID)
+		return
+	}
+	// The responses arrive through the transport's read path, so wait for
+	// them off the handler.
+	go func() {
+		defer func() { <-r.fetches }()
+		if err := r.resolve(peer.ID, msg.Block, txs, missing); err != nil {
+			log.Printf("compact: block %d from %s: %v", msg.Height, peer.ID, err)
+			return
+		}
+		r.complete(peer, msg, txs)
+	}()
+}
+
+// resolve fetches the transactions at missing into txs, then falls back to
+// fetching every transaction when short IDs collided with the wrong mempool
+// entries.
+func (r *CompactRelay) resolve(announcer string, cb *types.CompactBlock, txs []types.Transaction, missing []int) error {
+	if len(missing) > 0 {
+		if err := r.fetch(announcer, cb, txs, missing); err != nil {
+			return err
+		}
+	}
+	if txRootMatches(cb, txs) {
+		return nil
+	}
+	all := make([]int, len(txs))
+	for i := range all {
+		all[i] = i
+	}
+	if err := r.fetch(announcer, cb, txs, all); err != nil {
+		return err
+	}
+	if !txRootMatches(cb, txs) {
+		r.transport.ReportPeer(announcer, p2p.PenaltyInvalidBlock, "compact block tx root mismatch")
+		return errors.New("tx root mismatch")
+	}
+	return nil
+}
+
+func txRootMatches(cb *types.CompactBlock, txs []types.Transaction) bool {
+	block := types.Block{Header: cb.Header, Transactions: txs}
+	return block.CalculateTxRoot() == cb.Header.TxRoot
+}
+
+// fetch requests the transactions at missing into txs, asking the announcing
+// peer first and then the fastest other peers, which may have relayed the
+// block.
+func (r *CompactRelay) fetch(announcer string, cb *types.CompactBlock, txs []types.Transaction, missing []int) error {
+	var err error
+	for _, peerID := range r.fetchPeers(announcer) {
+		if err = r.fetchFrom(peerID, cb, txs, missing); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (r *CompactRelay) fetchPeers(announcer string) []string {
+	peers := []string{announcer}
+	lister, ok := r.transport.(latencyLister)
+	if !ok {
+		return peers
+	}
+	for _, peer := range lister.PeersByLatency() {
+		if len(peers) > compactFetchPeers {
+			break
+		}
+		if peer.ID != announcer {
+			peers = append(peers, peer.ID)
+		}
+	}
+	return peers
+}
+
+func (r *CompactRelay) fetchFrom(peerID string, cb *types.CompactBlock, txs []types.Transaction, missing []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), compactFetchTimeout)
+	defer cancel()
+	req := blockTxsRequest{BlockHash: cb.Header.Hash(), Indexes: missing}
+	resp, err := r.transport.Request(ctx, peerID, p2p.NewEnvelope(p2p.MessageTypeBlockTxs, p2p.MustMarshalPayload(req), ""))
+	if err != nil {
+		return fmt.Errorf("fetch missing transactions: %w", err)
+	}
+	var got blockTxsResponse
+	if err := json.Unmarshal(resp.Payload, &got); err != nil || len(got.Transactions) != len(missing) {
+		r.transport.ReportPeer(peerID, p2p.PenaltyBadPayload, "bad block transactions response")
+		return fmt.Errorf("bad block transactions response")
+	}
+	for i, idx := range missing {
+		txs[idx] = got.Transactions[i]
+	}
+	return nil
+}
+
+// complete delivers the assembled block and passes the announcement on.
+func (r *CompactRelay) complete(peer p2p.PeerInfo, msg compactMessage, txs []types.Transaction) {
+	block := &types.Block{Header: msg.Block.Header, Transactions: txs}
+	r.remember(block)
+	full := Message{From: msg.From, Height: msg.Height, Round: msg.Round, Type: msg.Type, Block: block, Attempt: msg.Attempt}
+	r.deliver(peer, full)
+	if err := r.announce(peer.ID, full); err != nil {
+		log.Printf("compact: relay block %d: %v", msg.Height, err)
+	}
+}
+
+func (r *CompactRelay) serveBlockTxs(peer p2p.PeerInfo, env p2p.Envelope) (p2p.Envelope, error) {
+	var req blockTxsRequest
+	if err := json.Unmarshal(env.Payload, &req); err != nil {
+		return p2p.Envelope{}, err
+	}
+	r.mu.Lock()
+	block := r.recent[req.BlockHash]
+	r.mu.Unlock()
+	if block == nil {
+		return p2p.Envelope{}, ErrUnknownCompactBlock
+	}
+	resp := blockTxsResponse{Transactions: make([]types.Transaction, 0, len(req.Indexes))}
+	for _, idx := range req.Indexes {
+		if idx < 0 || idx >= len(block.Transactions) {
+			return p2p.Envelope{}, fmt.Errorf("compact: transaction index %d out of range", idx)
+		}
+		resp.Transactions = append(resp.Transactions, block.Transactions[idx])
+	}
+	return p2p.NewEnvelope(p2p.MessageTypeBlockTxs, p2p.MustMarshalPayload(resp), ""), nil
+}
@@ -0,0 +1,212 @@
+package consensus
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/mempool"
+	"github.com/0xphantomotr/gchain/pkg/p2p"
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+func TestCompactRelayFetchesMissingTransactions(t *testing.T) {
+	hub := p2p.NewMemoryHub(p2p.MemoryHubConfig{Latency: time.Millisecond})
+	nodeA, _ := hub.Connect("a")
+	nodeB, _ := hub.Connect("b")
+	for _, node := range []*p2p.MemoryTransport{nodeA, nodeB} {
+		node.Start()
+		defer node.Close()
+	}
+
+	txs := make([]types.Transaction, 3)
+	for i := range txs {
+		txs[i] = types.Transaction{From: types.Address{1}, To: types.Address{2}, Amount: uint64(i + 1), Timestamp: time.Unix(int64(i), 0)}
+		txs[i].Hash = txs[i].CalculateHash()
+	}
+	poolB := mempool.New(10, nil)
+	for _, tx := range txs[:2] {
+		if err := poolB.Add(tx); err != nil {
+			t.Fatalf("add tx: %v", err)
+		}
+	}
+
+	relayA := NewCompactRelay(nodeA, mempool.New(10, nil), func(p2p.PeerInfo, Message) {})
+	got := make(chan Message, 1)
+	NewCompactRelay(nodeB, poolB, func(peer p2p.PeerInfo, msg Message) { got <- msg })
+
+	block := &types.Block{Header: types.BlockHeader{Height: 1, Timestamp: time.Unix(10, 0)}, Transactions: txs}
+	block.Header.TxRoot = block.CalculateTxRoot()
+	if err := relayA.Broadcast(Message{From: types.Address{1}, Height: 1, Type: MessageTypeProposal, Block: block}); err != nil {
+		t.Fatalf("broadcast: %v", err)
+	}
+
+	select {
+	case msg := <-got:
+		if msg.Block == nil || msg.Block.Header.Hash() != block.Header.Hash() || len(msg.Block.Transactions) != len(txs) {
+			t.Fatalf("unexpected block: %+v", msg.Block)
+		}
+		if msg.Block.Transactions[2].Hash != txs[2].Hash {
+			t.Fatal("missing transaction was not fetched")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("block was not reconstructed")
+	}
+}
+
+func TestCompactRelayFallsBackToOtherPeers(t *testing.T) {
+	hub := p2p.NewMemoryHub(p2p.MemoryHubConfig{Latency: time.Millisecond})
+	nodeA, _ := hub.Connect("a")
+	nodeB, _ := hub.Connect("b")
+	nodeX, _ := hub.Connect("x")
+	for _, node := range []*p2p.MemoryTransport{nodeA, nodeB, nodeX} {
+		node.Start()
+		defer node.Close()
+	}
+
+	tx := types.Transaction{From: types.Address{1}, To: types.Address{2}, Amount: 1, Timestamp: time.Unix(1, 0)}
+	tx.Hash = tx.CalculateHash()
+	block := &types.Block{Header: types.BlockHeader{Height: 1, Timestamp: time.Unix(10, 0)}, Transactions: []types.Transaction{tx}}
+	block.Header.TxRoot = block.CalculateTxRoot()
+
+	relayA := NewCompactRelay(nodeA, mempool.New(10, nil), func(p2p.PeerInfo, Message) {})
+	relayA.remember(block)
+	got := make(chan Message, 1)
+	NewCompactRelay(nodeB, mempool.New(10, nil), func(peer p2p.PeerInfo, msg Message) { got <- msg })
+
+	// x announces a block it cannot serve; b must get the transactions
+	// from a instead.
+	payload := p2p.MustMarshalPayload(compactMessage{From: types.Address{1}, Height: 1, Type: MessageTypeProposal, Block: types.NewCompactBlock(block)})
+	if err := nodeX.Send("b", p2p.NewEnvelope(p2p.MessageTypeCompactBlock, payload, "")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	select {
+	case msg := <-got:
+		if msg.Block.Header.Hash() != block.Header.Hash() || msg.Block.Transactions[0].Hash != tx.Hash {
+			t.Fatalf("unexpected block: %+v", msg.Block)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("block was not reconstructed from another peer")
+	}
+}
+
+func TestCompactRelayRefetchesOnShortIDCollision(t *testing.T) {
+	hub := p2p.NewMemoryHub(p2p.MemoryHubConfig{Latency: time.Millisecond})
+	nodeA, _ := hub.Connect("a")
+	nodeB, _ := hub.Connect("b")
+	for _, node := range []*p2p.MemoryTransport{nodeA, nodeB} {
+		node.Start()
+		defer node.Close()
+	}
+
+	tx := types.Transaction{From: types.Address{1}, To: types.Address{2}, Amount: 1, Timestamp: time.Unix(1, 0)}
+	tx.Hash = tx.CalculateHash()
+	decoy := types.Transaction{From: types.Address{1}, To: types.Address{2}, Amount: 2, Timestamp: time.Unix(2, 0)}
+	decoy.Hash = decoy.CalculateHash()
+	block := &types.Block{Header: types.BlockHeader{Height: 1, Timestamp: time.Unix(10, 0)}, Transactions: []types.Transaction{tx}}
+	block.Header.TxRoot = block.CalculateTxRoot()
+
+	relayA := NewCompactRelay(nodeA, mempool.New(10, nil), func(p2p.PeerInfo, Message) {})
+	relayA.remember(block)
+	poolB := mempool.New(10, nil)
+	if err := poolB.Add(decoy); err != nil {
+		t.Fatalf("add tx: %v", err)
+	}
+	got := make(chan Message, 1)
+	NewCompactRelay(nodeB, poolB, func(peer p2p.PeerInfo, msg Message) { got <- msg })
+
+	// Announce short IDs that match the decoy, as a collision would, so b
+	// fills the block without missing transactions but with the wrong root.
+	collided := types.NewCompactBlock(&types.Block{Header: block.Header, Transactions: []types.Transaction{decoy}})
+	payload := p2p.MustMarshalPayload(compactMessage{From: types.Address{1}, Height: 1, Type: MessageTypeProposal, Block: collided})
+	if err := nodeA.Send("b", p2p.NewEnvelope(p2p.MessageTypeCompactBlock, payload, "")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	select {
+	case msg := <-got:
+		if msg.Block.Header.Hash() != block.Header.Hash() || msg.Block.Transactions[0].Hash != tx.Hash {
+			t.Fatalf("unexpected block: %+v", msg.Block)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("block was not refetched after a short ID collision")
+	}
+}
+
+func TestLeaderReannounceSurvivesGossipDedup(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addrA := ln.Addr().String()
+	ln.Close()
+
+	proposer, follower := types.Address{1}, types.Address{2}
+	validators := mockValidatorSet{proposer: proposer, size: 2}
+	newNode := func(cfg p2p.Config, id types.Address, accept func() bool) (*p2p.Server, *LeaderEngine) {
+		cfg.HandshakeTimeout = 2 * time.Second
+		srv := p2p.NewServer(cfg)
+		pool := mempool.New(10, nil)
+		var engine *LeaderEngine
+		deliver := func(_ p2p.PeerInfo, msg Message) {
+			if accept() {
+				engine.HandleMessage(msg)
+			}
+		}
+		engine = NewLeaderEngine(newChainManager(t), pool, newStateManager(t), validators, NewCompactRelay(srv, pool, deliver), id, time.Second, 5)
+		srv.RegisterHandler(p2p.MessageTypeConsensus, func(peer p2p.PeerInfo, payload []byte) {
+			var msg Message
+			if err := json.Unmarshal(payload, &msg); err == nil {
+				deliver(peer, msg)
+			}
+		})
+		if err := srv.Start(); err != nil {
+			t.Fatalf("start server: %v", err)
+		}
+		t.Cleanup(func() { srv.Close() })
+		return srv, engine
+	}
+
+	// The follower receives the first announcement but cannot accept it
+	// yet, as when it is still catching up.
+	var ready atomic.Bool
+	var dropped atomic.Int32
+	srvA, leader := newNode(p2p.Config{ListenAddr: addrA}, proposer, func() bool { return true })
+	newNode(p2p.Config{ListenAddr: "127.0.0.1:0", Seeds: []string{addrA}}, follower, func() bool {
+		if ready.Load() {
+			return true
+		}
+		dropped.Add(1)
+		return false
+	})
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("peers to connect", func() bool { return len(srvA.Peers()) == 1 })
+
+	if err := leader.tick(context.Background()); err != nil {
+		t.Fatalf("propose: %v", err)
+	}
+	waitFor("the first proposal to arrive", func() bool { return dropped.Load() > 0 })
+
+	ready.Store(true)
+	waitFor("a re-announced proposal to be committed", func() bool {
+		if err := leader.tick(context.Background()); err != nil {
+			t.Fatalf("re-announce: %v", err)
+		}
+		height, _ := leader.chain.Tip()
+		return height == 1
+	})
+}
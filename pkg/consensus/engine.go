@@ -2,7 +2,14 @@ package consensus
 
 import (
 	"context"
+	"fmt"
+	"log"
 
+	"github.com/0xphantomotr/gchain/pkg/chain"
+	"github.com/0xphantomotr/gchain/pkg/events"
+	"github.com/0xphantomotr/gchain/pkg/mempool"
+	"github.com/0xphantomotr/gchain/pkg/metrics"
+	"github.com/0xphantomotr/gchain/pkg/state"
 	"github.com/0xphantomotr/gchain/pkg/types"
 )
 
@@ -19,6 +26,13 @@ type Message struct {
 	Round  uint64
 	Type   MessageType
 	Block  *types.Block
+	// BlockHash names the block a vote is for; votes do not carry the
+	// block itself.
+	BlockHash types.Hash
+	// Attempt numbers re-announcements of one proposal, and the votes they
+	// prompt, so each has a distinct payload that gossip deduplication
+	// does not drop.
+	Attempt uint64 `json:",omitempty"`
 }
 
 // type Executor struct {
@@ -35,6 +49,14 @@ type ValidatorSet interface {
 	Has(addr types.Address) bool
 }
 
+// WeightedValidatorSet is a ValidatorSet whose members vote with unequal
+// power. Plain sets give every member one vote.
+type WeightedValidatorSet interface {
+	ValidatorSet
+	Power(addr types.Address) uint64
+	TotalPower() uint64
+}
+
 type Broadcaster interface {
 	Broadcast(msg Message) error
 }
@@ -43,3 +65,44 @@ type Engine interface {
 	Start(ctx context.Context) error
 	HandleMessage(msg Message)
 }
+
+// executableTxs returns up to limit pending transactions that succeed when
+// dry-run against state at height. Failing ones are dropped from the pool so
+// proposers do not keep picking them.
+func executableTxs(pool *mempool.Mempool, stateMgr *state.Manager, height uint64, limit int) []types.Transaction {
+	pending := pool.Pending(limit)
+	ok, failed, err := stateMgr.FilterExecutable(pending, height)
+	if err != nil {
+		log.Printf("consensus: dry-run pending transactions: %v", err)
+		return nil
+	}
+	for _, tx := range failed {
+		pool.Remove(tx.Hash)
+	}
+	return ok
+}
+
+// commitBlock executes block against state, appends it to the chain with its
+// receipts, prunes its transactions from the mempool and publishes it on bus.
+// Proposers only include transactions that pass executableTxs, but a block
+// from a peer may still carry failing ones: those are recorded as failed in
+// their receipts and leave state untouched rather than rejecting the block,
+// so that every node commits the same chain. Every engine finalizes blocks
+// through here so they stay interchangeable.
+func commitBlock(chainMgr *chain.Manager, stateMgr *state.Manager, pool *mempool.Mempool, bus *events.Bus, block *types.Block) error {
+	receipts, err := stateMgr.ExecuteBlock(*block)
+	if err != nil {
+		return fmt.Errorf("execute block: %w", err)
+	}
+	if err := chainMgr.AddBlockWithReceipts(block, receipts); err != nil {
+		return fmt.Errorf("add block: %w", err)
+	}
+
+	metrics.ObserveBlockCommit(block.Header.Height)
+
+	for _, tx := range block.Transactions {
+		pool.Remove(tx.Hash)
+	}
+	bus.PublishBlock(block)
+	return nil
+}
@@ -1,15 +1,17 @@
 package consensus
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/0xphantomotr/gchain/pkg/chain"
+	"github.com/0xphantomotr/gchain/pkg/events"
 	"github.com/0xphantomotr/gchain/pkg/mempool"
-	"github.com/0xphantomotr/gchain/pkg/metrics"
 	"github.com/0xphantomotr/gchain/pkg/state"
 	"github.com/0xphantomotr/gchain/pkg/types"
 )
@@ -19,13 +21,31 @@ type LeaderEngine struct {
 	chain       *chain.Manager
 	mempool     *mempool.Mempool
 	state       *state.Manager
+	events      *events.Bus
 	validators  ValidatorSet
 	broadcaster Broadcaster
+	endBlocker  EndBlocker
 
-	nodeID         types.Address
-	height         uint64
-	round          uint64
-	votes          map[types.Hash]int
+	pendingValidators []pendingValidatorSet
+
+	nodeID types.Address
+	height uint64
+	round  uint64
+	// votes holds the distinct validators that voted for each block at the
+	// current height, so a repeated vote is counted once.
+	votes map[types.Hash]map[types.Address]bool
+	// proposal is the block this node proposed at the current height; it is
+	// re-announced instead of proposing a second one, numbered by announces.
+	proposal  *types.Block
+	announces uint64
+	// voted locks this node to the first valid proposal at the current
+	// height; later proposals for other blocks get no vote. Votes name
+	// blocks by hash, so this is also the block committed on a quorum.
+	voted *types.Block
+	// lastVoters are the validators whose votes committed the previous
+	// block, sorted; the next proposal records them for the reward split.
+	lastVoters     []types.Address
+	now            func() time.Time
 	roundDuration  time.Duration
 	maxTxsPerBlock int
 }
@@ -40,12 +60,61 @@ func NewLeaderEngine(chainMgr *chain.Manager, mem *mempool.Mempool, stateMgr *st
 		broadcaster:    broadcaster,
 		nodeID:         nodeID,
 		height:         height + 1,
-		votes:          make(map[types.Hash]int),
+		votes:          make(map[types.Hash]map[types.Address]bool),
+		now:            time.Now,
 		roundDuration:  roundDuration,
 		maxTxsPerBlock: maxTxsPerBlock,
 	}
 }
 
+// SetEndBlocker installs a hook that may schedule validator set changes
+// after each committed block.
+func (e *LeaderEngine) SetEndBlocker(endBlocker EndBlocker) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.endBlocker = endBlocker
+}
+
+// ScheduleValidatorSet replaces the validator set once the engine reaches
+// activationHeight.
+func (e *LeaderEngine) ScheduleValidatorSet(set ValidatorSet, activationHeight uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.scheduleValidatorSetLocked(set, activationHeight)
+}
+
+func (e *LeaderEngine) scheduleValidatorSetLocked(set ValidatorSet, activationHeight uint64) {
+	e.pendingValidators = append(e.pendingValidators, pendingValidatorSet{set: set, height: activationHeight})
+	e.activateValidatorsLocked()
+}
+
+func (e *LeaderEngine) activateValidatorsLocked() {
+	remaining := e.pendingValidators[:0]
+	for _, pending := range e.pendingValidators {
+		if pending.height > e.height {
+			remaining = append(remaining, pending)
+			continue
+		}
+		e.validators = pending.set
+		log.Printf("consensus: validator set updated at height %d (size=%d)", e.height, e.validators.Size())
+	}
+	e.pendingValidators = remaining
+}
+
+// SetEventBus publishes every committed block to bus.
+func (e *LeaderEngine) SetEventBus(bus *events.Bus) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = bus
+}
+
+// SetClock replaces the clock used to timestamp proposed blocks.
+func (e *LeaderEngine) SetClock(now func() time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.now = now
+}
+
 func (e *LeaderEngine) Start(ctx context.Context) error {
 	ticker := time.NewTicker(e.roundDuration)
 	defer ticker.Stop()
@@ -62,35 +131,67 @@ func (e *LeaderEngine) Start(ctx context.Context) error {
 	}
 }
 
+func (e *LeaderEngine) tick(ctx context.Context) error {
+	return e.runRound(ctx)
+}
+
 func (e *LeaderEngine) runRound(ctx context.Context) error {
 	e.mu.Lock()
 	height := e.height
 	round := e.round
 	proposer := e.validators.Proposer(height, round)
+	proposal := e.proposal
+	if proposer == e.nodeID && proposal != nil {
+		e.announces++
+	}
+	attempt := e.announces
 	e.mu.Unlock()
 
 	if proposer != e.nodeID {
 		return nil
 	}
+	if proposal != nil && proposal.Header.Height == height {
+		// Never propose two blocks at one height; re-announce the first so
+		// validators that missed or could not yet accept it can still vote.
+		return e.broadcaster.Broadcast(Message{
+			From:    e.nodeID,
+			Height:  height,
+			Round:   round,
+			Type:    MessageTypeProposal,
+			Block:   proposal,
+			Attempt: attempt,
+		})
+	}
 
 	_, tipHash := e.chain.Tip()
 	return e.proposeBlock(ctx, height, round, tipHash)
 }
 
 func (e *LeaderEngine) proposeBlock(ctx context.Context, height uint64, round uint64, previousHash types.Hash) error {
-	txs := e.mempool.Pending(e.maxTxsPerBlock)
+	e.mu.Lock()
+	now := e.now
+	lastVoters := e.lastVoters
+	e.mu.Unlock()
+
+	txs := executableTxs(e.mempool, e.state, height, e.maxTxsPerBlock)
 	block := &types.Block{
 		Header: types.BlockHeader{
 			Height:       height,
 			PreviousHash: previousHash,
 			Proposer:     e.nodeID,
-			Timestamp:    time.Now(),
+			Timestamp:    now(),
 			StateRoot:    types.Hash{},
+			LastVoters:   lastVoters,
 		},
 		Transactions: txs,
 	}
 	block.Header.TxRoot = block.CalculateTxRoot()
 
+	e.mu.Lock()
+	e.proposal = block
+	e.voted = block
+	e.mu.Unlock()
+
 	msg := Message{
 		From:   e.nodeID,
 		Height: height,
@@ -103,11 +204,11 @@ func (e *LeaderEngine) proposeBlock(ctx context.Context, height uint64, round ui
 	}
 
 	e.HandleMessage(Message{
-		From:   e.nodeID,
-		Height: height,
-		Round:  round,
-		Type:   MessageTypeVote,
-		Block:  block,
+		From:      e.nodeID,
+		Height:    height,
+		Round:     round,
+		Type:      MessageTypeVote,
+		BlockHash: block.Header.Hash(),
 	})
 
 	return nil
@@ -117,66 +218,106 @@ func (e *LeaderEngine) HandleMessage(msg Message) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	if msg.Block == nil || msg.Height != e.height {
+	if msg.Height != e.height {
 		return
 	}
 
 	switch msg.Type {
 	case MessageTypeProposal:
+		if msg.Block == nil {
+			return
+		}
 		expected := e.validators.Proposer(msg.Height, msg.Round)
 		if expected != msg.From {
 			return
 		}
-		if err := e.validateBlock(msg.Block); err != nil {
+		hash := msg.Block.Header.Hash()
+		if e.voted != nil && e.voted.Header.Hash() != hash {
+			// Already voted for a different block at this height.
 			return
 		}
-		e.broadcastVoteLocked(msg.Block, msg.Height, msg.Round)
-		e.applyVoteLocked(msg.Block)
+		if e.voted == nil {
+			if err := e.validateBlock(msg.Block); err != nil {
+				return
+			}
+			e.voted = msg.Block
+		}
+		e.broadcastVoteLocked(hash, msg.Height, msg.Round, msg.Attempt)
+		// The proposal doubles as the proposer's vote.
+		e.applyVoteLocked(msg.From, hash)
+		e.applyVoteLocked(e.nodeID, hash)
 	case MessageTypeVote:
-		e.applyVoteLocked(msg.Block)
+		// Only members of the current set may vote.
+		if !e.validators.Has(msg.From) {
+			return
+		}
+		hash := msg.BlockHash
+		if msg.Block != nil {
+			hash = msg.Block.Header.Hash()
+		}
+		e.applyVoteLocked(msg.From, hash)
 	}
 }
 
-func (e *LeaderEngine) broadcastVoteLocked(block *types.Block, height, round uint64) {
+func (e *LeaderEngine) broadcastVoteLocked(hash types.Hash, height, round, attempt uint64) {
 	msg := Message{
-		From:   e.nodeID,
-		Height: height,
-		Round:  round,
-		Type:   MessageTypeVote,
-		Block:  block,
+		From:      e.nodeID,
+		Height:    height,
+		Round:     round,
+		Type:      MessageTypeVote,
+		BlockHash: hash,
+		Attempt:   attempt,
 	}
 	if err := e.broadcaster.Broadcast(msg); err != nil {
 		log.Printf("broadcast vote error: %v", err)
 	}
 }
 
-func (e *LeaderEngine) applyVoteLocked(block *types.Block) {
-	hash := block.Header.Hash()
-	e.votes[hash]++
-	if e.votes[hash] >= e.quorumThreshold() {
-		e.commitBlockLocked(block)
+// applyVoteLocked records voter's vote for the block with hash at the
+// current height. The block is committed once it has a quorum and this node
+// has voted for it too; votes that arrive before the proposal are counted
+// when it does.
+func (e *LeaderEngine) applyVoteLocked(voter types.Address, hash types.Hash) {
+	voters, ok := e.votes[hash]
+	if !ok {
+		voters = make(map[types.Address]bool)
+		e.votes[hash] = voters
+	}
+	voters[voter] = true
+	if e.voted != nil && e.voted.Header.Hash() == hash && e.hasQuorumLocked(voters) {
+		e.commitBlockLocked(e.voted)
 	}
 }
 
 func (e *LeaderEngine) commitBlockLocked(block *types.Block) {
-	if err := e.state.ApplyBlock(*block); err != nil {
-		log.Printf("commit apply block error: %v", err)
-		return
-	}
-	if err := e.chain.AddBlock(block); err != nil {
-		log.Printf("commit add block error: %v", err)
+	if err := commitBlock(e.chain, e.state, e.mempool, e.events, block); err != nil {
+		log.Printf("commit block error: %v", err)
 		return
 	}
 
-	metrics.ObserveBlockCommit(block.Header.Height)
-
-	for _, tx := range block.Transactions {
-		e.mempool.Remove(tx.Hash)
+	e.lastVoters = nil
+	for voter := range e.votes[block.Header.Hash()] {
+		if e.validators.Has(voter) {
+			e.lastVoters = append(e.lastVoters, voter)
+		}
 	}
+	sort.Slice(e.lastVoters, func(i, j int) bool {
+		return bytes.Compare(e.lastVoters[i][:], e.lastVoters[j][:]) < 0
+	})
 
 	e.height = block.Header.Height + 1
 	e.round = 0
-	e.votes = make(map[types.Hash]int)
+	e.votes = make(map[types.Hash]map[types.Address]bool)
+	e.proposal = nil
+	e.announces = 0
+	e.voted = nil
+
+	if e.endBlocker != nil {
+		if set, activation, ok := e.endBlocker.EndBlock(block); ok {
+			e.pendingValidators = append(e.pendingValidators, pendingValidatorSet{set: set, height: activation})
+		}
+	}
+	e.activateValidatorsLocked()
 }
 
 func (e *LeaderEngine) validateBlock(block *types.Block) error {
@@ -187,9 +328,30 @@ func (e *LeaderEngine) validateBlock(block *types.Block) error {
 	if block.Header.PreviousHash != tipHash {
 		return fmt.Errorf("previous hash mismatch")
 	}
+	for i, voter := range block.Header.LastVoters {
+		if i > 0 && bytes.Compare(block.Header.LastVoters[i-1][:], voter[:]) >= 0 {
+			return fmt.Errorf("last voters not sorted")
+		}
+	}
 	return nil
 }
 
-func (e *LeaderEngine) quorumThreshold() int {
-	return e.validators.Size()/2 + 1
+// hasQuorumLocked reports whether voters hold more than half of the voting
+// power, weighted by stake when the validator set is weighted.
+func (e *LeaderEngine) hasQuorumLocked(voters map[types.Address]bool) bool {
+	var power, total uint64
+	if weighted, ok := e.validators.(WeightedValidatorSet); ok {
+		for voter := range voters {
+			power += weighted.Power(voter)
+		}
+		total = weighted.TotalPower()
+	} else {
+		for voter := range voters {
+			if e.validators.Has(voter) {
+				power++
+			}
+		}
+		total = uint64(e.validators.Size())
+	}
+	return power > total/2
 }
@@ -92,6 +92,40 @@ func TestLeaderEngineProposesAndCommits(t *testing.T) {
 	}
 }
 
+func TestLeaderProposesOnlyExecutableTxs(t *testing.T) {
+	chainMgr := newChainManager(t)
+	stateMgr := newStateManager(t)
+	pool := mempool.New(10, nil)
+
+	sender := types.Address{1}
+	if err := stateMgr.SeedAccount(sender, 10, 0); err != nil {
+		t.Fatalf("seed account: %v", err)
+	}
+	good := types.Transaction{From: sender, To: types.Address{2}, Amount: 5, Timestamp: time.Unix(0, 1)}
+	broke := types.Transaction{From: sender, To: types.Address{2}, Amount: 50, Nonce: 1, Timestamp: time.Unix(0, 2)}
+	for _, tx := range []types.Transaction{good, broke} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatalf("add tx: %v", err)
+		}
+	}
+
+	engine := NewLeaderEngine(chainMgr, pool, stateMgr, mockValidatorSet{proposer: sender, size: 1}, &mockBroadcaster{}, sender, time.Second, 5)
+	if err := engine.proposeBlock(context.Background(), engine.height, engine.round, types.Hash{}); err != nil {
+		t.Fatalf("propose block: %v", err)
+	}
+
+	block, err := chainMgr.GetBlockByHeight(1)
+	if err != nil {
+		t.Fatalf("get block: %v", err)
+	}
+	if len(block.Transactions) != 1 || block.Transactions[0].Hash != good.CalculateHash() {
+		t.Fatalf("expected only the fundable tx, got %+v", block.Transactions)
+	}
+	if pool.Size() != 0 {
+		t.Fatalf("expected the failing tx dropped from the pool, got %d pooled", pool.Size())
+	}
+}
+
 func TestFollowerVotesAndCommitsOnQuorum(t *testing.T) {
 	chainMgr := newChainManager(t)
 	stateMgr := newStateManager(t)
@@ -142,3 +176,154 @@ func TestFollowerVotesAndCommitsOnQuorum(t *testing.T) {
 		t.Fatalf("expected chain height 1 after quorum, got %d", height)
 	}
 }
+
+// memberSet is a fixed validator set with a single proposer.
+type memberSet struct {
+	proposer types.Address
+	members  []types.Address
+}
+
+func (m memberSet) Proposer(height, round uint64) types.Address { return m.proposer }
+func (m memberSet) Size() int                                   { return len(m.members) }
+func (m memberSet) Has(addr types.Address) bool {
+	for _, member := range m.members {
+		if member == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func countVotes(b *mockBroadcaster) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	votes := 0
+	for _, msg := range b.messages {
+		if msg.Type == MessageTypeVote {
+			votes++
+		}
+	}
+	return votes
+}
+
+func testProposal(engine *LeaderEngine, proposer types.Address, ts int64) *types.Block {
+	block := &types.Block{Header: types.BlockHeader{Height: engine.height, Proposer: proposer, Timestamp: time.Unix(ts, 0)}}
+	block.Header.TxRoot = block.CalculateTxRoot()
+	return block
+}
+
+func TestLeaderCountsEachMemberVoteOnce(t *testing.T) {
+	proposer, self, other := types.Address{1}, types.Address{2}, types.Address{3}
+	set := memberSet{proposer: proposer, members: []types.Address{proposer, self, other, {4}}}
+	chainMgr := newChainManager(t)
+	engine := NewLeaderEngine(chainMgr, mempool.New(10, nil), newStateManager(t), set, &mockBroadcaster{}, self, time.Second, 5)
+
+	block := testProposal(engine, proposer, 1)
+	engine.HandleMessage(Message{From: proposer, Height: 1, Type: MessageTypeProposal, Block: block})
+	// The proposer and this node make two of four; neither a repeated vote
+	// nor a vote from outside the set may supply the third.
+	engine.HandleMessage(Message{From: proposer, Height: 1, Type: MessageTypeVote, BlockHash: block.Header.Hash()})
+	engine.HandleMessage(Message{From: types.Address{9}, Height: 1, Type: MessageTypeVote, BlockHash: block.Header.Hash()})
+	if height, _ := chainMgr.Tip(); height != 0 {
+		t.Fatalf("committed without a quorum of distinct members at height %d", height)
+	}
+
+	engine.HandleMessage(Message{From: other, Height: 1, Type: MessageTypeVote, BlockHash: block.Header.Hash()})
+	if height, _ := chainMgr.Tip(); height != 1 {
+		t.Fatalf("expected commit once three members voted, got height %d", height)
+	}
+}
+
+func TestLeaderVotesForOneProposalPerHeight(t *testing.T) {
+	proposer, self := types.Address{1}, types.Address{2}
+	set := memberSet{proposer: proposer, members: []types.Address{proposer, self, {3}, {4}}}
+	broadcaster := &mockBroadcaster{}
+	engine := NewLeaderEngine(newChainManager(t), mempool.New(10, nil), newStateManager(t), set, broadcaster, self, time.Second, 5)
+
+	first := testProposal(engine, proposer, 1)
+	engine.HandleMessage(Message{From: proposer, Height: 1, Type: MessageTypeProposal, Block: first})
+	engine.HandleMessage(Message{From: proposer, Height: 1, Type: MessageTypeProposal, Block: testProposal(engine, proposer, 2)})
+	if votes := countVotes(broadcaster); votes != 1 {
+		t.Fatalf("expected a single vote at height 1, got %d", votes)
+	}
+	// A re-announce of the locked block is voted for again.
+	engine.HandleMessage(Message{From: proposer, Height: 1, Type: MessageTypeProposal, Block: first})
+	if last, _ := broadcaster.Last(); last.Type != MessageTypeVote || last.Block != nil || last.BlockHash != first.Header.Hash() {
+		t.Fatalf("expected a vote for the locked block, got %#v", last)
+	}
+}
+
+func TestLeaderReannouncesInsteadOfReproposing(t *testing.T) {
+	self := types.Address{1}
+	set := memberSet{proposer: self, members: []types.Address{self, {2}}}
+	broadcaster := &mockBroadcaster{}
+	engine := NewLeaderEngine(newChainManager(t), mempool.New(10, nil), newStateManager(t), set, broadcaster, self, time.Second, 5)
+
+	for i := 0; i < 3; i++ {
+		if err := engine.tick(context.Background()); err != nil {
+			t.Fatalf("tick: %v", err)
+		}
+	}
+	broadcaster.mu.Lock()
+	defer broadcaster.mu.Unlock()
+	var proposals []types.Hash
+	for _, msg := range broadcaster.messages {
+		if msg.Type == MessageTypeProposal {
+			proposals = append(proposals, msg.Block.Header.Hash())
+		}
+	}
+	if len(proposals) != 3 {
+		t.Fatalf("expected a proposal each round, got %d", len(proposals))
+	}
+	for _, hash := range proposals[1:] {
+		if hash != proposals[0] {
+			t.Fatal("proposer built a second block at the same height")
+		}
+	}
+}
+
+func TestLeaderCountsVotesReceivedBeforeProposal(t *testing.T) {
+	proposer, self, other := types.Address{1}, types.Address{2}, types.Address{3}
+	set := memberSet{proposer: proposer, members: []types.Address{proposer, self, other, {4}}}
+	chainMgr := newChainManager(t)
+	engine := NewLeaderEngine(chainMgr, mempool.New(10, nil), newStateManager(t), set, &mockBroadcaster{}, self, time.Second, 5)
+
+	block := testProposal(engine, proposer, 1)
+	engine.HandleMessage(Message{From: other, Height: 1, Type: MessageTypeVote, BlockHash: block.Header.Hash()})
+	if height, _ := chainMgr.Tip(); height != 0 {
+		t.Fatal("committed a block this node has not seen")
+	}
+	engine.HandleMessage(Message{From: proposer, Height: 1, Type: MessageTypeProposal, Block: block})
+	if height, _ := chainMgr.Tip(); height != 1 {
+		t.Fatalf("expected the early vote to complete the quorum, got height %d", height)
+	}
+}
+
+func TestLeaderRecordsLastVotersInNextProposal(t *testing.T) {
+	self, other, idle := types.Address{1}, types.Address{2}, types.Address{3}
+	set := memberSet{proposer: self, members: []types.Address{self, other, idle}}
+	chainMgr := newChainManager(t)
+	engine := NewLeaderEngine(chainMgr, mempool.New(10, nil), newStateManager(t), set, &mockBroadcaster{}, self, time.Second, 5)
+
+	if err := engine.proposeBlock(context.Background(), 1, 0, types.Hash{}); err != nil {
+		t.Fatalf("propose block: %v", err)
+	}
+	engine.mu.Lock()
+	block := engine.proposal
+	engine.mu.Unlock()
+	engine.HandleMessage(Message{From: other, Height: 1, Type: MessageTypeVote, BlockHash: block.Header.Hash()})
+	if height, _ := chainMgr.Tip(); height != 1 {
+		t.Fatalf("expected block 1 committed, got height %d", height)
+	}
+
+	_, tipHash := chainMgr.Tip()
+	if err := engine.proposeBlock(context.Background(), 2, 0, tipHash); err != nil {
+		t.Fatalf("propose block: %v", err)
+	}
+	engine.mu.Lock()
+	got := engine.proposal.Header.LastVoters
+	engine.mu.Unlock()
+	if len(got) != 2 || got[0] != self || got[1] != other {
+		t.Fatalf("expected last voters [%s %s], got %v", self, other, got)
+	}
+}
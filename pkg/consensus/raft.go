@@ -0,0 +1,776 @@
+package consensus
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/chain"
+	"github.com/0xphantomotr/gchain/pkg/events"
+	"github.com/0xphantomotr/gchain/pkg/mempool"
+	"github.com/0xphantomotr/gchain/pkg/p2p"
+	"github.com/0xphantomotr/gchain/pkg/state"
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+var raftHardStateKey = []byte("raft:hardstate")
+
+// defaultSnapshotChunkSize leaves headroom under p2p.DefaultMaxMessageSize
+// for the envelope and the JSON around the blocks.
+const defaultSnapshotChunkSize = 1 << 20
+
+type RaftConfig struct {
+	TickInterval time.Duration
+	// ElectionTicks is the minimum number of ticks without hearing from a
+	// leader before a follower campaigns; the actual timeout is randomized in
+	// [ElectionTicks, 2*ElectionTicks).
+	ElectionTicks  int
+	HeartbeatTicks int
+	// BlockTicks is how often, in ticks, the leader proposes a block.
+	BlockTicks int
+	// SnapshotThreshold is how many applied entries are kept in the log
+	// before it is compacted. Followers that fall behind the compacted log
+	// are caught up with a snapshot of blocks read from the chain.
+	SnapshotThreshold uint64
+	// SnapshotChunkSize caps the encoded blocks in one snapshot message,
+	// which must stay under the transport's frame limit. Larger snapshots
+	// are streamed in chunks, each acknowledged before the next is sent.
+	SnapshotChunkSize int
+	MaxTxsPerBlock    int
+	// Seed makes election timeouts reproducible.
+	Seed int64
+	// Store, when set, persists the current term, vote and unapplied log so
+	// a restarted node never votes twice in a term or forgets acknowledged
+	// entries.
+	Store state.Store
+}
+
+type raftRole uint8
+
+const (
+	raftFollower raftRole = iota
+	raftCandidate
+	raftLeader
+)
+
+type raftMsgType uint8
+
+const (
+	raftMsgVote raftMsgType = iota
+	raftMsgVoteResp
+	raftMsgAppend
+	raftMsgAppendResp
+	raftMsgSnapshot
+)
+
+// raftEntry carries one proposed block. Its log index is the block height.
+type raftEntry struct {
+	Term  uint64       `json:"term"`
+	Block *types.Block `json:"block"`
+}
+
+type raftMessage struct {
+	Type raftMsgType   `json:"type"`
+	From types.Address `json:"from"`
+	To   types.Address `json:"to"`
+	Term uint64        `json:"term"`
+	// LogIndex and LogTerm are the candidate's last entry for votes, the
+	// entry preceding Entries for appends, and the last included entry for
+	// snapshots, whose Blocks may cover only a prefix of it.
+	LogIndex uint64         `json:"log_index"`
+	LogTerm  uint64         `json:"log_term"`
+	Entries  []raftEntry    `json:"entries,omitempty"`
+	Blocks   []*types.Block `json:"blocks,omitempty"`
+	Commit   uint64         `json:"commit"`
+	Reject   bool           `json:"reject"`
+	// Match is the follower's last matching index on success, or a hint of
+	// its last index on rejection.
+	Match uint64 `json:"match"`
+}
+
+type raftHardState struct {
+	Term         uint64        `json:"term"`
+	Vote         types.Address `json:"vote"`
+	SnapshotTerm uint64        `json:"snapshot_term"`
+	Entries      []raftEntry   `json:"entries"`
+}
+
+// RaftEngine orders blocks with Raft. It tolerates crashed members but not
+// malicious ones, in exchange for single-round-trip finality: a block is
+// final as soon as a majority has stored it.
+type RaftEngine struct {
+	mu        sync.Mutex
+	chain     *chain.Manager
+	mempool   *mempool.Mempool
+	state     *state.Manager
+	events    *events.Bus
+	transport p2p.Transport
+	cfg       RaftConfig
+	rng       *rand.Rand
+	now       func() time.Time
+
+	id      types.Address
+	members []types.Address
+	// peers maps members to the transport peer they were last heard from,
+	// so replies and appends go point-to-point instead of being broadcast.
+	peers map[types.Address]string
+
+	role   raftRole
+	term   uint64
+	vote   types.Address
+	leader types.Address
+	votes  map[types.Address]bool
+
+	entries       []raftEntry
+	snapshotIndex uint64
+	snapshotTerm  uint64
+	commitIndex   uint64
+	nextIndex     map[types.Address]uint64
+	matchIndex    map[types.Address]uint64
+
+	electionElapsed  int
+	electionTimeout  int
+	heartbeatElapsed int
+	blockElapsed     int
+}
+
+func NewRaftEngine(chainMgr *chain.Manager, mem *mempool.Mempool, stateMgr *state.Manager, transport p2p.Transport, nodeID types.Address, members []types.Address, cfg RaftConfig) (*RaftEngine, error) {
+	if cfg.ElectionTicks <= 0 {
+		cfg.ElectionTicks = 10
+	}
+	if cfg.HeartbeatTicks <= 0 {
+		cfg.HeartbeatTicks = 1
+	}
+	if cfg.BlockTicks <= 0 {
+		cfg.BlockTicks = 1
+	}
+	if cfg.SnapshotChunkSize <= 0 {
+		cfg.SnapshotChunkSize = defaultSnapshotChunkSize
+	}
+	seed := cfg.Seed ^ int64(binary.BigEndian.Uint64(nodeID[:8]))
+
+	tip, _ := chainMgr.Tip()
+	e := &RaftEngine{
+		chain:         chainMgr,
+		mempool:       mem,
+		state:         stateMgr,
+		transport:     transport,
+		cfg:           cfg,
+		rng:           rand.New(rand.NewSource(seed)),
+		now:           time.Now,
+		id:            nodeID,
+		members:       append([]types.Address(nil), members...),
+		peers:         make(map[types.Address]string),
+		snapshotIndex: tip,
+		commitIndex:   tip,
+	}
+	if err := e.loadHardState(); err != nil {
+		return nil, err
+	}
+	e.becomeFollowerLocked(e.term, types.Address{})
+
+	transport.RegisterHandler(p2p.MessageTypeRaft, e.handleEnvelope)
+	return e, nil
+}
+
+// SetEventBus publishes every committed block to bus.
+func (e *RaftEngine) SetEventBus(bus *events.Bus) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = bus
+}
+
+// SetClock replaces the clock used to timestamp proposed blocks.
+func (e *RaftEngine) SetClock(now func() time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.now = now
+}
+
+func (e *RaftEngine) Start(ctx context.Context) error {
+	ticker := time.NewTicker(e.cfg.TickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := e.tick(ctx); err != nil {
+				log.Printf("raft tick error: %v", err)
+			}
+		}
+	}
+}
+
+// HandleMessage is a no-op: Raft traffic arrives on the transport as
+// p2p.MessageTypeRaft envelopes rather than as consensus messages.
+func (e *RaftEngine) HandleMessage(msg Message) {}
+
+// Leader returns the current leader, if known.
+func (e *RaftEngine) Leader() (types.Address, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader, e.leader != (types.Address{})
+}
+
+func (e *RaftEngine) tick(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.role != raftLeader {
+		e.electionElapsed++
+		if e.electionElapsed >= e.electionTimeout {
+			e.campaignLocked()
+		}
+		return nil
+	}
+
+	e.heartbeatElapsed++
+	if e.heartbeatElapsed >= e.cfg.HeartbeatTicks {
+		e.heartbeatElapsed = 0
+		e.broadcastAppendLocked()
+	}
+	e.blockElapsed++
+	if e.blockElapsed >= e.cfg.BlockTicks {
+		e.blockElapsed = 0
+		return e.proposeLocked()
+	}
+	return nil
+}
+
+func (e *RaftEngine) handleEnvelope(peer p2p.PeerInfo, payload []byte) {
+	var msg raftMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		log.Printf("raft: invalid payload from %s: %v", peer.ID, err)
+		e.transport.ReportPeer(peer.ID, p2p.PenaltyBadPayload, "invalid raft payload")
+		return
+	}
+	e.step(peer.ID, msg)
+}
+
+func (e *RaftEngine) step(peerID string, msg raftMessage) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if msg.To != e.id || !e.isMember(msg.From) {
+		return
+	}
+	if peerID != "" {
+		e.peers[msg.From] = peerID
+	}
+	if msg.Term > e.term {
+		leader := types.Address{}
+		if msg.Type == raftMsgAppend || msg.Type == raftMsgSnapshot {
+			leader = msg.From
+		}
+		e.becomeFollowerLocked(msg.Term, leader)
+	}
+	if msg.Term < e.term {
+		switch msg.Type {
+		case raftMsgVote:
+			e.sendLocked(raftMessage{Type: raftMsgVoteResp, To: msg.From, Reject: true})
+		case raftMsgAppend, raftMsgSnapshot:
+			e.sendLocked(raftMessage{Type: raftMsgAppendResp, To: msg.From, Reject: true, Match: e.lastIndex()})
+		}
+		return
+	}
+
+	switch msg.Type {
+	case raftMsgVote:
+		e.handleVoteLocked(msg)
+	case raftMsgVoteResp:
+		e.handleVoteRespLocked(msg)
+	case raftMsgAppend:
+		e.handleAppendLocked(msg)
+	case raftMsgAppendResp:
+		e.handleAppendRespLocked(msg)
+	case raftMsgSnapshot:
+		e.handleSnapshotLocked(msg)
+	}
+}
+
+func (e *RaftEngine) campaignLocked() {
+	e.role = raftCandidate
+	e.term++
+	e.vote = e.id
+	e.leader = types.Address{}
+	e.votes = map[types.Address]bool{e.id: true}
+	e.resetElectionTimerLocked()
+	e.persistLocked()
+
+	if e.quorumReached(e.votes) {
+		e.becomeLeaderLocked()
+		return
+	}
+	for _, member := range e.members {
+		if member == e.id {
+			continue
+		}
+		e.sendLocked(raftMessage{
+			Type:     raftMsgVote,
+			To:       member,
+			LogIndex: e.lastIndex(),
+			LogTerm:  e.lastTerm(),
+		})
+	}
+}
+
+func (e *RaftEngine) becomeFollowerLocked(term uint64, leader types.Address) {
+	if term != e.term {
+		e.term = term
+		e.vote = types.Address{}
+		e.persistLocked()
+	}
+	e.role = raftFollower
+	e.leader = leader
+	e.resetElectionTimerLocked()
+}
+
+func (e *RaftEngine) becomeLeaderLocked() {
+	e.role = raftLeader
+	e.leader = e.id
+	e.heartbeatElapsed = 0
+	e.blockElapsed = 0
+	e.nextIndex = make(map[types.Address]uint64, len(e.members))
+	e.matchIndex = make(map[types.Address]uint64, len(e.members))
+	for _, member := range e.members {
+		e.nextIndex[member] = e.lastIndex() + 1
+	}
+	e.matchIndex[e.id] = e.lastIndex()
+	log.Printf("raft: %s elected leader for term %d", e.id.String()[:8], e.term)
+	e.broadcastAppendLocked()
+}
+
+func (e *RaftEngine) resetElectionTimerLocked() {
+	e.electionElapsed = 0
+	e.electionTimeout = e.cfg.ElectionTicks + e.rng.Intn(e.cfg.ElectionTicks)
+}
+
+func (e *RaftEngine) handleVoteLocked(msg raftMessage) {
+	upToDate := msg.LogTerm > e.lastTerm() || (msg.LogTerm == e.lastTerm() && msg.LogIndex >= e.lastIndex())
+	canVote := e.vote == (types.Address{}) || e.vote == msg.From
+	if !canVote || !upToDate {
+		e.sendLocked(raftMessage{Type: raftMsgVoteResp, To: msg.From, Reject: true})
+		return
+	}
+	e.vote = msg.From
+	e.resetElectionTimerLocked()
+	e.persistLocked()
+	e.sendLocked(raftMessage{Type: raftMsgVoteResp, To: msg.From})
+}
+
+func (e *RaftEngine) handleVoteRespLocked(msg raftMessage) {
+	if e.role != raftCandidate {
+		return
+	}
+	e.votes[msg.From] = !msg.Reject
+	if e.quorumReached(e.votes) {
+		e.becomeLeaderLocked()
+	}
+}
+
+func (e *RaftEngine) proposeLocked() error {
+	// Keep at most one block of the current term in flight so every proposal
+	// builds on a block the leader knows is valid.
+	if e.lastTerm() == e.term && e.lastIndex() > e.commitIndex {
+		return nil
+	}
+	prevHash, err := e.hashAt(e.lastIndex())
+	if err != nil {
+		return err
+	}
+
+	// State only reflects committed entries, so transactions can be dry-run
+	// only when nothing is pending ahead of this block.
+	height := e.lastIndex() + 1
+	var txs []types.Transaction
+	if e.lastIndex() == e.commitIndex {
+		txs = executableTxs(e.mempool, e.state, height, e.cfg.MaxTxsPerBlock)
+	} else {
+		txs = e.mempool.Pending(e.cfg.MaxTxsPerBlock)
+	}
+	block := &types.Block{
+		Header: types.BlockHeader{
+			Height:       height,
+			PreviousHash: prevHash,
+			Proposer:     e.id,
+			Timestamp:    e.now(),
+		},
+		Transactions: txs,
+	}
+	block.Header.TxRoot = block.CalculateTxRoot()
+
+	e.entries = append(e.entries, raftEntry{Term: e.term, Block: block})
+	e.matchIndex[e.id] = e.lastIndex()
+	e.persistLocked()
+	e.maybeCommitLocked()
+	e.broadcastAppendLocked()
+	return nil
+}
+
+func (e *RaftEngine) broadcastAppendLocked() {
+	for _, member := range e.members {
+		if member != e.id {
+			e.sendAppendLocked(member)
+		}
+	}
+}
+
+func (e *RaftEngine) sendAppendLocked(to types.Address) {
+	next := e.nextIndex[to]
+	if next <= e.snapshotIndex {
+		e.sendSnapshotLocked(to, next)
+		return
+	}
+	prev := next - 1
+	e.sendLocked(raftMessage{
+		Type:     raftMsgAppend,
+		To:       to,
+		LogIndex: prev,
+		LogTerm:  e.termAt(prev),
+		Entries:  e.entriesFrom(next),
+		Commit:   e.commitIndex,
+	})
+}
+
+func (e *RaftEngine) sendSnapshotLocked(to types.Address, from uint64) {
+	var (
+		blocks []*types.Block
+		size   int
+	)
+	for h := max(from, 1); h <= e.snapshotIndex; h++ {
+		block, err := e.chain.GetBlockByHeight(h)
+		if err != nil {
+			log.Printf("raft: load snapshot block %d: %v", h, err)
+			return
+		}
+		encoded, err := json.Marshal(block)
+		if err != nil {
+			log.Printf("raft: encode snapshot block %d: %v", h, err)
+			return
+		}
+		// Always send at least one block so a catch-up makes progress.
+		if len(blocks) > 0 && size+len(encoded) > e.cfg.SnapshotChunkSize {
+			break
+		}
+		blocks = append(blocks, block)
+		size += len(encoded)
+	}
+	e.sendLocked(raftMessage{
+		Type:     raftMsgSnapshot,
+		To:       to,
+		LogIndex: e.snapshotIndex,
+		LogTerm:  e.snapshotTerm,
+		Blocks:   blocks,
+		Commit:   e.commitIndex,
+	})
+}
+
+func (e *RaftEngine) handleAppendLocked(msg raftMessage) {
+	e.leader = msg.From
+	e.role = raftFollower
+	e.electionElapsed = 0
+
+	prev, entries := msg.LogIndex, msg.Entries
+	// Anything at or below our snapshot is already committed here.
+	for prev < e.snapshotIndex && len(entries) > 0 {
+		prev++
+		entries = entries[1:]
+	}
+	if prev < e.snapshotIndex {
+		e.sendLocked(raftMessage{Type: raftMsgAppendResp, To: msg.From, Match: e.snapshotIndex})
+		return
+	}
+	// Entries up to our snapshot are committed and therefore match; the
+	// snapshot term may be unknown after a restart without a Store.
+	if prev > e.lastIndex() || (prev > e.snapshotIndex && e.termAt(prev) != termOf(msg, prev)) {
+		e.sendLocked(raftMessage{Type: raftMsgAppendResp, To: msg.From, Reject: true, Match: min(e.lastIndex(), prev-1)})
+		return
+	}
+
+	for i, entry := range entries {
+		index := prev + uint64(i) + 1
+		if index <= e.lastIndex() {
+			if e.termAt(index) == entry.Term {
+				continue
+			}
+			e.entries = e.entries[:index-e.snapshotIndex-1]
+		}
+		e.entries = append(e.entries, entry)
+	}
+	e.persistLocked()
+
+	lastNew := prev + uint64(len(entries))
+	if msg.Commit > e.commitIndex {
+		e.commitIndex = min(msg.Commit, lastNew)
+		e.applyLocked()
+	}
+	e.sendLocked(raftMessage{Type: raftMsgAppendResp, To: msg.From, Match: lastNew})
+}
+
+// termOf returns the term the leader claims for prev, which is only carried
+// for the entry immediately before the batch.
+func termOf(msg raftMessage, prev uint64) uint64 {
+	if prev == msg.LogIndex {
+		return msg.LogTerm
+	}
+	return msg.Entries[prev-msg.LogIndex-1].Term
+}
+
+func (e *RaftEngine) handleAppendRespLocked(msg raftMessage) {
+	if e.role != raftLeader {
+		return
+	}
+	if msg.Reject {
+		e.nextIndex[msg.From] = max(min(e.nextIndex[msg.From]-1, msg.Match+1), 1)
+		e.sendAppendLocked(msg.From)
+		return
+	}
+	progressed := msg.Match > e.matchIndex[msg.From]
+	if progressed {
+		e.matchIndex[msg.From] = msg.Match
+	}
+	e.nextIndex[msg.From] = max(e.nextIndex[msg.From], msg.Match+1)
+	// Stream the rest of a chunked snapshot without waiting for a
+	// heartbeat; stale or duplicate acks leave that to the next one.
+	if next := e.nextIndex[msg.From]; progressed && next <= e.snapshotIndex {
+		e.sendSnapshotLocked(msg.From, next)
+	}
+	e.maybeCommitLocked()
+}
+
+func (e *RaftEngine) handleSnapshotLocked(msg raftMessage) {
+	e.leader = msg.From
+	e.role = raftFollower
+	e.electionElapsed = 0
+
+	// A chunk starting past our tip was cut from a next index guessed from
+	// uncommitted entries; ask for the snapshot from our tip instead.
+	if tip, _ := e.chain.Tip(); len(msg.Blocks) > 0 && msg.Blocks[0].Header.Height > tip+1 {
+		e.sendLocked(raftMessage{Type: raftMsgAppendResp, To: msg.From, Reject: true, Match: tip})
+		return
+	}
+	for _, block := range msg.Blocks {
+		tip, _ := e.chain.Tip()
+		if block.Header.Height != tip+1 {
+			continue
+		}
+		if err := commitBlock(e.chain, e.state, e.mempool, e.events, block); err != nil {
+			log.Printf("raft: apply snapshot block %d: %v", block.Header.Height, err)
+			break
+		}
+	}
+
+	tip, _ := e.chain.Tip()
+	if tip >= msg.LogIndex {
+		e.entries = e.entriesFrom(msg.LogIndex + 1)
+		e.snapshotIndex = msg.LogIndex
+		e.snapshotTerm = msg.LogTerm
+		e.commitIndex = max(e.commitIndex, msg.LogIndex)
+		e.persistLocked()
+	}
+	// After a partial chunk the applied blocks are committed and so match;
+	// acking the tip makes the leader send the next chunk from there.
+	e.sendLocked(raftMessage{Type: raftMsgAppendResp, To: msg.From, Match: max(e.snapshotIndex, tip)})
+}
+
+func (e *RaftEngine) maybeCommitLocked() {
+	for index := e.lastIndex(); index > e.commitIndex; index-- {
+		// Only entries from the current term are committed by counting
+		// replicas; earlier ones commit indirectly.
+		if e.termAt(index) != e.term {
+			break
+		}
+		acks := 0
+		for _, member := range e.members {
+			if e.matchIndex[member] >= index {
+				acks++
+			}
+		}
+		if acks >= e.quorum() {
+			e.commitIndex = index
+			e.applyLocked()
+			return
+		}
+	}
+}
+
+func (e *RaftEngine) applyLocked() {
+	tip, _ := e.chain.Tip()
+	for index := tip + 1; index <= e.commitIndex; index++ {
+		entry, ok := e.entryAt(index)
+		if !ok {
+			return
+		}
+		if err := commitBlock(e.chain, e.state, e.mempool, e.events, entry.Block); err != nil {
+			log.Printf("raft: apply block %d: %v", index, err)
+			return
+		}
+	}
+	e.compactLocked()
+	e.persistLocked()
+}
+
+// compactLocked drops applied entries once more than SnapshotThreshold of
+// them have accumulated. The blocks themselves stay in the chain store, which
+// is what followers are caught up from.
+func (e *RaftEngine) compactLocked() {
+	if e.cfg.SnapshotThreshold == 0 {
+		return
+	}
+	applied, _ := e.chain.Tip()
+	if applied < e.snapshotIndex+e.cfg.SnapshotThreshold {
+		return
+	}
+	e.snapshotTerm = e.termAt(applied)
+	e.entries = e.entriesFrom(applied + 1)
+	e.snapshotIndex = applied
+}
+
+func (e *RaftEngine) sendLocked(msg raftMessage) {
+	msg.From = e.id
+	if msg.Term == 0 {
+		msg.Term = e.term
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("raft: marshal message: %v", err)
+		return
+	}
+	env := p2p.NewEnvelope(p2p.MessageTypeRaft, payload, "")
+	// Until a member has been heard from, its peer is unknown; receivers
+	// drop messages not addressed to them.
+	if peerID, ok := e.peers[msg.To]; ok {
+		if err := e.transport.Send(peerID, env); err == nil {
+			return
+		}
+		delete(e.peers, msg.To)
+	}
+	e.transport.Broadcast(env)
+}
+
+func (e *RaftEngine) lastIndex() uint64 {
+	return e.snapshotIndex + uint64(len(e.entries))
+}
+
+func (e *RaftEngine) lastTerm() uint64 {
+	return e.termAt(e.lastIndex())
+}
+
+func (e *RaftEngine) termAt(index uint64) uint64 {
+	if index == e.snapshotIndex {
+		return e.snapshotTerm
+	}
+	if entry, ok := e.entryAt(index); ok {
+		return entry.Term
+	}
+	return 0
+}
+
+func (e *RaftEngine) entryAt(index uint64) (raftEntry, bool) {
+	if index <= e.snapshotIndex || index > e.lastIndex() {
+		return raftEntry{}, false
+	}
+	return e.entries[index-e.snapshotIndex-1], true
+}
+
+func (e *RaftEngine) entriesFrom(index uint64) []raftEntry {
+	if index <= e.snapshotIndex {
+		index = e.snapshotIndex + 1
+	}
+	if index > e.lastIndex() {
+		return nil
+	}
+	return append([]raftEntry(nil), e.entries[index-e.snapshotIndex-1:]...)
+}
+
+func (e *RaftEngine) hashAt(index uint64) (types.Hash, error) {
+	if entry, ok := e.entryAt(index); ok {
+		return entry.Block.Header.Hash(), nil
+	}
+	if index == 0 {
+		return types.Hash{}, nil
+	}
+	block, err := e.chain.GetBlockByHeight(index)
+	if err != nil {
+		return types.Hash{}, fmt.Errorf("load block %d: %w", index, err)
+	}
+	return block.Header.Hash(), nil
+}
+
+func (e *RaftEngine) isMember(addr types.Address) bool {
+	for _, member := range e.members {
+		if member == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *RaftEngine) quorum() int {
+	return len(e.members)/2 + 1
+}
+
+func (e *RaftEngine) quorumReached(votes map[types.Address]bool) bool {
+	granted := 0
+	for _, ok := range votes {
+		if ok {
+			granted++
+		}
+	}
+	return granted >= e.quorum()
+}
+
+func (e *RaftEngine) loadHardState() error {
+	if e.cfg.Store == nil {
+		return nil
+	}
+	data, err := e.cfg.Store.Get(raftHardStateKey)
+	if errors.Is(err, state.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("load raft state: %w", err)
+	}
+	var hs raftHardState
+	if err := json.Unmarshal(data, &hs); err != nil {
+		return fmt.Errorf("decode raft state: %w", err)
+	}
+	e.term = hs.Term
+	e.vote = hs.Vote
+	e.snapshotTerm = hs.SnapshotTerm
+	for _, entry := range hs.Entries {
+		if entry.Block.Header.Height == e.lastIndex()+1 {
+			e.entries = append(e.entries, entry)
+		}
+	}
+	return nil
+}
+
+func (e *RaftEngine) persistLocked() {
+	if e.cfg.Store == nil {
+		return
+	}
+	applied, _ := e.chain.Tip()
+	hs := raftHardState{
+		Term:         e.term,
+		Vote:         e.vote,
+		SnapshotTerm: e.termAt(applied),
+		Entries:      e.entriesFrom(applied + 1),
+	}
+	payload, err := json.Marshal(hs)
+	if err != nil {
+		log.Printf("raft: marshal hard state: %v", err)
+		return
+	}
+	if err := e.cfg.Store.Set(raftHardStateKey, payload); err != nil {
+		log.Printf("raft: persist hard state: %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+package consensus
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/mempool"
+	"github.com/0xphantomotr/gchain/pkg/p2p"
+	"github.com/0xphantomotr/gchain/pkg/state"
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+// queueNet buffers broadcasts so tests can deliver them outside the sender's
+// lock, one hop at a time.
+type queueNet struct {
+	mu      sync.Mutex
+	nodes   map[string]*queueTransport
+	pending []queuedEnvelope
+	down    map[string]bool
+	// broadcasts counts envelopes sent without a target.
+	broadcasts int
+	// inspect, when set, sees every envelope before it is delivered.
+	inspect func(queuedEnvelope)
+}
+
+type queuedEnvelope struct {
+	from string
+	to   string // empty for broadcasts
+	env  p2p.Envelope
+}
+
+type queueTransport struct {
+	net      *queueNet
+	id       string
+	handlers map[p2p.MessageType]p2p.HandlerFunc
+}
+
+func newQueueNet() *queueNet {
+	return &queueNet{nodes: make(map[string]*queueTransport), down: make(map[string]bool)}
+}
+
+func (n *queueNet) join(id string) *queueTransport {
+	t := &queueTransport{net: n, id: id, handlers: make(map[p2p.MessageType]p2p.HandlerFunc)}
+	n.nodes[id] = t
+	return t
+}
+
+func (n *queueNet) deliverAll() {
+	for {
+		n.mu.Lock()
+		batch := n.pending
+		n.pending = nil
+		n.mu.Unlock()
+		if len(batch) == 0 {
+			return
+		}
+		for _, q := range batch {
+			if n.down[q.from] {
+				continue
+			}
+			if n.inspect != nil {
+				n.inspect(q)
+			}
+			for id, node := range n.nodes {
+				if id == q.from || n.down[id] || (q.to != "" && id != q.to) {
+					continue
+				}
+				if h := node.handlers[q.env.Type]; h != nil {
+					h(p2p.PeerInfo{ID: q.from}, q.env.Payload)
+				}
+			}
+		}
+	}
+}
+
+func (t *queueTransport) Start() error { return nil }
+func (t *queueTransport) Close() error { return nil }
+func (t *queueTransport) Broadcast(env p2p.Envelope) {
+	t.net.mu.Lock()
+	defer t.net.mu.Unlock()
+	t.net.broadcasts++
+	t.net.pending = append(t.net.pending, queuedEnvelope{from: t.id, env: env.Clone()})
+}
+func (t *queueTransport) BroadcastExcept(peerID string, env p2p.Envelope) { t.Broadcast(env) }
+func (t *queueTransport) RegisterHandler(msgType p2p.MessageType, handler p2p.HandlerFunc) {
+	t.handlers[msgType] = handler
+}
+func (t *queueTransport) ReportPeer(peerID string, penalty int, reason string) {}
+func (t *queueTransport) Send(peerID string, env p2p.Envelope) error {
+	t.net.mu.Lock()
+	defer t.net.mu.Unlock()
+	t.net.pending = append(t.net.pending, queuedEnvelope{from: t.id, to: peerID, env: env.Clone()})
+	return nil
+}
+func (t *queueTransport) Request(ctx context.Context, peerID string, env p2p.Envelope) (p2p.Envelope, error) {
+	return p2p.Envelope{}, context.Canceled
+}
+func (t *queueTransport) RegisterRequestHandler(msgType p2p.MessageType, handler p2p.RequestHandlerFunc) {
+}
+
+func newRaftCluster(t *testing.T, n int, cfg RaftConfig) (*queueNet, []*RaftEngine) {
+	t.Helper()
+	net := newQueueNet()
+	members := make([]types.Address, n)
+	for i := range members {
+		members[i] = types.Address{byte(i + 1)}
+	}
+	engines := make([]*RaftEngine, n)
+	for i := range engines {
+		e, err := NewRaftEngine(newChainManager(t), mempool.New(10, nil), newStateManager(t), net.join(members[i].String()), members[i], members, cfg)
+		if err != nil {
+			t.Fatalf("new raft engine: %v", err)
+		}
+		engines[i] = e
+	}
+	return net, engines
+}
+
+func runRaft(t *testing.T, net *queueNet, engines []*RaftEngine, ticks int) {
+	t.Helper()
+	for i := 0; i < ticks; i++ {
+		for j, e := range engines {
+			if net.down[e.id.String()] {
+				continue
+			}
+			if err := e.tick(context.Background()); err != nil {
+				t.Fatalf("tick engine %d: %v", j, err)
+			}
+		}
+		net.deliverAll()
+	}
+}
+
+func TestRaftElectsLeaderAndReplicates(t *testing.T) {
+	net, engines := newRaftCluster(t, 3, RaftConfig{ElectionTicks: 5, HeartbeatTicks: 1, BlockTicks: 1, MaxTxsPerBlock: 5, Seed: 1})
+	runRaft(t, net, engines, 40)
+
+	leaders := 0
+	for _, e := range engines {
+		if e.role == raftLeader {
+			leaders++
+		}
+	}
+	if leaders != 1 {
+		t.Fatalf("expected exactly one leader, got %d", leaders)
+	}
+
+	height, hash := engines[0].chain.Tip()
+	if height == 0 {
+		t.Fatal("expected blocks to be committed")
+	}
+	for i, e := range engines[1:] {
+		h, _ := e.chain.Tip()
+		if h+1 < height {
+			t.Fatalf("engine %d lagging: height %d vs %d", i+1, h, height)
+		}
+		if h >= height {
+			block, err := e.chain.GetBlockByHeight(height)
+			if err != nil || block.Header.Hash() != hash {
+				t.Fatalf("engine %d committed a different block at height %d", i+1, height)
+			}
+		}
+	}
+}
+
+func TestRaftSendsPointToPoint(t *testing.T) {
+	net, engines := newRaftCluster(t, 3, RaftConfig{ElectionTicks: 5, HeartbeatTicks: 1, BlockTicks: 1, MaxTxsPerBlock: 5, Seed: 1})
+	runRaft(t, net, engines, 40)
+
+	// Once every member has been heard from, appends and their responses
+	// are addressed to a single peer.
+	net.broadcasts = 0
+	before, _ := engines[0].chain.Tip()
+	runRaft(t, net, engines, 10)
+	if net.broadcasts != 0 {
+		t.Fatalf("expected no broadcasts in steady state, got %d", net.broadcasts)
+	}
+	if after, _ := engines[0].chain.Tip(); after <= before {
+		t.Fatalf("chain did not progress: %d -> %d", before, after)
+	}
+}
+
+func TestRaftFailoverAndSnapshotCatchUp(t *testing.T) {
+	net, engines := newRaftCluster(t, 3, RaftConfig{ElectionTicks: 5, HeartbeatTicks: 1, BlockTicks: 1, SnapshotThreshold: 2, MaxTxsPerBlock: 5, Seed: 2})
+	runRaft(t, net, engines, 30)
+
+	var leader *RaftEngine
+	for _, e := range engines {
+		if e.role == raftLeader {
+			leader = e
+		}
+	}
+	if leader == nil {
+		t.Fatal("no leader elected")
+	}
+	before, _ := leader.chain.Tip()
+
+	net.down[leader.id.String()] = true
+	runRaft(t, net, engines, 40)
+
+	var next *RaftEngine
+	for _, e := range engines {
+		if e != leader && e.role == raftLeader {
+			next = e
+		}
+	}
+	if next == nil {
+		t.Fatal("no new leader after failover")
+	}
+	after, _ := next.chain.Tip()
+	if after <= before {
+		t.Fatalf("chain did not progress after failover: %d -> %d", before, after)
+	}
+
+	// The old leader rejoins far behind the compacted log and must be caught
+	// up from a snapshot.
+	delete(net.down, leader.id.String())
+	runRaft(t, net, engines, 20)
+
+	tip, _ := next.chain.Tip()
+	got, _ := leader.chain.Tip()
+	if got+1 < tip {
+		t.Fatalf("old leader did not catch up: %d vs %d", got, tip)
+	}
+	for h := uint64(1); h <= got; h++ {
+		a, _ := leader.chain.GetBlockByHeight(h)
+		b, _ := next.chain.GetBlockByHeight(h)
+		if a.Header.Hash() != b.Header.Hash() {
+			t.Fatalf("chains diverged at height %d", h)
+		}
+	}
+}
+
+func TestRaftStreamsSnapshotInChunks(t *testing.T) {
+	net, engines := newRaftCluster(t, 3, RaftConfig{ElectionTicks: 5, HeartbeatTicks: 1, BlockTicks: 1, SnapshotThreshold: 2, SnapshotChunkSize: 1, MaxTxsPerBlock: 5, Seed: 2})
+	runRaft(t, net, engines, 20)
+
+	var lagging *RaftEngine
+	for _, e := range engines {
+		if e.role != raftLeader {
+			lagging = e
+			break
+		}
+	}
+	net.down[lagging.id.String()] = true
+	runRaft(t, net, engines, 30)
+
+	chunks := 0
+	net.inspect = func(q queuedEnvelope) {
+		var msg raftMessage
+		if err := json.Unmarshal(q.env.Payload, &msg); err != nil || msg.Type != raftMsgSnapshot {
+			return
+		}
+		chunks++
+		if len(msg.Blocks) != 1 {
+			t.Errorf("expected one block per snapshot chunk, got %d", len(msg.Blocks))
+		}
+	}
+	delete(net.down, lagging.id.String())
+	runRaft(t, net, engines, 40)
+
+	if chunks < 2 {
+		t.Fatalf("expected the snapshot to be streamed in several chunks, got %d", chunks)
+	}
+	tip, _ := engines[0].chain.Tip()
+	for _, e := range engines[1:] {
+		if h, _ := e.chain.Tip(); h > tip {
+			tip = h
+		}
+	}
+	if got, _ := lagging.chain.Tip(); got+1 < tip {
+		t.Fatalf("lagging member did not catch up: %d vs %d", got, tip)
+	}
+}
+
+func TestRaftHardStatePersists(t *testing.T) {
+	store := state.NewMemoryStore()
+	member := types.Address{1}
+	net := newQueueNet()
+	cfg := RaftConfig{ElectionTicks: 2, Store: store, TickInterval: time.Millisecond}
+
+	e, err := NewRaftEngine(newChainManager(t), mempool.New(10, nil), newStateManager(t), net.join("a"), member, []types.Address{member}, cfg)
+	if err != nil {
+		t.Fatalf("new raft engine: %v", err)
+	}
+	runRaft(t, net, []*RaftEngine{e}, 5)
+	term := e.term
+
+	restarted, err := NewRaftEngine(newChainManager(t), mempool.New(10, nil), newStateManager(t), net.join("b"), member, []types.Address{member}, cfg)
+	if err != nil {
+		t.Fatalf("restart raft engine: %v", err)
+	}
+	if restarted.term != term || restarted.vote != member {
+		t.Fatalf("expected term %d and self vote after restart, got term %d", term, restarted.term)
+	}
+}
+
+func TestRaftOverMemoryHub(t *testing.T) {
+	hub := p2p.NewMemoryHub(p2p.MemoryHubConfig{Latency: time.Millisecond, Jitter: time.Millisecond, Seed: 3})
+	members := []types.Address{{1}, {2}, {3}}
+	engines := make([]*RaftEngine, len(members))
+	for i, member := range members {
+		transport, err := hub.Connect(member.String())
+		if err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		transport.Start()
+		defer transport.Close()
+		e, err := NewRaftEngine(newChainManager(t), mempool.New(10, nil), newStateManager(t), transport, member, members, RaftConfig{
+			TickInterval: 5 * time.Millisecond, ElectionTicks: 10, HeartbeatTicks: 1, BlockTicks: 2, MaxTxsPerBlock: 5, Seed: int64(i + 1),
+		})
+		if err != nil {
+			t.Fatalf("new raft engine: %v", err)
+		}
+		engines[i] = e
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, e := range engines {
+		wg.Add(1)
+		go func(e *RaftEngine) {
+			defer wg.Done()
+			e.Start(ctx)
+		}(e)
+	}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	height := func(e *RaftEngine) uint64 {
+		h, _ := e.chain.Tip()
+		return h
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if height(engines[0]) >= 3 && height(engines[1]) >= 3 && height(engines[2]) >= 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i, e := range engines {
+		if height(e) < 3 {
+			t.Fatalf("engine %d stuck at height %d", i, height(e))
+		}
+	}
+	for h := uint64(1); h <= 3; h++ {
+		want, _ := engines[0].chain.GetBlockByHeight(h)
+		for i, e := range engines[1:] {
+			got, _ := e.chain.GetBlockByHeight(h)
+			if got.Header.Hash() != want.Header.Hash() {
+				t.Fatalf("engine %d diverged at height %d", i+1, h)
+			}
+		}
+	}
+}
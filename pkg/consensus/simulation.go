@@ -0,0 +1,403 @@
+package consensus
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/chain"
+	"github.com/0xphantomotr/gchain/pkg/mempool"
+	"github.com/0xphantomotr/gchain/pkg/p2p"
+	"github.com/0xphantomotr/gchain/pkg/state"
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+// SimConfig controls the simulated network. Every random choice is drawn from
+// a single generator seeded with Seed, so a failing run can be replayed
+// exactly.
+type SimConfig struct {
+	Nodes int
+	Seed  int64
+	// TickInterval is the virtual time between engine ticks.
+	TickInterval time.Duration
+	// Each delivery is delayed uniformly in [MinDelay, MaxDelay].
+	MinDelay time.Duration
+	MaxDelay time.Duration
+	// DropRate and DuplicateRate are per-delivery probabilities.
+	DropRate      float64
+	DuplicateRate float64
+	// ReorderRate is the probability a delivery is held back by an extra
+	// random delay of up to ReorderWindow, letting later messages overtake it.
+	ReorderRate   float64
+	ReorderWindow time.Duration
+}
+
+// EngineFactory builds the engine under test for one simulated node. The
+// engine must be driven through node.Broadcaster() or node.Transport() so the
+// simulation sees its traffic.
+type EngineFactory func(node *SimNode) (Engine, error)
+
+// tickable engines can be driven by the simulation's virtual clock instead of
+// their own timers.
+type tickable interface {
+	tick(ctx context.Context) error
+}
+
+// clocked engines timestamp blocks with the simulation's virtual clock, so
+// runs with the same seed produce identical blocks.
+type clocked interface {
+	SetClock(now func() time.Time)
+}
+
+type SimNode struct {
+	Index   int
+	ID      types.Address
+	Chain   *chain.Manager
+	State   *state.Manager
+	Mempool *mempool.Mempool
+	Engine  Engine
+
+	sim      *Simulation
+	handlers map[p2p.MessageType]p2p.HandlerFunc
+}
+
+// Broadcaster returns a consensus.Broadcaster that sends through the
+// simulated network.
+func (n *SimNode) Broadcaster() Broadcaster {
+	return simBroadcaster{node: n}
+}
+
+// Transport returns a p2p.Transport that sends through the simulated network.
+func (n *SimNode) Transport() p2p.Transport {
+	return simTransport{node: n}
+}
+
+func (n *SimNode) name() string {
+	return n.ID.String()
+}
+
+// Simulation runs several engines in one goroutine against a virtual clock
+// and an in-memory network with injectable faults.
+type Simulation struct {
+	cfg    SimConfig
+	rng    *rand.Rand
+	now    time.Time
+	seq    uint64
+	events simQueue
+	nodes  []*SimNode
+	groups map[int]int
+}
+
+func NewSimulation(cfg SimConfig, factory EngineFactory) (*Simulation, error) {
+	if cfg.TickInterval <= 0 {
+		cfg.TickInterval = 100 * time.Millisecond
+	}
+	if cfg.MaxDelay < cfg.MinDelay {
+		cfg.MaxDelay = cfg.MinDelay
+	}
+	sim := &Simulation{
+		cfg: cfg,
+		rng: rand.New(rand.NewSource(cfg.Seed)),
+		now: time.Unix(0, 0),
+	}
+
+	for i := 0; i < cfg.Nodes; i++ {
+		chainMgr, err := chain.NewManager(chain.NewMemoryStore())
+		if err != nil {
+			return nil, fmt.Errorf("node %d: new chain manager: %w", i, err)
+		}
+		node := &SimNode{
+			Index:    i,
+			ID:       SimNodeID(i),
+			Chain:    chainMgr,
+			State:    state.NewManager(state.NewMemoryStore()),
+			Mempool:  mempool.New(1024, nil),
+			sim:      sim,
+			handlers: make(map[p2p.MessageType]p2p.HandlerFunc),
+		}
+		engine, err := factory(node)
+		if err != nil {
+			return nil, fmt.Errorf("node %d: build engine: %w", i, err)
+		}
+		if _, ok := engine.(tickable); !ok {
+			return nil, fmt.Errorf("node %d: engine %T cannot be driven by the simulation", i, engine)
+		}
+		if c, ok := engine.(clocked); ok {
+			c.SetClock(sim.Now)
+		}
+		node.Engine = engine
+		sim.nodes = append(sim.nodes, node)
+
+		// Stagger the first tick so nodes do not run in lockstep.
+		offset := time.Duration(sim.rng.Int63n(int64(cfg.TickInterval)))
+		sim.schedule(&simEvent{at: sim.now.Add(offset), to: i, tick: true})
+	}
+	return sim, nil
+}
+
+// SimNodeID is the address the simulation assigns to node i.
+func SimNodeID(i int) types.Address {
+	return types.Address{0x51, byte(i >> 8), byte(i)}
+}
+
+func (s *Simulation) Nodes() []*SimNode {
+	return s.nodes
+}
+
+// Now returns the current virtual time.
+func (s *Simulation) Now() time.Time {
+	return s.now
+}
+
+// Partition splits the network so only nodes in the same group can talk.
+// Nodes not listed form a group of their own.
+func (s *Simulation) Partition(groups ...[]int) {
+	s.groups = make(map[int]int)
+	for g, members := range groups {
+		for _, i := range members {
+			s.groups[i] = g + 1
+		}
+	}
+	for i := range s.nodes {
+		if _, ok := s.groups[i]; !ok {
+			s.groups[i] = len(groups) + 1 + i
+		}
+	}
+}
+
+// Heal removes every partition.
+func (s *Simulation) Heal() {
+	s.groups = nil
+}
+
+func (s *Simulation) connected(a, b int) bool {
+	return s.groups == nil || s.groups[a] == s.groups[b]
+}
+
+// Run advances the virtual clock by d, processing every tick and delivery due
+// in that window.
+func (s *Simulation) Run(d time.Duration) error {
+	return s.RunUntil(func() bool { return false }, d)
+}
+
+// RunUntil advances the clock until done returns true or limit elapses.
+func (s *Simulation) RunUntil(done func() bool, limit time.Duration) error {
+	deadline := s.now.Add(limit)
+	ctx := context.Background()
+	for s.events.Len() > 0 && !done() {
+		next := s.events[0]
+		if next.at.After(deadline) {
+			break
+		}
+		heap.Pop(&s.events)
+		s.now = next.at
+
+		node := s.nodes[next.to]
+		switch {
+		case next.tick:
+			if err := node.Engine.(tickable).tick(ctx); err != nil {
+				return fmt.Errorf("node %d tick at %s: %w", next.to, s.now.Sub(time.Unix(0, 0)), err)
+			}
+			s.schedule(&simEvent{at: s.now.Add(s.cfg.TickInterval), to: next.to, tick: true})
+		case next.msg != nil:
+			var msg Message
+			if err := json.Unmarshal(next.msg, &msg); err != nil {
+				return fmt.Errorf("decode simulated message: %w", err)
+			}
+			node.Engine.HandleMessage(msg)
+		default:
+			if h := node.handlers[next.env.Type]; h != nil {
+				h(p2p.PeerInfo{ID: s.nodes[next.from].name()}, next.env.Payload)
+			}
+		}
+	}
+	if s.now.Before(deadline) && !done() {
+		s.now = deadline
+	}
+	return nil
+}
+
+// CheckSafety reports an error if two nodes committed different blocks at
+// the same height.
+func (s *Simulation) CheckSafety() error {
+	for height := uint64(1); height <= s.MaxHeight(); height++ {
+		var want types.Hash
+		owner := -1
+		for _, node := range s.nodes {
+			block, err := node.Chain.GetBlockByHeight(height)
+			if err != nil {
+				continue
+			}
+			hash := block.Header.Hash()
+			if owner < 0 {
+				want, owner = hash, node.Index
+				continue
+			}
+			if hash != want {
+				return fmt.Errorf("safety violation at height %d: node %d committed %s, node %d committed %s",
+					height, owner, want, node.Index, hash)
+			}
+		}
+	}
+	return nil
+}
+
+// MinHeight is the lowest tip across nodes; liveness means it keeps growing.
+func (s *Simulation) MinHeight() uint64 {
+	var lowest uint64
+	for i, node := range s.nodes {
+		height, _ := node.Chain.Tip()
+		if i == 0 || height < lowest {
+			lowest = height
+		}
+	}
+	return lowest
+}
+
+func (s *Simulation) MaxHeight() uint64 {
+	var highest uint64
+	for _, node := range s.nodes {
+		if height, _ := node.Chain.Tip(); height > highest {
+			highest = height
+		}
+	}
+	return highest
+}
+
+func (s *Simulation) send(from int, except string, msg []byte, env *p2p.Envelope) {
+	for to, node := range s.nodes {
+		if to == from || node.name() == except || !s.connected(from, to) {
+			continue
+		}
+		s.transmit(from, to, msg, env)
+	}
+}
+
+// sendTo is send for a single recipient.
+func (s *Simulation) sendTo(from int, to string, env *p2p.Envelope) {
+	for i, node := range s.nodes {
+		if node.name() != to || i == from || !s.connected(from, i) {
+			continue
+		}
+		s.transmit(from, i, nil, env)
+	}
+}
+
+// transmit schedules one delivery, dropping or duplicating it at the
+// configured rates.
+func (s *Simulation) transmit(from, to int, msg []byte, env *p2p.Envelope) {
+	if s.rng.Float64() < s.cfg.DropRate {
+		return
+	}
+	copies := 1
+	if s.rng.Float64() < s.cfg.DuplicateRate {
+		copies++
+	}
+	for c := 0; c < copies; c++ {
+		s.schedule(&simEvent{at: s.now.Add(s.delay()), from: from, to: to, msg: msg, env: env})
+	}
+}
+
+func (s *Simulation) delay() time.Duration {
+	d := s.cfg.MinDelay
+	if spread := s.cfg.MaxDelay - s.cfg.MinDelay; spread > 0 {
+		d += time.Duration(s.rng.Int63n(int64(spread) + 1))
+	}
+	if s.cfg.ReorderWindow > 0 && s.rng.Float64() < s.cfg.ReorderRate {
+		d += time.Duration(s.rng.Int63n(int64(s.cfg.ReorderWindow)))
+	}
+	return d
+}
+
+func (s *Simulation) schedule(ev *simEvent) {
+	s.seq++
+	ev.seq = s.seq
+	heap.Push(&s.events, ev)
+}
+
+type simBroadcaster struct {
+	node *SimNode
+}
+
+func (b simBroadcaster) Broadcast(msg Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	b.node.sim.send(b.node.Index, "", payload, nil)
+	return nil
+}
+
+var errSimRequest = errors.New("simulation: requests are not supported")
+
+type simTransport struct {
+	node *SimNode
+}
+
+func (t simTransport) Start() error { return nil }
+func (t simTransport) Close() error { return nil }
+
+func (t simTransport) Broadcast(env p2p.Envelope) {
+	t.BroadcastExcept(env.PeerID, env)
+}
+
+func (t simTransport) BroadcastExcept(peerID string, env p2p.Envelope) {
+	dup := env.Clone()
+	t.node.sim.send(t.node.Index, peerID, nil, &dup)
+}
+
+func (t simTransport) RegisterHandler(msgType p2p.MessageType, handler p2p.HandlerFunc) {
+	t.node.handlers[msgType] = handler
+}
+
+func (t simTransport) ReportPeer(peerID string, penalty int, reason string) {}
+
+func (t simTransport) Send(peerID string, env p2p.Envelope) error {
+	dup := env.Clone()
+	t.node.sim.sendTo(t.node.Index, peerID, &dup)
+	return nil
+}
+
+// Request cannot block on a reply: the simulation only advances its clock
+// between handler calls.
+func (t simTransport) Request(ctx context.Context, peerID string, env p2p.Envelope) (p2p.Envelope, error) {
+	return p2p.Envelope{}, errSimRequest
+}
+
+func (t simTransport) RegisterRequestHandler(msgType p2p.MessageType, handler p2p.RequestHandlerFunc) {
+}
+
+type simEvent struct {
+	at   time.Time
+	seq  uint64
+	from int
+	to   int
+	tick bool
+	msg  []byte
+	env  *p2p.Envelope
+}
+
+type simQueue []*simEvent
+
+func (q simQueue) Len() int { return len(q) }
+func (q simQueue) Less(i, j int) bool {
+	if !q[i].at.Equal(q[j].at) {
+		return q[i].at.Before(q[j].at)
+	}
+	return q[i].seq < q[j].seq
+}
+func (q simQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *simQueue) Push(x interface{}) {
+	*q = append(*q, x.(*simEvent))
+}
+func (q *simQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	*q = old[0 : n-1]
+	return item
+}
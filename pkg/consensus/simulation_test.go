@@ -0,0 +1,209 @@
+package consensus
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/p2p"
+	"github.com/0xphantomotr/gchain/pkg/state"
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+func leaderFactory(n int) EngineFactory {
+	validators := make([]state.Validator, n)
+	for i := range validators {
+		validators[i] = state.Validator{Address: SimNodeID(i), SelfBond: 1, Stake: 1}
+	}
+	return func(node *SimNode) (Engine, error) {
+		set := NewStakeValidatorSet(validators)
+		return NewLeaderEngine(node.Chain, node.Mempool, node.State, set, node.Broadcaster(), node.ID, 100*time.Millisecond, 16), nil
+	}
+}
+
+func cliqueFactory(n int) EngineFactory {
+	keys := make([]ed25519.PrivateKey, n)
+	signers := make([]types.Address, n)
+	for i := range keys {
+		keys[i] = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{byte(i + 1)}, ed25519.SeedSize))
+		signers[i] = SignerAddress(keys[i])
+	}
+	return func(node *SimNode) (Engine, error) {
+		return NewCliqueEngine(node.Chain, node.Mempool, node.State, node.Broadcaster(), keys[node.Index], signers, CliqueConfig{Period: 100 * time.Millisecond, Wiggle: 2, MaxTxsPerBlock: 16})
+	}
+}
+
+func raftFactory(n int, seed int64) EngineFactory {
+	members := make([]types.Address, n)
+	for i := range members {
+		members[i] = SimNodeID(i)
+	}
+	return func(node *SimNode) (Engine, error) {
+		return NewRaftEngine(node.Chain, node.Mempool, node.State, node.Transport(), node.ID, members, RaftConfig{ElectionTicks: 10, HeartbeatTicks: 1, BlockTicks: 2, SnapshotThreshold: 16, MaxTxsPerBlock: 16, Seed: seed})
+	}
+}
+
+func requireProgress(t *testing.T, sim *Simulation, target uint64, limit time.Duration) {
+	t.Helper()
+	if err := sim.RunUntil(func() bool { return sim.MinHeight() >= target }, limit); err != nil {
+		t.Fatalf("run simulation: %v", err)
+	}
+	if err := sim.CheckSafety(); err != nil {
+		t.Fatal(err)
+	}
+	if got := sim.MinHeight(); got < target {
+		t.Fatalf("liveness: expected every node at height >= %d after %s, lowest is %d", target, limit, got)
+	}
+}
+
+// LeaderEngine has no block sync, so a validator that misses a commit never
+// catches up; drops are exercised against Raft instead.
+func TestSimulationLeaderUnderFaults(t *testing.T) {
+	sim, err := NewSimulation(SimConfig{
+		Nodes:         4,
+		Seed:          7,
+		TickInterval:  100 * time.Millisecond,
+		MinDelay:      5 * time.Millisecond,
+		MaxDelay:      80 * time.Millisecond,
+		DuplicateRate: 0.2,
+		ReorderRate:   0.2,
+		ReorderWindow: 200 * time.Millisecond,
+	}, leaderFactory(4))
+	if err != nil {
+		t.Fatalf("new simulation: %v", err)
+	}
+	requireProgress(t, sim, 10, time.Minute)
+}
+
+func TestSimulationLeaderPartitionHeals(t *testing.T) {
+	sim, err := NewSimulation(SimConfig{Nodes: 4, Seed: 3, MinDelay: time.Millisecond, MaxDelay: 20 * time.Millisecond}, leaderFactory(4))
+	if err != nil {
+		t.Fatalf("new simulation: %v", err)
+	}
+	requireProgress(t, sim, 3, 10*time.Second)
+
+	// Neither side has a quorum of three.
+	sim.Partition([]int{0, 1}, []int{2, 3})
+	stalled := sim.MaxHeight()
+	if err := sim.Run(5 * time.Second); err != nil {
+		t.Fatalf("run partitioned: %v", err)
+	}
+	if err := sim.CheckSafety(); err != nil {
+		t.Fatal(err)
+	}
+	if sim.MaxHeight() > stalled+1 {
+		t.Fatalf("minority partitions committed blocks: %d -> %d", stalled, sim.MaxHeight())
+	}
+
+	sim.Heal()
+	requireProgress(t, sim, stalled+5, 30*time.Second)
+}
+
+func TestSimulationPartitionIsolatesUnlistedNodes(t *testing.T) {
+	sim, err := NewSimulation(SimConfig{Nodes: 4, Seed: 1}, leaderFactory(4))
+	if err != nil {
+		t.Fatalf("new simulation: %v", err)
+	}
+	sim.Partition([]int{0, 1})
+	if !sim.connected(0, 1) {
+		t.Fatal("nodes in one group should stay connected")
+	}
+	if sim.connected(2, 3) || sim.connected(0, 2) {
+		t.Fatal("each unlisted node should be cut off on its own")
+	}
+}
+
+func TestSimulationCliqueUnderFaults(t *testing.T) {
+	sim, err := NewSimulation(SimConfig{
+		Nodes:         3,
+		Seed:          11,
+		MinDelay:      time.Millisecond,
+		MaxDelay:      30 * time.Millisecond,
+		DuplicateRate: 0.3,
+		ReorderRate:   0.2,
+		ReorderWindow: 50 * time.Millisecond,
+	}, cliqueFactory(3))
+	if err != nil {
+		t.Fatalf("new simulation: %v", err)
+	}
+	requireProgress(t, sim, 10, time.Minute)
+}
+
+func TestSimulationRaftPartitionAndRecovery(t *testing.T) {
+	sim, err := NewSimulation(SimConfig{
+		Nodes:         5,
+		Seed:          5,
+		MinDelay:      time.Millisecond,
+		MaxDelay:      40 * time.Millisecond,
+		DropRate:      0.05,
+		DuplicateRate: 0.1,
+		ReorderRate:   0.1,
+		ReorderWindow: 100 * time.Millisecond,
+	}, raftFactory(5, 5))
+	if err != nil {
+		t.Fatalf("new simulation: %v", err)
+	}
+	requireProgress(t, sim, 5, time.Minute)
+
+	sim.Partition([]int{0, 1}, []int{2, 3, 4})
+	if err := sim.Run(20 * time.Second); err != nil {
+		t.Fatalf("run partitioned: %v", err)
+	}
+	if err := sim.CheckSafety(); err != nil {
+		t.Fatal(err)
+	}
+
+	sim.Heal()
+	requireProgress(t, sim, sim.MaxHeight(), time.Minute)
+}
+
+func TestSimulationIsReproducible(t *testing.T) {
+	run := func() [][]types.Hash {
+		sim, err := NewSimulation(SimConfig{Nodes: 3, Seed: 42, MinDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond, DropRate: 0.2}, raftFactory(3, 42))
+		if err != nil {
+			t.Fatalf("new simulation: %v", err)
+		}
+		if err := sim.Run(10 * time.Second); err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		var chains [][]types.Hash
+		for _, node := range sim.Nodes() {
+			tip, _ := node.Chain.Tip()
+			hashes := make([]types.Hash, 0, tip)
+			for h := uint64(1); h <= tip; h++ {
+				block, err := node.Chain.GetBlockByHeight(h)
+				if err != nil {
+					t.Fatalf("load block %d: %v", h, err)
+				}
+				hashes = append(hashes, block.Header.Hash())
+			}
+			chains = append(chains, hashes)
+		}
+		return chains
+	}
+
+	first, second := run(), run()
+	for i := range first {
+		if len(first[i]) == 0 || len(first[i]) != len(second[i]) {
+			t.Fatalf("node %d: runs reached heights %d and %d", i, len(first[i]), len(second[i]))
+		}
+		for h := range first[i] {
+			if first[i][h] != second[i][h] {
+				t.Fatalf("node %d: runs committed different blocks at height %d", i, h+1)
+			}
+		}
+	}
+}
+
+func TestSimulationDuplicatesPointToPointMessages(t *testing.T) {
+	sim, err := NewSimulation(SimConfig{Nodes: 2, Seed: 1, DuplicateRate: 1}, raftFactory(2, 1))
+	if err != nil {
+		t.Fatalf("new simulation: %v", err)
+	}
+	before := sim.events.Len()
+	sim.sendTo(0, sim.nodes[1].name(), &p2p.Envelope{Type: p2p.MessageTypeConsensus})
+	if got := sim.events.Len() - before; got != 2 {
+		t.Fatalf("expected 2 scheduled deliveries, got %d", got)
+	}
+}
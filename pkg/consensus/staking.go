@@ -0,0 +1,117 @@
+package consensus
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"log"
+
+	"github.com/0xphantomotr/gchain/pkg/state"
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+// EndBlocker runs after every committed block. When it returns ok the engine
+// switches to set once its height reaches activationHeight.
+type EndBlocker interface {
+	EndBlock(block *types.Block) (set ValidatorSet, activationHeight uint64, ok bool)
+}
+
+type pendingValidatorSet struct {
+	set    ValidatorSet
+	height uint64
+}
+
+// StakeValidatorSet selects proposers in proportion to bonded stake and
+// gives each validator voting power equal to its stake.
+type StakeValidatorSet struct {
+	validators []state.Validator
+	total      uint64
+}
+
+func NewStakeValidatorSet(validators []state.Validator) *StakeValidatorSet {
+	set := &StakeValidatorSet{validators: make([]state.Validator, len(validators))}
+	copy(set.validators, validators)
+	for _, v := range validators {
+		set.total += v.Stake
+	}
+	return set
+}
+
+// Proposer draws a validator with probability proportional to its stake.
+// The draw is seeded by a hash of height and round, so every node agrees on
+// it without shared state and no validator holds a long run of heights.
+func (s *StakeValidatorSet) Proposer(height, round uint64) types.Address {
+	if s.total == 0 {
+		return types.Address{}
+	}
+	var seed [16]byte
+	binary.BigEndian.PutUint64(seed[:8], height)
+	binary.BigEndian.PutUint64(seed[8:], round)
+	sum := sha256.Sum256(seed[:])
+	offset := binary.BigEndian.Uint64(sum[:8]) % s.total
+	for _, v := range s.validators {
+		if offset < v.Stake {
+			return v.Address
+		}
+		offset -= v.Stake
+	}
+	return s.validators[len(s.validators)-1].Address
+}
+
+func (s *StakeValidatorSet) Size() int {
+	return len(s.validators)
+}
+
+func (s *StakeValidatorSet) Has(addr types.Address) bool {
+	for _, v := range s.validators {
+		if v.Address == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Power returns addr's stake, or zero if it is not in the set.
+func (s *StakeValidatorSet) Power(addr types.Address) uint64 {
+	for _, v := range s.validators {
+		if v.Address == addr {
+			return v.Stake
+		}
+	}
+	return 0
+}
+
+func (s *StakeValidatorSet) TotalPower() uint64 {
+	return s.total
+}
+
+func (s *StakeValidatorSet) Validators() []state.Validator {
+	out := make([]state.Validator, len(s.validators))
+	copy(out, s.validators)
+	return out
+}
+
+// StakingEndBlocker recomputes the validator set from bonded stake every
+// EpochLength blocks. The new set becomes active ActivationDelay blocks after
+// the epoch boundary so every node switches at the same height.
+type StakingEndBlocker struct {
+	State           *state.Manager
+	EpochLength     uint64
+	ActivationDelay uint64
+	MaxValidators   int
+}
+
+func (s *StakingEndBlocker) EndBlock(block *types.Block) (ValidatorSet, uint64, bool) {
+	height := block.Header.Height
+	if s.EpochLength == 0 || height%s.EpochLength != 0 {
+		return nil, 0, false
+	}
+	validators, err := s.State.ActiveValidators(s.MaxValidators)
+	if err != nil {
+		log.Printf("staking end block height=%d: %v", height, err)
+		return nil, 0, false
+	}
+	if len(validators) == 0 {
+		return nil, 0, false
+	}
+	return NewStakeValidatorSet(validators), height + max(s.ActivationDelay, 1), true
+}
@@ -0,0 +1,105 @@
+package consensus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/mempool"
+	"github.com/0xphantomotr/gchain/pkg/state"
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+func TestStakeValidatorSetWeightsProposers(t *testing.T) {
+	set := NewStakeValidatorSet([]state.Validator{
+		{Address: types.Address{1}, Stake: 3},
+		{Address: types.Address{2}, Stake: 1},
+	})
+
+	counts := make(map[types.Address]int)
+	for h := uint64(0); h < 4000; h++ {
+		counts[set.Proposer(h, 0)]++
+	}
+	if counts[types.Address{1}] < 2800 || counts[types.Address{1}] > 3200 || counts[types.Address{1}]+counts[types.Address{2}] != 4000 {
+		t.Fatalf("unexpected proposer distribution %v", counts)
+	}
+}
+
+func TestStakeValidatorSetAvoidsLongRuns(t *testing.T) {
+	set := NewStakeValidatorSet([]state.Validator{
+		{Address: types.Address{1}, Stake: 5_000_000_000},
+		{Address: types.Address{2}, Stake: 5_000_000_000},
+	})
+	run, longest := 0, 0
+	var last types.Address
+	for h := uint64(1); h <= 1000; h++ {
+		p := set.Proposer(h, 0)
+		if p == last {
+			run++
+		} else {
+			run, last = 1, p
+		}
+		longest = max(longest, run)
+	}
+	if longest > 20 {
+		t.Fatalf("validator proposed %d heights in a row", longest)
+	}
+}
+
+func TestLeaderQuorumWeightedByStake(t *testing.T) {
+	whale, small1, small2 := types.Address{1}, types.Address{2}, types.Address{3}
+	set := NewStakeValidatorSet([]state.Validator{
+		{Address: whale, Stake: 10},
+		{Address: small1, Stake: 1},
+		{Address: small2, Stake: 1},
+	})
+	engine := NewLeaderEngine(newChainManager(t), mempool.New(10, nil), newStateManager(t), set, &mockBroadcaster{}, small1, time.Second, 5)
+
+	if engine.hasQuorumLocked(map[types.Address]bool{small1: true, small2: true}) {
+		t.Fatal("two of three validators must not reach quorum with a sixth of the stake")
+	}
+	if !engine.hasQuorumLocked(map[types.Address]bool{whale: true}) {
+		t.Fatal("a validator holding most of the stake should reach quorum alone")
+	}
+	if engine.hasQuorumLocked(map[types.Address]bool{types.Address{9}: true, small1: true}) {
+		t.Fatal("non-members must carry no voting power")
+	}
+}
+
+func TestEndBlockerActivatesStakedValidators(t *testing.T) {
+	chainMgr := newChainManager(t)
+	stateMgr := newStateManager(t)
+	pool := mempool.New(10, nil)
+
+	nodeID := types.Address{1}
+	staker := types.Address{2}
+	if err := stateMgr.SeedAccount(staker, 100, 0); err != nil {
+		t.Fatalf("seed account: %v", err)
+	}
+	if err := pool.Add(types.Transaction{Type: types.TxTypeBond, From: staker, To: staker, Amount: 50, Timestamp: time.Unix(0, 1)}); err != nil {
+		t.Fatalf("add bond tx: %v", err)
+	}
+
+	engine := NewLeaderEngine(chainMgr, pool, stateMgr, mockValidatorSet{proposer: nodeID, size: 1}, &mockBroadcaster{}, nodeID, time.Millisecond, 5)
+	engine.SetEndBlocker(&StakingEndBlocker{State: stateMgr, EpochLength: 1, ActivationDelay: 2})
+
+	if err := engine.proposeBlock(context.Background(), engine.height, engine.round, types.Hash{}); err != nil {
+		t.Fatalf("propose block: %v", err)
+	}
+	if _, ok := engine.validators.(*StakeValidatorSet); ok {
+		t.Fatal("validator set activated before activation height")
+	}
+
+	_, tipHash := chainMgr.Tip()
+	if err := engine.proposeBlock(context.Background(), engine.height, engine.round, tipHash); err != nil {
+		t.Fatalf("propose block: %v", err)
+	}
+
+	set, ok := engine.validators.(*StakeValidatorSet)
+	if !ok {
+		t.Fatalf("expected stake validator set at height %d, got %T", engine.height, engine.validators)
+	}
+	if !set.Has(staker) || set.Size() != 1 {
+		t.Fatalf("unexpected validator set %+v", set.Validators())
+	}
+}
@@ -0,0 +1,104 @@
+package events
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+const DefaultBufferSize = 256
+
+var ErrSlowSubscriber = errors.New("events: subscriber fell behind")
+
+type EventType uint8
+
+const (
+	// EventNewBlock is published after a block is committed.
+	EventNewBlock EventType = iota
+	// EventNewTx is published when a transaction enters the mempool.
+	EventNewTx
+)
+
+type Event struct {
+	Type  EventType
+	Block *types.Block
+	Tx    *types.Transaction
+}
+
+// Bus fans events out to subscribers. Publishing never blocks: a subscriber
+// whose buffer is full is dropped and its channel closed. A nil *Bus
+// discards everything, so publishers need not check for one.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives every event published after Subscribe returns.
+type Subscription struct {
+	bus *Bus
+	ch  chan Event
+	err error
+}
+
+// Subscribe registers a subscriber with room for buffer undelivered events.
+func (b *Bus) Subscribe(buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBufferSize
+	}
+	sub := &Subscription{bus: b, ch: make(chan Event, buffer)}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *Bus) Publish(ev Event) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs {
+		select {
+		case sub.ch <- ev:
+		default:
+			sub.err = ErrSlowSubscriber
+			delete(b.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+func (b *Bus) PublishBlock(block *types.Block) {
+	b.Publish(Event{Type: EventNewBlock, Block: block})
+}
+
+func (b *Bus) PublishTx(tx types.Transaction) {
+	b.Publish(Event{Type: EventNewTx, Tx: &tx})
+}
+
+// Events is closed by Unsubscribe or when the subscriber falls behind.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Err reports why the events channel was closed, if the bus closed it.
+func (s *Subscription) Err() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	return s.err
+}
+
+func (s *Subscription) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.ch)
+	}
+}
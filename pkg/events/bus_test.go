@@ -0,0 +1,50 @@
+package events
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+func TestBusFansOut(t *testing.T) {
+	bus := NewBus()
+	a, b := bus.Subscribe(4), bus.Subscribe(4)
+	bus.PublishTx(types.Transaction{Amount: 1})
+	for _, sub := range []*Subscription{a, b} {
+		ev := <-sub.Events()
+		if ev.Type != EventNewTx || ev.Tx.Amount != 1 {
+			t.Fatalf("unexpected event: %+v", ev)
+		}
+	}
+
+	a.Unsubscribe()
+	a.Unsubscribe()
+	bus.PublishBlock(&types.Block{})
+	if _, ok := <-a.Events(); ok {
+		t.Fatal("expected closed channel after unsubscribe")
+	}
+	if ev := <-b.Events(); ev.Type != EventNewBlock {
+		t.Fatalf("expected block event, got %+v", ev)
+	}
+}
+
+func TestBusDropsSlowSubscriber(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe(1)
+	bus.PublishTx(types.Transaction{})
+	bus.PublishTx(types.Transaction{})
+	<-sub.Events()
+	if _, ok := <-sub.Events(); ok {
+		t.Fatal("expected the slow subscriber to be dropped")
+	}
+	if !errors.Is(sub.Err(), ErrSlowSubscriber) {
+		t.Fatalf("expected ErrSlowSubscriber, got %v", sub.Err())
+	}
+	sub.Unsubscribe()
+}
+
+func TestNilBusDiscards(t *testing.T) {
+	var bus *Bus
+	bus.PublishBlock(&types.Block{})
+}
@@ -4,6 +4,7 @@ import (
 	"container/heap"
 	"sync"
 
+	"github.com/0xphantomotr/gchain/pkg/events"
 	"github.com/0xphantomotr/gchain/pkg/types"
 )
 
@@ -30,6 +31,7 @@ type Mempool struct {
 	pq     priorityQueue
 	maxTxs int
 	source TxSource
+	events *events.Bus
 }
 
 func New(maxTxs int, source TxSource) *Mempool {
@@ -43,18 +45,36 @@ func New(maxTxs int, source TxSource) *Mempool {
 	}
 }
 
+// SetEventBus publishes every newly added transaction to bus.
+func (m *Mempool) SetEventBus(bus *events.Bus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = bus
+}
+
 func (m *Mempool) Add(tx types.Transaction) error {
+	tx.Hash = tx.CalculateHash()
+	bus, err := m.add(tx)
+	if err != nil {
+		return err
+	}
+	// Publish outside the lock so subscribers may call back into the pool.
+	bus.PublishTx(tx)
+	return nil
+}
+
+// add pools tx and returns the bus to announce it on, or nil if tx was
+// already pooled.
+func (m *Mempool) add(tx types.Transaction) (*events.Bus, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	hash := tx.CalculateHash()
-	tx.Hash = hash
-	if _, exists := m.txs[hash]; exists {
-		return nil
+	if _, exists := m.txs[tx.Hash]; exists {
+		return nil, nil
 	}
 	if m.source != nil {
 		if err := m.source.Validate(tx); err != nil {
-			return err
+			return nil, err
 		}
 	}
 	if m.maxTxs > 0 && len(m.txs) >= m.maxTxs {
@@ -63,8 +83,8 @@ func (m *Mempool) Add(tx types.Transaction) error {
 	}
 	e := &entry{tx: tx, priority: -tx.Timestamp.UnixNano()}
 	heap.Push(&m.pq, e)
-	m.txs[hash] = e
-	return nil
+	m.txs[tx.Hash] = e
+	return m.events, nil
 }
 
 func (m *Mempool) Pending(limit int) []types.Transaction {
@@ -87,6 +107,40 @@ func (m *Mempool) Pending(limit int) []types.Transaction {
 	return results
 }
 
+// Transactions returns every pooled transaction in no particular order.
+func (m *Mempool) Transactions() []types.Transaction {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]types.Transaction, 0, len(m.txs))
+	for _, e := range m.txs {
+		out = append(out, e.tx)
+	}
+	return out
+}
+
+// Hashes returns the hash of every pooled transaction in no particular
+// order; Get looks each one up.
+func (m *Mempool) Hashes() []types.Hash {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]types.Hash, 0, len(m.txs))
+	for hash := range m.txs {
+		out = append(out, hash)
+	}
+	return out
+}
+
+// Get returns the pooled transaction with the given hash.
+func (m *Mempool) Get(hash types.Hash) (types.Transaction, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	e, ok := m.txs[hash]
+	if !ok {
+		return types.Transaction{}, false
+	}
+	return e.tx, true
+}
+
 func (m *Mempool) Remove(hash types.Hash) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
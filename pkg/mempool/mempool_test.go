@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/0xphantomotr/gchain/pkg/events"
 	"github.com/0xphantomotr/gchain/pkg/types"
 )
 
@@ -71,3 +72,46 @@ func TestRemoveDeletesEntry(t *testing.T) {
 		t.Fatalf("expected empty pool")
 	}
 }
+
+func TestTransactionsSnapshot(t *testing.T) {
+	pool := New(10, nil)
+	pool.Add(makeTx(1))
+	pool.Add(makeTx(2))
+	if txs := pool.Transactions(); len(txs) != 2 {
+		t.Fatalf("expected 2 transactions, got %d", len(txs))
+	}
+}
+
+func TestGetReturnsPooledTx(t *testing.T) {
+	pool := New(10, nil)
+	tx := makeTx(1)
+	pool.Add(tx)
+	if got, ok := pool.Get(tx.Hash); !ok || got.Hash != tx.Hash {
+		t.Fatalf("expected tx to be found")
+	}
+	if _, ok := pool.Get(makeTx(2).Hash); ok {
+		t.Fatalf("expected unknown tx to be missing")
+	}
+}
+
+func TestAddPublishesNewTxOnce(t *testing.T) {
+	pool := New(10, nil)
+	bus := events.NewBus()
+	pool.SetEventBus(bus)
+	sub := bus.Subscribe(4)
+
+	tx := makeTx(1)
+	for i := 0; i < 2; i++ {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sub.Unsubscribe()
+	var got []types.Hash
+	for ev := range sub.Events() {
+		got = append(got, ev.Tx.Hash)
+	}
+	if len(got) != 1 || got[0] != tx.Hash {
+		t.Fatalf("expected one event for %s, got %v", tx.Hash, got)
+	}
+}
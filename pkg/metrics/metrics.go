@@ -1,14 +1,33 @@
 package metrics
 
-import "expvar"
+import (
+	"expvar"
+	"time"
+)
 
 var (
 	txSubmitted     = expvar.NewInt("tx_submitted_total")
 	blocksCommitted = expvar.NewInt("blocks_committed_total")
 	currentHeight   = expvar.NewInt("current_block_height")
 	peerCount       = expvar.NewInt("peer_count")
+	dialAttempts    = expvar.NewInt("dial_attempts_total")
+	dialFailures    = expvar.NewInt("dial_failures_total")
+	peerRTT         = expvar.NewMap("peer_rtt_ms")
+	droppedMessages = expvar.NewMap("p2p_messages_dropped_total")
+	throttled       = expvar.NewMap("p2p_throttled_total")
+	compressedIn    = expvar.NewInt("p2p_compression_raw_bytes_total")
+	compressedOut   = expvar.NewInt("p2p_compression_compressed_bytes_total")
 )
 
+func init() {
+	expvar.Publish("p2p_compression_ratio", expvar.Func(func() any {
+		if out := compressedOut.Value(); out > 0 {
+			return float64(compressedIn.Value()) / float64(out)
+		}
+		return 0.0
+	}))
+}
+
 // IncTxSubmitted increments the transaction submission counter.
 func IncTxSubmitted() {
 	txSubmitted.Add(1)
@@ -24,3 +43,41 @@ func ObserveBlockCommit(height uint64) {
 func SetPeerCount(count int) {
 	peerCount.Set(int64(count))
 }
+
+// IncDialAttempt increments the outbound dial counter.
+func IncDialAttempt() {
+	dialAttempts.Add(1)
+}
+
+// IncDialFailure increments the failed outbound dial counter.
+func IncDialFailure() {
+	dialFailures.Add(1)
+}
+
+// SetPeerRTT records a peer's ping round-trip time in milliseconds.
+func SetPeerRTT(peerID string, rtt time.Duration) {
+	v := new(expvar.Float)
+	v.Set(float64(rtt) / float64(time.Millisecond))
+	peerRTT.Set(peerID, v)
+}
+
+// RemovePeerRTT forgets a disconnected peer's round-trip time.
+func RemovePeerRTT(peerID string) {
+	peerRTT.Delete(peerID)
+}
+
+// IncMessageDropped counts a peer message dropped by its rate limit.
+func IncMessageDropped(msgType string) {
+	droppedMessages.Add(msgType, 1)
+}
+
+// IncThrottled counts a peer read or write delayed by a byte-rate cap.
+func IncThrottled(direction string) {
+	throttled.Add(direction, 1)
+}
+
+// ObserveCompression records a frame compressed from raw to compressed bytes.
+func ObserveCompression(raw, compressed int) {
+	compressedIn.Add(int64(raw))
+	compressedOut.Add(int64(compressed))
+}
@@ -0,0 +1,287 @@
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAddrBookSize caps the address book when Config.MaxAddrBookSize
+	// is zero.
+	DefaultAddrBookSize = 1000
+	// maxAddrFailures is how many consecutive failed dials drop an address.
+	maxAddrFailures = 8
+)
+
+// KnownAddress is an address book entry. ID is empty until a handshake with
+// the address has succeeded or a peer vouched for it.
+type KnownAddress struct {
+	Addr        string    `json:"addr"`
+	ID          string    `json:"id,omitempty"`
+	Attempts    int       `json:"attempts"`
+	Successes   int       `json:"successes"`
+	Failures    int       `json:"failures"`
+	Added       time.Time `json:"added,omitempty"`
+	LastAttempt time.Time `json:"last_attempt,omitempty"`
+	LastSuccess time.Time `json:"last_success,omitempty"`
+}
+
+// AddrBook tracks peer addresses learned from seeds, PEX and inbound peers.
+// When path is set the book is persisted to a JSON file. Once full, new
+// addresses evict the least proven entry.
+type AddrBook struct {
+	mu      sync.Mutex
+	path    string
+	addrs   map[string]*KnownAddress
+	maxSize int
+	rng     *rand.Rand
+}
+
+func NewAddrBook(path string) *AddrBook {
+	return &AddrBook{
+		path:    path,
+		addrs:   make(map[string]*KnownAddress),
+		maxSize: DefaultAddrBookSize,
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// SetMaxSize caps the number of addresses kept; n <= 0 restores the default.
+func (b *AddrBook) SetMaxSize(n int) {
+	if n <= 0 {
+		n = DefaultAddrBookSize
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maxSize = n
+	for len(b.addrs) > b.maxSize {
+		b.evictLocked()
+	}
+}
+
+// Load merges the entries saved at the book's path. A missing file is not an
+// error.
+func (b *AddrBook) Load() error {
+	if b.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read address book: %w", err)
+	}
+	var entries []*KnownAddress
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("decode address book: %w", err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, entry := range entries {
+		if _, ok := b.addrs[entry.Addr]; !ok && entry.Addr != "" {
+			b.insertLocked(entry)
+		}
+	}
+	return nil
+}
+
+// Add records addr if it is new. It reports whether the book changed.
+func (b *AddrBook) Add(addr, id string) bool {
+	if addr == "" {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if known, ok := b.addrs[addr]; ok {
+		if known.ID == "" && id != "" {
+			known.ID = id
+		}
+		return false
+	}
+	b.insertLocked(&KnownAddress{Addr: addr, ID: id, Added: time.Now()})
+	return true
+}
+
+func (b *AddrBook) insertLocked(known *KnownAddress) {
+	if len(b.addrs) >= b.maxSize {
+		b.evictLocked()
+	}
+	b.addrs[known.Addr] = known
+}
+
+// evictLocked drops one entry: among addresses never dialed successfully the
+// one with the most failures, then the oldest; when all have succeeded, the
+// one that succeeded longest ago.
+func (b *AddrBook) evictLocked() {
+	var victim *KnownAddress
+	for _, known := range b.addrs {
+		if victim == nil || evictBefore(known, victim) {
+			victim = known
+		}
+	}
+	if victim != nil {
+		delete(b.addrs, victim.Addr)
+	}
+}
+
+func evictBefore(a, b *KnownAddress) bool {
+	if (a.Successes == 0) != (b.Successes == 0) {
+		return a.Successes == 0
+	}
+	if a.Successes == 0 {
+		if a.Failures != b.Failures {
+			return a.Failures > b.Failures
+		}
+		if !a.Added.Equal(b.Added) {
+			return a.Added.Before(b.Added)
+		}
+		return a.Addr < b.Addr
+	}
+	if !a.LastSuccess.Equal(b.LastSuccess) {
+		return a.LastSuccess.Before(b.LastSuccess)
+	}
+	return a.Addr < b.Addr
+}
+
+func (b *AddrBook) MarkAttempt(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	known, ok := b.addrs[addr]
+	if !ok {
+		known = &KnownAddress{Addr: addr, Added: time.Now()}
+		b.insertLocked(known)
+	}
+	known.Attempts++
+	known.LastAttempt = time.Now()
+}
+
+func (b *AddrBook) MarkGood(addr, id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	known, ok := b.addrs[addr]
+	if !ok {
+		known = &KnownAddress{Addr: addr, Added: time.Now()}
+		b.insertLocked(known)
+	}
+	known.ID = id
+	known.Successes++
+	known.Failures = 0
+	known.LastSuccess = time.Now()
+}
+
+// MarkBad records a failed dial and forgets the address after too many
+// consecutive failures.
+func (b *AddrBook) MarkBad(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	known, ok := b.addrs[addr]
+	if !ok {
+		return
+	}
+	known.Failures++
+	if known.Failures >= maxAddrFailures {
+		delete(b.addrs, addr)
+	}
+}
+
+func (b *AddrBook) Remove(addr string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.addrs, addr)
+}
+
+// Pick returns a random address to dial among those not excluded and not
+// attempted within retry, favouring the fewest consecutive failures.
+func (b *AddrBook) Pick(retry time.Duration, exclude func(KnownAddress) bool) (KnownAddress, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var candidates []*KnownAddress
+	best := maxAddrFailures
+	for _, known := range b.addrs {
+		if exclude != nil && exclude(*known) {
+			continue
+		}
+		if !known.LastAttempt.IsZero() && time.Since(known.LastAttempt) < retry {
+			continue
+		}
+		switch {
+		case known.Failures < best:
+			best = known.Failures
+			candidates = append(candidates[:0], known)
+		case known.Failures == best:
+			candidates = append(candidates, known)
+		}
+	}
+	if len(candidates) == 0 {
+		return KnownAddress{}, false
+	}
+	return *candidates[b.rng.Intn(len(candidates))], true
+}
+
+// Sample returns up to n random entries, preferring addresses that have been
+// dialed successfully.
+func (b *AddrBook) Sample(n int) []KnownAddress {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	all := make([]KnownAddress, 0, len(b.addrs))
+	for _, known := range b.addrs {
+		all = append(all, *known)
+	}
+	b.rng.Shuffle(len(all), func(i, j int) { all[i], all[j] = all[j], all[i] })
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Successes > 0 && all[j].Successes == 0
+	})
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+func (b *AddrBook) Size() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.addrs)
+}
+
+// Save writes the book to its file, replacing it atomically.
+func (b *AddrBook) Save() error {
+	if b.path == "" {
+		return nil
+	}
+	b.mu.Lock()
+	entries := make([]*KnownAddress, 0, len(b.addrs))
+	for _, known := range b.addrs {
+		entries = append(entries, known)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Addr < entries[j].Addr })
+	data, err := json.MarshalIndent(entries, "", "  ")
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), ".addrbook-*")
+	if err != nil {
+		return fmt.Errorf("save address book: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("save address book: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("save address book: %w", err)
+	}
+	return os.Rename(tmp.Name(), b.path)
+}
@@ -0,0 +1,82 @@
+package p2p
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAddrBookPersistsStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "addrbook.json")
+	book := NewAddrBook(path)
+	book.Add("10.0.0.1:9000", "")
+	book.Add("10.0.0.2:9000", "peer-b")
+	book.MarkAttempt("10.0.0.1:9000")
+	book.MarkGood("10.0.0.1:9000", "peer-a")
+	if err := book.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	loaded := NewAddrBook(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if loaded.Size() != 2 {
+		t.Fatalf("expected 2 addresses, got %d", loaded.Size())
+	}
+	for _, known := range loaded.Sample(2) {
+		if known.Addr == "10.0.0.1:9000" && (known.ID != "peer-a" || known.Successes != 1 || known.Attempts != 1) {
+			t.Fatalf("stats not persisted: %+v", known)
+		}
+	}
+}
+
+func TestAddrBookPickSkipsFailingAndRecent(t *testing.T) {
+	book := NewAddrBook("")
+	book.Add("good:1", "")
+	book.Add("flaky:1", "")
+	book.MarkAttempt("flaky:1")
+	book.MarkBad("flaky:1")
+	// Make the failed address eligible again so only its failures count.
+	book.addrs["flaky:1"].LastAttempt = time.Time{}
+
+	for i := 0; i < 20; i++ {
+		known, ok := book.Pick(time.Minute, nil)
+		if !ok || known.Addr != "good:1" {
+			t.Fatalf("expected the address without failures, got %+v", known)
+		}
+	}
+
+	book.MarkAttempt("good:1")
+	if known, _ := book.Pick(time.Minute, nil); known.Addr != "flaky:1" {
+		t.Fatalf("recently attempted address should be skipped, got %+v", known)
+	}
+
+	for i := 0; i < maxAddrFailures; i++ {
+		book.MarkBad("flaky:1")
+	}
+	if book.Size() != 1 {
+		t.Fatalf("expected the failing address to be evicted, size %d", book.Size())
+	}
+}
+
+func TestAddrBookEvictsWhenFull(t *testing.T) {
+	book := NewAddrBook("")
+	book.SetMaxSize(3)
+	book.Add("proven:1", "")
+	book.MarkGood("proven:1", "peer-a")
+	book.Add("failing:1", "")
+	book.MarkBad("failing:1")
+	book.Add("fresh:1", "")
+
+	if !book.Add("new:1", "") || book.Size() != 3 {
+		t.Fatalf("expected the book to stay at 3 entries, got %d", book.Size())
+	}
+	book.mu.Lock()
+	_, failing := book.addrs["failing:1"]
+	_, proven := book.addrs["proven:1"]
+	book.mu.Unlock()
+	if failing || !proven {
+		t.Fatal("expected the failing address to be evicted before the proven one")
+	}
+}
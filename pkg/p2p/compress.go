@@ -0,0 +1,184 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/0xphantomotr/gchain/pkg/metrics"
+)
+
+// Compression names a frame compression algorithm peers can negotiate.
+type Compression string
+
+const (
+	CompressionSnappy Compression = "snappy"
+	CompressionZstd   Compression = "zstd"
+)
+
+// DefaultCompressionThreshold is the smallest payload worth compressing
+// when Config.CompressionThreshold is unset.
+const DefaultCompressionThreshold = 1024
+
+// compressedFlag marks a frame whose payload is compressed with the
+// connection's negotiated algorithm. Message types stay below it.
+const compressedFlag MessageType = 0x80
+
+var (
+	ErrUnknownCompression    = errors.New("p2p: unknown compression")
+	ErrUnexpectedCompression = errors.New("p2p: compressed frame on uncompressed connection")
+)
+
+type codec interface {
+	encode(src []byte) []byte
+	// decode fails if the result would exceed limit bytes.
+	decode(src []byte, limit int) ([]byte, error)
+	close()
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) encode(src []byte) []byte {
+	return snappy.Encode(nil, src)
+}
+
+func (snappyCodec) decode(src []byte, limit int) ([]byte, error) {
+	n, err := snappy.DecodedLen(src)
+	if err != nil {
+		return nil, err
+	}
+	if n > limit {
+		return nil, fmt.Errorf("%w: %d bytes uncompressed (limit %d)", ErrMessageTooLarge, n, limit)
+	}
+	return snappy.Decode(nil, src)
+}
+
+func (snappyCodec) close() {}
+
+type zstdCodec struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+func newZstdCodec(maxSize int) (*zstdCodec, error) {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	if err != nil {
+		return nil, err
+	}
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(maxSize)))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdCodec{enc: enc, dec: dec}, nil
+}
+
+func (c *zstdCodec) encode(src []byte) []byte {
+	return c.enc.EncodeAll(src, nil)
+}
+
+func (c *zstdCodec) decode(src []byte, limit int) ([]byte, error) {
+	out, err := c.dec.DecodeAll(src, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, fmt.Errorf("%w: %d bytes uncompressed (limit %d)", ErrMessageTooLarge, len(out), limit)
+	}
+	return out, nil
+}
+
+func (c *zstdCodec) close() {
+	c.enc.Close()
+	c.dec.Close()
+}
+
+// newCodecs builds a codec for each configured algorithm. Zstd refuses to
+// inflate a frame beyond the largest configured message size.
+func newCodecs(cfg Config) (map[Compression]codec, error) {
+	codecs := make(map[Compression]codec, len(cfg.Compression))
+	for _, c := range cfg.Compression {
+		switch c {
+		case CompressionSnappy:
+			codecs[c] = snappyCodec{}
+		case CompressionZstd:
+			maxSize := cfg.MaxMessageSize
+			if maxSize <= 0 {
+				maxSize = DefaultMaxMessageSize
+			}
+			for _, limit := range cfg.MaxMessageSizes {
+				maxSize = max(maxSize, limit)
+			}
+			z, err := newZstdCodec(maxSize)
+			if err != nil {
+				return nil, err
+			}
+			codecs[c] = z
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrUnknownCompression, c)
+		}
+	}
+	return codecs, nil
+}
+
+func (s *Server) compressionThreshold() int {
+	if s.cfg.CompressionThreshold > 0 {
+		return s.cfg.CompressionThreshold
+	}
+	return DefaultCompressionThreshold
+}
+
+// negotiateCompression picks the algorithm for a connection to remote. Both
+// ends must choose the same one, so the preference list of the node with
+// the lower ID wins; the first entry the other side supports is used.
+func (s *Server) negotiateCompression(remote NodeInfo) codec {
+	local := make([]string, len(s.cfg.Compression))
+	for i, c := range s.cfg.Compression {
+		local[i] = string(c)
+	}
+	prefer, other := local, remote.Compression
+	if remote.ID < s.id {
+		prefer, other = remote.Compression, local
+	}
+	for _, name := range prefer {
+		for _, o := range other {
+			if name == o {
+				return s.codecs[Compression(name)]
+			}
+		}
+	}
+	return nil
+}
+
+// compressFrame compresses env for p when the connection negotiated an
+// algorithm, the payload is large enough and compression pays off.
+func (s *Server) compressFrame(p *Peer, env Envelope) Envelope {
+	if p.codec == nil || len(env.Payload) < s.compressionThreshold() {
+		return env
+	}
+	compressed := p.codec.encode(env.Payload)
+	if len(compressed) >= len(env.Payload) {
+		return env
+	}
+	metrics.ObserveCompression(len(env.Payload), len(compressed))
+	env.Type |= compressedFlag
+	env.Payload = compressed
+	return env
+}
+
+func (s *Server) decompressFrame(p *Peer, env Envelope) (Envelope, error) {
+	if env.Type&compressedFlag == 0 {
+		return env, nil
+	}
+	if p.codec == nil {
+		return Envelope{}, ErrUnexpectedCompression
+	}
+	env.Type &^= compressedFlag
+	payload, err := p.codec.decode(env.Payload, s.maxMessageSize(env.Type))
+	if err != nil {
+		return Envelope{}, fmt.Errorf("decompress %s frame: %w", env.Type, err)
+	}
+	env.Payload = payload
+	return env, nil
+}
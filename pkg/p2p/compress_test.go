@@ -0,0 +1,129 @@
+package p2p
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestCodecsRoundTripAndEnforceLimit(t *testing.T) {
+	codecs, err := newCodecs(Config{Compression: []Compression{CompressionZstd, CompressionSnappy}})
+	if err != nil {
+		t.Fatalf("new codecs: %v", err)
+	}
+	payload := bytes.Repeat([]byte(`{"from":"0101","to":"0202","amount":5}`), 100)
+	for name, c := range codecs {
+		compressed := c.encode(payload)
+		if len(compressed) >= len(payload) {
+			t.Fatalf("%s did not shrink a repetitive payload", name)
+		}
+		out, err := c.decode(compressed, len(payload))
+		if err != nil || !bytes.Equal(out, payload) {
+			t.Fatalf("%s round trip failed: %v", name, err)
+		}
+		if _, err := c.decode(compressed, len(payload)-1); !errors.Is(err, ErrMessageTooLarge) {
+			t.Fatalf("%s: expected ErrMessageTooLarge, got %v", name, err)
+		}
+		c.close()
+	}
+	if _, err := newCodecs(Config{Compression: []Compression{"lz4"}}); !errors.Is(err, ErrUnknownCompression) {
+		t.Fatalf("expected ErrUnknownCompression, got %v", err)
+	}
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	a := NewServer(Config{Compression: []Compression{CompressionZstd, CompressionSnappy}})
+	b := NewServer(Config{Compression: []Compression{CompressionSnappy, CompressionZstd}})
+	for _, s := range []*Server{a, b} {
+		codecs, err := newCodecs(s.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.codecs = codecs
+	}
+	fromA := a.negotiateCompression(b.localNodeInfo())
+	fromB := b.negotiateCompression(a.localNodeInfo())
+	if fromA == nil || fromB == nil {
+		t.Fatal("expected a shared algorithm")
+	}
+	if fmt.Sprintf("%T", fromA) != fmt.Sprintf("%T", fromB) {
+		t.Fatal("both ends must pick the same algorithm")
+	}
+
+	plain := NewServer(Config{})
+	if a.negotiateCompression(plain.localNodeInfo()) != nil {
+		t.Fatal("expected no compression with a peer that offers none")
+	}
+}
+
+func TestCompressedFramesBetweenPeers(t *testing.T) {
+	a := newTestServer(t, Config{Compression: []Compression{CompressionZstd}})
+	b := newTestServer(t, Config{
+		Seeds:           []string{a.listener.Addr().String()},
+		Compression:     []Compression{CompressionSnappy, CompressionZstd},
+		MaxMessageSizes: map[MessageType]int{MessageTypeBlock: 64 << 10},
+	})
+	got := make(chan []byte, 1)
+	b.RegisterHandler(MessageTypeBlock, func(_ PeerInfo, payload []byte) { got <- payload })
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && len(a.Peers()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Over the per-type limit only once inflated; the wire frame is tiny.
+	payload := bytes.Repeat([]byte("block"), 8<<10)
+	a.Broadcast(NewEnvelope(MessageTypeBlock, payload, ""))
+	select {
+	case received := <-got:
+		if !bytes.Equal(received, payload) {
+			t.Fatal("payload corrupted in transit")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("compressed block not delivered")
+	}
+
+	a.Broadcast(NewEnvelope(MessageTypeBlock, bytes.Repeat([]byte("block"), 16<<10), ""))
+	for time.Now().Before(deadline.Add(2*time.Second)) && len(b.Peers()) != 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(b.Peers()) != 0 {
+		t.Fatal("peer inflating past the message limit was not dropped")
+	}
+}
+
+func TestCompressedFrameUsesPerTypeLimit(t *testing.T) {
+	a := newTestServer(t, Config{Compression: []Compression{CompressionZstd}})
+	b := newTestServer(t, Config{
+		Seeds:           []string{a.listener.Addr().String()},
+		Compression:     []Compression{CompressionZstd},
+		MaxMessageSize:  4 << 10,
+		MaxMessageSizes: map[MessageType]int{MessageTypeBlock: 64 << 10},
+	})
+	got := make(chan []byte, 1)
+	b.RegisterHandler(MessageTypeBlock, func(_ PeerInfo, payload []byte) { got <- payload })
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && len(a.Peers()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Compresses, but the wire frame still exceeds the global limit and
+	// must be checked against the block limit instead.
+	noise := make([]byte, 16<<10)
+	rand.New(rand.NewSource(1)).Read(noise)
+	payload := append(noise, bytes.Repeat([]byte("block"), 4<<10)...)
+	a.Broadcast(NewEnvelope(MessageTypeBlock, payload, ""))
+	select {
+	case received := <-got:
+		if !bytes.Equal(received, payload) {
+			t.Fatal("payload corrupted in transit")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("compressed block within its per-type limit not delivered")
+	}
+	if len(b.Peers()) == 0 {
+		t.Fatal("peer dropped for a frame within its per-type limit")
+	}
+}
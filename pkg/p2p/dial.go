@@ -0,0 +1,195 @@
+package p2p
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/metrics"
+)
+
+const (
+	DefaultDialBackoffBase    = time.Second
+	DefaultDialBackoffMax     = 5 * time.Minute
+	DefaultMaxConcurrentDials = 8
+)
+
+// persistentPeer is an address the server keeps connected for its whole
+// lifetime, redialing with backoff whenever the connection drops.
+type persistentPeer struct {
+	addr string
+	id   string
+	// down is signalled when the peer's connection is removed.
+	down chan struct{}
+}
+
+// startDial claims addr so only one goroutine dials it at a time.
+func (s *Server) startDial(addr string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dialing[addr] {
+		return false
+	}
+	s.dialing[addr] = true
+	return true
+}
+
+func (s *Server) finishDial(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.dialing, addr)
+}
+
+// dial connects to addr and records the outcome in the address book,
+// returning the remote node ID. The caller must hold the dial claim from
+// startDial. At most MaxConcurrentDials dials run at once.
+func (s *Server) dial(addr string) (string, error) {
+	select {
+	case s.dialSlots <- struct{}{}:
+		defer func() { <-s.dialSlots }()
+	case <-s.quit:
+		return "", ErrServerClosed
+	}
+
+	if s.cfg.Gater != nil && !s.cfg.Gater.InterceptDial(addr) {
+		return "", ErrGated
+	}
+	metrics.IncDialAttempt()
+	s.book.MarkAttempt(addr)
+	conn, err := net.DialTimeout("tcp", addr, s.cfg.HandshakeTimeout)
+	if err != nil {
+		metrics.IncDialFailure()
+		s.book.MarkBad(addr)
+		return "", err
+	}
+	node, err := s.handleConnection(conn, false)
+	switch {
+	case err == nil || errors.Is(err, ErrDuplicatePeer):
+		s.book.MarkGood(addr, node.ID)
+		return node.ID, nil
+	case errors.Is(err, ErrSelfConnection):
+		s.book.Remove(addr)
+	default:
+		s.book.MarkBad(addr)
+	}
+	metrics.IncDialFailure()
+	return node.ID, err
+}
+
+// backoff is the wait before retry number attempt: exponential from
+// DialBackoffBase up to DialBackoffMax, with the result spread over
+// [d/2, 3d/2) so peers that dropped together do not redial in lockstep.
+func (s *Server) backoff(attempt int) time.Duration {
+	base, max := s.cfg.DialBackoffBase, s.cfg.DialBackoffMax
+	if base <= 0 {
+		base = DefaultDialBackoffBase
+	}
+	if max <= 0 {
+		max = DefaultDialBackoffMax
+	}
+	d := max
+	if attempt < 32 && base<<attempt < max {
+		d = base << attempt
+	}
+	d = d/2 + time.Duration(rand.Int63n(int64(d)))
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// sleep waits for d and reports false if the server closed meanwhile.
+func (s *Server) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.quit:
+		return false
+	}
+}
+
+// connectSeeds dials each seed until the first successful connection.
+func (s *Server) connectSeeds() {
+	for _, addr := range s.cfg.Seeds {
+		if s.persistent[addr] != nil || !s.startDial(addr) {
+			continue
+		}
+		go func(target string) {
+			defer s.finishDial(target)
+			for attempt := 0; ; attempt++ {
+				_, err := s.dial(target)
+				if err == nil || errors.Is(err, ErrSelfConnection) || !s.sleep(s.backoff(attempt)) {
+					return
+				}
+			}
+		}(addr)
+	}
+}
+
+func (s *Server) connectPersistent() {
+	for _, pp := range s.persistent {
+		go s.keepPersistent(pp)
+	}
+}
+
+// keepPersistent holds a connection to pp open, redialing after every
+// disconnect. The backoff resets once a connection succeeds.
+func (s *Server) keepPersistent(pp *persistentPeer) {
+	for attempt := 0; ; {
+		// Forget drops of an earlier connection.
+		select {
+		case <-pp.down:
+		default:
+		}
+		if s.startDial(pp.addr) {
+			id, err := s.dial(pp.addr)
+			s.finishDial(pp.addr)
+			if errors.Is(err, ErrSelfConnection) {
+				return
+			}
+			if err == nil {
+				if !s.awaitDisconnect(pp, id) {
+					return
+				}
+				attempt = 0
+			}
+		}
+		if !s.sleep(s.backoff(attempt)) {
+			return
+		}
+		attempt++
+	}
+}
+
+// awaitDisconnect blocks until the persistent peer connected as id drops,
+// reporting false if the server closed first.
+func (s *Server) awaitDisconnect(pp *persistentPeer, id string) bool {
+	s.mu.Lock()
+	pp.id = id
+	_, connected := s.peers[id]
+	s.mu.Unlock()
+	if !connected {
+		return true
+	}
+	select {
+	case <-pp.down:
+		return true
+	case <-s.quit:
+		return false
+	}
+}
+
+// persistentDownLocked wakes the redial loop of the persistent peer with id.
+func (s *Server) persistentDownLocked(id string) {
+	for _, pp := range s.persistent {
+		if pp.id == id {
+			select {
+			case pp.down <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffGrowsWithinBounds(t *testing.T) {
+	s := NewServer(Config{DialBackoffBase: 100 * time.Millisecond, DialBackoffMax: time.Second})
+	for attempt := 0; attempt < 40; attempt++ {
+		want := 100 * time.Millisecond << attempt
+		if attempt >= 4 {
+			want = time.Second
+		}
+		d := s.backoff(attempt)
+		if d < want/2 || d > time.Second {
+			t.Fatalf("attempt %d: backoff %v outside [%v, %v]", attempt, d, want/2, time.Second)
+		}
+	}
+}
+
+func TestPersistentPeerReconnects(t *testing.T) {
+	b := newTestServer(t, Config{})
+	a := newTestServer(t, Config{
+		PersistentPeers: []string{b.listener.Addr().String()},
+		DialBackoffBase: 20 * time.Millisecond,
+		DialBackoffMax:  100 * time.Millisecond,
+	})
+
+	waitConnected := func() *Peer {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			b.mu.RLock()
+			peer := b.peers[a.ID()]
+			b.mu.RUnlock()
+			if peer != nil {
+				return peer
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("persistent peer not connected")
+		return nil
+	}
+
+	first := waitConnected()
+	b.removePeer(first)
+	if second := waitConnected(); second == first {
+		t.Fatal("expected a fresh connection after the drop")
+	}
+}
@@ -0,0 +1,669 @@
+package p2p
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/bits"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// bucketSize is Kademlia's k: entries per bucket and results per lookup.
+	bucketSize = 16
+	// lookupAlpha is how many FIND_NODE queries a lookup keeps in flight.
+	lookupAlpha   = 3
+	nodeIDBits    = 160
+	maxPacketSize = 8 * 1024
+	packetTTL     = 20 * time.Second
+	discDomain    = "gchain-discovery"
+	// bondExpiry is how long a pong proves a node's endpoint; unbonded
+	// nodes are pinged before they enter the table or get FIND_NODE answers.
+	bondExpiry = 24 * time.Hour
+	// maxReadBackoff caps the pause after consecutive socket read errors.
+	maxReadBackoff = time.Second
+
+	DefaultDiscoveryRefresh = 30 * time.Second
+	DefaultDiscoveryTimeout = 500 * time.Millisecond
+)
+
+const (
+	discPing uint8 = iota + 1
+	discPong
+	discFindNode
+	discNeighbors
+)
+
+var (
+	ErrDiscoveryTimeout = errors.New("p2p: discovery request timed out")
+	ErrDiscoveryClosed  = errors.New("p2p: discovery closed")
+)
+
+// DHTNode is a node known to the discovery table. UDPAddr is where it answers
+// discovery queries; TCPAddr is where its p2p.Server listens.
+type DHTNode struct {
+	ID      string `json:"id"`
+	UDPAddr string `json:"udp"`
+	TCPAddr string `json:"tcp,omitempty"`
+}
+
+type DiscoveryConfig struct {
+	ListenAddr string
+	// Bootnodes are UDP addresses contacted to join the network.
+	Bootnodes []string
+	// TCPAddr is advertised so discovered nodes can dial our p2p.Server.
+	TCPAddr         string
+	RefreshInterval time.Duration
+	Timeout         time.Duration
+}
+
+// dhtID is a node ID as a 160-bit number; distance is XOR.
+type dhtID [nodeIDBits / 8]byte
+
+func parseDHTID(id string) (dhtID, error) {
+	var out dhtID
+	raw, err := hex.DecodeString(id)
+	if err != nil || len(raw) != len(out) {
+		return out, fmt.Errorf("invalid node id %q", id)
+	}
+	copy(out[:], raw)
+	return out, nil
+}
+
+// logDistance is the bit length of a XOR b: 0 for equal IDs, 160 for IDs
+// differing in the top bit.
+func logDistance(a, b dhtID) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return (len(a)-i)*8 - bits.LeadingZeros8(x)
+		}
+	}
+	return 0
+}
+
+// closer reports whether a is closer to target than b.
+func closer(target, a, b dhtID) bool {
+	for i := range target {
+		da, db := a[i]^target[i], b[i]^target[i]
+		if da != db {
+			return da < db
+		}
+	}
+	return false
+}
+
+type tableEntry struct {
+	node     DHTNode
+	id       dhtID
+	lastSeen time.Time
+}
+
+type discMessage struct {
+	Type    uint8     `json:"type"`
+	Nonce   uint64    `json:"nonce"`
+	Expires int64     `json:"expires"`
+	TCPAddr string    `json:"tcp,omitempty"`
+	Target  string    `json:"target,omitempty"`
+	Nodes   []DHTNode `json:"nodes,omitempty"`
+}
+
+// discPacket carries a signed message; the sender's ID is derived from
+// PubKey, so table entries cannot be forged for keys an attacker lacks.
+type discPacket struct {
+	PubKey []byte          `json:"pub"`
+	Sig    []byte          `json:"sig"`
+	Msg    json.RawMessage `json:"msg"`
+}
+
+type discReply struct {
+	from DHTNode
+	msg  discMessage
+}
+
+// Discovery is a Kademlia-style DHT over UDP. Nodes are kept in 160 buckets
+// by XOR distance from our ID; lookups walk FIND_NODE queries towards a
+// target, and a refresh loop re-runs lookups and evicts nodes that stop
+// answering pings.
+type Discovery struct {
+	cfg    DiscoveryConfig
+	key    ed25519.PrivateKey
+	self   DHTNode
+	selfID dhtID
+	conn   *net.UDPConn
+	onNode func(DHTNode)
+
+	mu      sync.Mutex
+	buckets [nodeIDBits][]*tableEntry
+	// checking marks buckets whose oldest entry is being pinged to decide
+	// whether a newcomer may replace it.
+	checking [nodeIDBits]bool
+	pending  map[uint64]chan discReply
+	// bonds maps node ID and UDP address to when the endpoint last answered
+	// our ping; bonding marks endpoints with a ping in flight.
+	bonds   map[string]time.Time
+	bonding map[string]bool
+
+	quit      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewDiscovery creates a DHT node identified by key. onNode, if set, is
+// called whenever a node with a TCP address enters the table.
+func NewDiscovery(cfg DiscoveryConfig, key ed25519.PrivateKey, onNode func(DHTNode)) *Discovery {
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = DefaultDiscoveryRefresh
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultDiscoveryTimeout
+	}
+	id := NodeIDFromPublicKey(key.Public().(ed25519.PublicKey))
+	selfID, _ := parseDHTID(id)
+	return &Discovery{
+		cfg:     cfg,
+		key:     key,
+		self:    DHTNode{ID: id, TCPAddr: cfg.TCPAddr},
+		selfID:  selfID,
+		onNode:  onNode,
+		pending: make(map[uint64]chan discReply),
+		bonds:   make(map[string]time.Time),
+		bonding: make(map[string]bool),
+		quit:    make(chan struct{}),
+	}
+}
+
+func (d *Discovery) Start() error {
+	addr, err := net.ResolveUDPAddr("udp", d.cfg.ListenAddr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	d.conn = conn
+	d.self.UDPAddr = conn.LocalAddr().String()
+
+	d.wg.Add(2)
+	go d.readLoop()
+	go d.refreshLoop()
+	return nil
+}
+
+func (d *Discovery) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.quit)
+		if d.conn != nil {
+			d.conn.Close()
+		}
+	})
+	d.wg.Wait()
+	return nil
+}
+
+// Self returns the record this node advertises.
+func (d *Discovery) Self() DHTNode {
+	return d.self
+}
+
+// Nodes returns every node in the routing table.
+func (d *Discovery) Nodes() []DHTNode {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []DHTNode
+	for _, bucket := range d.buckets {
+		for _, entry := range bucket {
+			out = append(out, entry.node)
+		}
+	}
+	return out
+}
+
+// Bootstrap pings the configured bootnodes and looks up our own ID to fill
+// the buckets near us.
+func (d *Discovery) Bootstrap() {
+	var wg sync.WaitGroup
+	for _, addr := range d.cfg.Bootnodes {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			if _, err := d.ping(addr); err != nil {
+				log.Printf("p2p: bootnode %s: %v", addr, err)
+			}
+		}(addr)
+	}
+	wg.Wait()
+	d.lookup(d.selfID)
+}
+
+// Lookup returns the k nodes closest to id that the network knows about.
+func (d *Discovery) Lookup(id string) ([]DHTNode, error) {
+	target, err := parseDHTID(id)
+	if err != nil {
+		return nil, err
+	}
+	return d.lookup(target), nil
+}
+
+func (d *Discovery) lookup(target dhtID) []DHTNode {
+	type candidate struct {
+		node DHTNode
+		id   dhtID
+	}
+	var result []candidate
+	seen := map[string]bool{d.self.ID: true}
+	add := func(node DHTNode) {
+		if seen[node.ID] {
+			return
+		}
+		id, err := parseDHTID(node.ID)
+		if err != nil {
+			return
+		}
+		seen[node.ID] = true
+		result = append(result, candidate{node: node, id: id})
+	}
+	for _, node := range d.closest(target, bucketSize) {
+		add(node)
+	}
+
+	asked := make(map[string]bool)
+	for {
+		sort.Slice(result, func(i, j int) bool { return closer(target, result[i].id, result[j].id) })
+		if len(result) > bucketSize {
+			result = result[:bucketSize]
+		}
+		var batch []DHTNode
+		for _, c := range result {
+			if !asked[c.node.ID] {
+				asked[c.node.ID] = true
+				batch = append(batch, c.node)
+				if len(batch) == lookupAlpha {
+					break
+				}
+			}
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		replies := make(chan []DHTNode, len(batch))
+		for _, node := range batch {
+			go func(node DHTNode) {
+				// Unresponsive nodes are left for revalidate to evict.
+				nodes, _ := d.findNode(node, target)
+				replies <- nodes
+			}(node)
+		}
+		for range batch {
+			for _, node := range <-replies {
+				add(node)
+			}
+		}
+	}
+
+	out := make([]DHTNode, len(result))
+	for i, c := range result {
+		out[i] = c.node
+	}
+	return out
+}
+
+func (d *Discovery) closest(target dhtID, n int) []DHTNode {
+	d.mu.Lock()
+	var entries []tableEntry
+	for _, bucket := range d.buckets {
+		for _, entry := range bucket {
+			entries = append(entries, *entry)
+		}
+	}
+	d.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return closer(target, entries[i].id, entries[j].id) })
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	out := make([]DHTNode, len(entries))
+	for i, entry := range entries {
+		out[i] = entry.node
+	}
+	return out
+}
+
+// seen records that node answered or contacted us. A full bucket keeps its
+// oldest entry if that entry still answers a ping, favouring long-lived nodes.
+func (d *Discovery) seen(node DHTNode) {
+	id, err := parseDHTID(node.ID)
+	if err != nil || id == d.selfID {
+		return
+	}
+	b := logDistance(d.selfID, id) - 1
+
+	d.mu.Lock()
+	bucket := d.buckets[b]
+	for i, entry := range bucket {
+		if entry.id == id {
+			entry.node, entry.lastSeen = node, time.Now()
+			d.buckets[b] = append(append(bucket[:i:i], bucket[i+1:]...), entry)
+			d.mu.Unlock()
+			return
+		}
+	}
+	if len(bucket) < bucketSize {
+		d.buckets[b] = append(bucket, &tableEntry{node: node, id: id, lastSeen: time.Now()})
+		d.mu.Unlock()
+		if d.onNode != nil && node.TCPAddr != "" {
+			d.onNode(node)
+		}
+		return
+	}
+	if d.checking[b] {
+		d.mu.Unlock()
+		return
+	}
+	d.checking[b] = true
+	oldest := bucket[0].node
+	d.mu.Unlock()
+
+	go func() {
+		_, err := d.ping(oldest.UDPAddr)
+		d.mu.Lock()
+		d.checking[b] = false
+		d.mu.Unlock()
+		if err != nil {
+			d.remove(oldest.ID)
+			d.seen(node)
+		}
+	}()
+}
+
+func (d *Discovery) remove(nodeID string) {
+	id, err := parseDHTID(nodeID)
+	if err != nil || id == d.selfID {
+		return
+	}
+	b := logDistance(d.selfID, id) - 1
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	bucket := d.buckets[b]
+	for i, entry := range bucket {
+		if entry.id == id {
+			d.buckets[b] = append(bucket[:i:i], bucket[i+1:]...)
+			return
+		}
+	}
+}
+
+func (d *Discovery) refreshLoop() {
+	defer d.wg.Done()
+	d.Bootstrap()
+
+	ticker := time.NewTicker(d.cfg.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.quit:
+			return
+		case <-ticker.C:
+		}
+		if len(d.Nodes()) == 0 {
+			d.Bootstrap()
+			continue
+		}
+		d.revalidate()
+		d.lookup(d.selfID)
+		var random dhtID
+		rand.Read(random[:])
+		d.lookup(random)
+	}
+}
+
+// revalidate pings the least recently seen node of every bucket and drops
+// those that do not answer.
+func (d *Discovery) revalidate() {
+	d.mu.Lock()
+	for key, at := range d.bonds {
+		if time.Since(at) >= bondExpiry {
+			delete(d.bonds, key)
+		}
+	}
+	var oldest []DHTNode
+	for _, bucket := range d.buckets {
+		if len(bucket) > 0 {
+			oldest = append(oldest, bucket[0].node)
+		}
+	}
+	d.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, node := range oldest {
+		wg.Add(1)
+		go func(node DHTNode) {
+			defer wg.Done()
+			if _, err := d.ping(node.UDPAddr); err != nil {
+				d.remove(node.ID)
+			}
+		}(node)
+	}
+	wg.Wait()
+}
+
+// ping checks that addr answers and bonds with the node behind it.
+func (d *Discovery) ping(addr string) (DHTNode, error) {
+	reply, err := d.request(addr, discMessage{Type: discPing, TCPAddr: d.self.TCPAddr}, discPong)
+	if err != nil {
+		return DHTNode{}, err
+	}
+	d.mu.Lock()
+	d.bonds[bondKey(reply.from)] = time.Now()
+	d.mu.Unlock()
+	d.seen(reply.from)
+	return reply.from, nil
+}
+
+func bondKey(node DHTNode) string {
+	return node.ID + "@" + node.UDPAddr
+}
+
+func (d *Discovery) bonded(node DHTNode) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	at, ok := d.bonds[bondKey(node)]
+	return ok && time.Since(at) < bondExpiry
+}
+
+// bond pings node in the background unless a ping is already in flight,
+// then runs done, if set, once the node has answered as itself.
+func (d *Discovery) bond(node DHTNode, done func()) {
+	key := bondKey(node)
+	d.mu.Lock()
+	if d.bonding[key] {
+		d.mu.Unlock()
+		return
+	}
+	d.bonding[key] = true
+	d.mu.Unlock()
+	go func() {
+		from, err := d.ping(node.UDPAddr)
+		d.mu.Lock()
+		delete(d.bonding, key)
+		d.mu.Unlock()
+		if err == nil && from.ID == node.ID && done != nil {
+			done()
+		}
+	}()
+}
+
+func (d *Discovery) findNode(node DHTNode, target dhtID) ([]DHTNode, error) {
+	msg := discMessage{Type: discFindNode, TCPAddr: d.self.TCPAddr, Target: hex.EncodeToString(target[:])}
+	reply, err := d.request(node.UDPAddr, msg, discNeighbors)
+	if err != nil {
+		return nil, err
+	}
+	// A reply carrying our nonce proves the endpoint as well as a pong.
+	d.mu.Lock()
+	d.bonds[bondKey(reply.from)] = time.Now()
+	d.mu.Unlock()
+	d.seen(reply.from)
+	if len(reply.msg.Nodes) > bucketSize {
+		return reply.msg.Nodes[:bucketSize], nil
+	}
+	return reply.msg.Nodes, nil
+}
+
+func (d *Discovery) request(addr string, msg discMessage, want uint8) (discReply, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return discReply{}, err
+	}
+	var nonce [8]byte
+	rand.Read(nonce[:])
+	msg.Nonce = binary.BigEndian.Uint64(nonce[:])
+
+	ch := make(chan discReply, 1)
+	d.mu.Lock()
+	d.pending[msg.Nonce] = ch
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.pending, msg.Nonce)
+		d.mu.Unlock()
+	}()
+
+	if err := d.send(udpAddr, msg); err != nil {
+		return discReply{}, err
+	}
+	timer := time.NewTimer(d.cfg.Timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case reply := <-ch:
+			if reply.msg.Type == want && reply.from.UDPAddr == udpAddr.String() {
+				return reply, nil
+			}
+		case <-timer.C:
+			return discReply{}, ErrDiscoveryTimeout
+		case <-d.quit:
+			return discReply{}, ErrDiscoveryClosed
+		}
+	}
+}
+
+func (d *Discovery) send(to *net.UDPAddr, msg discMessage) error {
+	msg.Expires = time.Now().Add(packetTTL).Unix()
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	packet, err := json.Marshal(discPacket{
+		PubKey: d.key.Public().(ed25519.PublicKey),
+		Sig:    ed25519.Sign(d.key, append([]byte(discDomain), raw...)),
+		Msg:    raw,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = d.conn.WriteToUDP(packet, to)
+	return err
+}
+
+func (d *Discovery) readLoop() {
+	defer d.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	var backoff time.Duration
+	for {
+		n, from, err := d.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			backoff = min(max(2*backoff, 10*time.Millisecond), maxReadBackoff)
+			log.Printf("p2p: discovery read: %v", err)
+			select {
+			case <-d.quit:
+				return
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		backoff = 0
+		d.handlePacket(from, buf[:n])
+	}
+}
+
+func (d *Discovery) handlePacket(from *net.UDPAddr, data []byte) {
+	var packet discPacket
+	if err := json.Unmarshal(data, &packet); err != nil {
+		return
+	}
+	if len(packet.PubKey) != ed25519.PublicKeySize ||
+		!ed25519.Verify(packet.PubKey, append([]byte(discDomain), packet.Msg...), packet.Sig) {
+		return
+	}
+	var msg discMessage
+	if err := json.Unmarshal(packet.Msg, &msg); err != nil || time.Now().Unix() > msg.Expires {
+		return
+	}
+	sender := DHTNode{ID: NodeIDFromPublicKey(packet.PubKey), UDPAddr: from.String()}
+	if sender.ID == d.self.ID {
+		return
+	}
+	if msg.TCPAddr != "" {
+		sender.TCPAddr = dialableAddr(msg.TCPAddr, from.String())
+	}
+
+	switch msg.Type {
+	case discPing:
+		d.send(from, discMessage{Type: discPong, Nonce: msg.Nonce, TCPAddr: d.self.TCPAddr})
+		if d.bonded(sender) {
+			d.seen(sender)
+		} else {
+			// The source address may be spoofed; only a pong to our own
+			// ping lets the node into the table.
+			d.bond(sender, nil)
+		}
+	case discFindNode:
+		if d.bonded(sender) {
+			d.seen(sender)
+			d.answerFindNode(from, sender, msg)
+			return
+		}
+		// Answering an unproven endpoint would let spoofed queries reflect
+		// large replies at a third party, so answer once it returns our
+		// ping.
+		d.bond(sender, func() { d.answerFindNode(from, sender, msg) })
+	case discPong, discNeighbors:
+		d.mu.Lock()
+		ch := d.pending[msg.Nonce]
+		d.mu.Unlock()
+		if ch == nil {
+			return
+		}
+		select {
+		case ch <- discReply{from: sender, msg: msg}:
+		default:
+		}
+	}
+}
+
+func (d *Discovery) answerFindNode(to *net.UDPAddr, sender DHTNode, msg discMessage) {
+	target, err := parseDHTID(msg.Target)
+	if err != nil {
+		return
+	}
+	var nodes []DHTNode
+	for _, node := range d.closest(target, bucketSize+1) {
+		if node.ID != sender.ID && len(nodes) < bucketSize {
+			nodes = append(nodes, node)
+		}
+	}
+	d.send(to, discMessage{Type: discNeighbors, Nonce: msg.Nonce, Nodes: nodes})
+}
@@ -0,0 +1,222 @@
+package p2p
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func startDiscoveryNetwork(t *testing.T, n int, refresh time.Duration) []*Discovery {
+	t.Helper()
+	var nodes []*Discovery
+	var bootnodes []string
+	for i := 0; i < n; i++ {
+		_, key, _ := ed25519.GenerateKey(rand.Reader)
+		d := NewDiscovery(DiscoveryConfig{
+			ListenAddr:      "127.0.0.1:0",
+			Bootnodes:       bootnodes,
+			RefreshInterval: refresh,
+			Timeout:         300 * time.Millisecond,
+		}, key, nil)
+		if err := d.Start(); err != nil {
+			t.Fatalf("start node %d: %v", i, err)
+		}
+		t.Cleanup(func() { d.Close() })
+		if i == 0 {
+			bootnodes = []string{d.Self().UDPAddr}
+		}
+		nodes = append(nodes, d)
+	}
+	return nodes
+}
+
+func TestLogDistance(t *testing.T) {
+	var a, b dhtID
+	if logDistance(a, b) != 0 {
+		t.Fatal("equal ids must have distance 0")
+	}
+	b[len(b)-1] = 1
+	if d := logDistance(a, b); d != 1 {
+		t.Fatalf("expected distance 1, got %d", d)
+	}
+	b[0] = 0x80
+	if d := logDistance(a, b); d != nodeIDBits {
+		t.Fatalf("expected distance %d, got %d", nodeIDBits, d)
+	}
+}
+
+func TestDiscoveryLookupFindsEveryNode(t *testing.T) {
+	nodes := startDiscoveryNetwork(t, 24, time.Second)
+	populated := func() bool {
+		for _, d := range nodes {
+			if len(d.Nodes()) < bucketSize/2 {
+				return false
+			}
+		}
+		return true
+	}
+	deadline := time.Now().Add(15 * time.Second)
+	for time.Now().Before(deadline) && !populated() {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !populated() {
+		t.Fatal("routing tables were not populated")
+	}
+
+	for i, target := range nodes {
+		searcher := nodes[(i*7+3)%len(nodes)]
+		if searcher == target {
+			continue
+		}
+		found, err := searcher.Lookup(target.Self().ID)
+		if err != nil {
+			t.Fatalf("lookup: %v", err)
+		}
+		if len(found) == 0 || found[0].ID != target.Self().ID {
+			t.Fatalf("lookup for node %d did not return it first: %v", i, found)
+		}
+	}
+}
+
+func TestDiscoveryEvictsDeadNodes(t *testing.T) {
+	nodes := startDiscoveryNetwork(t, 3, 200*time.Millisecond)
+	time.Sleep(500 * time.Millisecond)
+	if len(nodes[0].Nodes()) != 2 {
+		t.Fatalf("expected bootnode to know 2 nodes, got %d", len(nodes[0].Nodes()))
+	}
+
+	dead := nodes[2].Self().ID
+	nodes[2].Close()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		alive := true
+		for _, node := range nodes[0].Nodes() {
+			if node.ID == dead {
+				alive = false
+			}
+		}
+		if alive {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("dead node was never evicted from the table")
+}
+
+func TestServerDialsNodesFoundByDiscovery(t *testing.T) {
+	cfg := Config{DiscoveryAddr: "127.0.0.1:0", DiscoveryRefresh: 200 * time.Millisecond, PEXInterval: 200 * time.Millisecond}
+	boot := newTestServer(t, cfg)
+	cfg.Bootnodes = []string{boot.Discovery().Self().UDPAddr}
+	a := newTestServer(t, cfg)
+	b := newTestServer(t, cfg)
+
+	want := map[*Server]int{boot: 2, a: 2, b: 2}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		done := true
+		for s, n := range want {
+			if len(s.Peers()) < n {
+				done = false
+			}
+		}
+		if done {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("servers did not connect via discovery: boot=%d a=%d b=%d", len(boot.Peers()), len(a.Peers()), len(b.Peers()))
+}
+
+// rawDiscoveryPeer speaks the discovery protocol by hand so a test can
+// decide which packets get answered.
+type rawDiscoveryPeer struct {
+	t    *testing.T
+	key  ed25519.PrivateKey
+	conn *net.UDPConn
+}
+
+func newRawDiscoveryPeer(t *testing.T) *rawDiscoveryPeer {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &rawDiscoveryPeer{t: t, key: key, conn: conn}
+}
+
+func (p *rawDiscoveryPeer) send(to string, msg discMessage) {
+	msg.Expires = time.Now().Add(packetTTL).Unix()
+	raw, _ := json.Marshal(msg)
+	packet, _ := json.Marshal(discPacket{
+		PubKey: p.key.Public().(ed25519.PublicKey),
+		Sig:    ed25519.Sign(p.key, append([]byte(discDomain), raw...)),
+		Msg:    raw,
+	})
+	addr, _ := net.ResolveUDPAddr("udp", to)
+	if _, err := p.conn.WriteToUDP(packet, addr); err != nil {
+		p.t.Fatalf("send: %v", err)
+	}
+}
+
+// receive returns the next message of type want, skipping others.
+func (p *rawDiscoveryPeer) receive(want uint8) (discMessage, bool) {
+	buf := make([]byte, maxPacketSize)
+	p.conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		n, _, err := p.conn.ReadFromUDP(buf)
+		if err != nil {
+			return discMessage{}, false
+		}
+		var packet discPacket
+		var msg discMessage
+		if json.Unmarshal(buf[:n], &packet) != nil || json.Unmarshal(packet.Msg, &msg) != nil {
+			continue
+		}
+		if msg.Type == want {
+			return msg, true
+		}
+	}
+}
+
+func TestDiscoveryBondsBeforeTrustingEndpoints(t *testing.T) {
+	nodes := startDiscoveryNetwork(t, 2, time.Hour)
+	d := nodes[0]
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && len(d.Nodes()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	peer := newRawDiscoveryPeer(t)
+	target := d.Self().UDPAddr
+
+	// An endpoint that never returns our ping stays out of the table and
+	// gets no neighbors.
+	peer.send(target, discMessage{Type: discPing, Nonce: 1, TCPAddr: "127.0.0.1:1"})
+	if _, ok := peer.receive(discPong); !ok {
+		t.Fatal("expected a pong")
+	}
+	peer.send(target, discMessage{Type: discFindNode, Nonce: 2, Target: d.Self().ID})
+	if _, ok := peer.receive(discNeighbors); ok {
+		t.Fatal("answered FIND_NODE from an unbonded endpoint")
+	}
+	for _, node := range d.Nodes() {
+		if node.UDPAddr == peer.conn.LocalAddr().String() {
+			t.Fatal("unbonded endpoint entered the table")
+		}
+	}
+
+	// Once it answers the bonding ping, the query is served.
+	peer.send(target, discMessage{Type: discFindNode, Nonce: 3, Target: d.Self().ID})
+	ping, ok := peer.receive(discPing)
+	if !ok {
+		t.Fatal("expected a bonding ping")
+	}
+	peer.send(target, discMessage{Type: discPong, Nonce: ping.Nonce})
+	neighbors, ok := peer.receive(discNeighbors)
+	if !ok || neighbors.Nonce != 3 || len(neighbors.Nodes) != 1 {
+		t.Fatalf("expected neighbors after bonding, got %+v", neighbors)
+	}
+}
@@ -0,0 +1,180 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+var (
+	ErrNotSentry   = errors.New("p2p: sentry-only node refuses non-sentry peer")
+	ErrGated       = errors.New("p2p: connection refused by gater")
+	ErrIPLimit     = errors.New("p2p: too many inbound connections from IP")
+	ErrSubnetLimit = errors.New("p2p: too many inbound connections from subnet")
+)
+
+// ConnectionGater lets the embedding application veto connections. Each
+// hook reports whether the connection may proceed.
+type ConnectionGater interface {
+	// InterceptDial runs before dialing addr.
+	InterceptDial(addr string) bool
+	// InterceptAccept runs for an inbound connection before the handshake.
+	InterceptAccept(addr net.Addr) bool
+	// InterceptPeer runs once the handshake has authenticated the peer.
+	InterceptPeer(info PeerInfo) bool
+}
+
+// isUnconditionalLocked reports whether p is listed in UnconditionalPeerIDs
+// and so exempt from, and not counted against, every connection limit.
+func (s *Server) isUnconditionalLocked(p *Peer) bool {
+	return s.unconditional[p.info.ID]
+}
+
+// isPersistentLocked reports whether p is one of our persistent peers.
+func (s *Server) isPersistentLocked(p *Peer) bool {
+	if !p.info.Inbound && s.persistent[p.info.Addr] != nil {
+		return true
+	}
+	for _, pp := range s.persistent {
+		if pp.id == p.info.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// isReservedLocked reports whether p may use a reserved slot: it is listed
+// in ReservedPeers or is one of our persistent peers.
+func (s *Server) isReservedLocked(p *Peer) bool {
+	return s.reserved[p.info.ID] || s.isPersistentLocked(p)
+}
+
+// freeReservedLocked counts the reserved slots whose peers are not
+// connected.
+func (s *Server) freeReservedLocked() int {
+	free := 0
+	for id := range s.reserved {
+		if s.peers[id] == nil {
+			free++
+		}
+	}
+	for addr, pp := range s.persistent {
+		if s.reserved[pp.id] {
+			continue
+		}
+		connected := pp.id != "" && s.peers[pp.id] != nil
+		for _, peer := range s.peers {
+			if !peer.info.Inbound && peer.info.Addr == addr {
+				connected = true
+			}
+		}
+		if !connected {
+			free++
+		}
+	}
+	return free
+}
+
+// checkSlotsLocked enforces the connection limits for a new peer.
+func (s *Server) checkSlotsLocked(p *Peer) error {
+	if s.isUnconditionalLocked(p) {
+		return nil
+	}
+	if s.cfg.SentryOnly && !s.isPersistentLocked(p) {
+		return ErrNotSentry
+	}
+	reserved := s.isReservedLocked(p)
+	var total, inbound, outbound int
+	for _, peer := range s.peers {
+		if s.isUnconditionalLocked(peer) {
+			continue
+		}
+		total++
+		if s.isReservedLocked(peer) {
+			continue
+		}
+		if peer.info.Inbound {
+			inbound++
+		} else {
+			outbound++
+		}
+	}
+	if s.cfg.MaxPeers > 0 {
+		limit := s.cfg.MaxPeers
+		if !reserved {
+			limit -= s.freeReservedLocked()
+		}
+		if total >= limit {
+			return fmt.Errorf("%w: %d of %d slots in use or reserved", ErrTooManyPeers, total, s.cfg.MaxPeers)
+		}
+	}
+	if reserved {
+		return nil
+	}
+	switch {
+	case p.info.Inbound && s.cfg.MaxInbound > 0 && inbound >= s.cfg.MaxInbound:
+		return fmt.Errorf("%w: %d inbound", ErrTooManyPeers, inbound)
+	case !p.info.Inbound && s.cfg.MaxOutbound > 0 && outbound >= s.cfg.MaxOutbound:
+		return fmt.Errorf("%w: %d outbound", ErrTooManyPeers, outbound)
+	}
+	if p.info.Inbound {
+		return s.checkSourceLocked(p.info.Addr)
+	}
+	return nil
+}
+
+// checkSourceLocked applies the per-IP and per-subnet inbound caps to a
+// connection from addr.
+func (s *Server) checkSourceLocked(addr string) error {
+	if s.cfg.MaxInboundPerIP <= 0 && s.cfg.MaxInboundPerSubnet <= 0 {
+		return nil
+	}
+	ip := net.ParseIP(hostOf(addr))
+	if ip == nil {
+		return nil
+	}
+	subnet := subnetOf(ip)
+	var sameIP, sameSubnet int
+	for _, peer := range s.peers {
+		if !peer.info.Inbound || s.isUnconditionalLocked(peer) || s.isReservedLocked(peer) {
+			continue
+		}
+		other := net.ParseIP(hostOf(peer.info.Addr))
+		if other == nil {
+			continue
+		}
+		if other.Equal(ip) {
+			sameIP++
+		}
+		if subnetOf(other) == subnet {
+			sameSubnet++
+		}
+	}
+	if s.cfg.MaxInboundPerIP > 0 && sameIP >= s.cfg.MaxInboundPerIP {
+		return fmt.Errorf("%w: %s", ErrIPLimit, ip)
+	}
+	if s.cfg.MaxInboundPerSubnet > 0 && sameSubnet >= s.cfg.MaxInboundPerSubnet {
+		return fmt.Errorf("%w: %s", ErrSubnetLimit, subnet)
+	}
+	return nil
+}
+
+// subnetOf groups addresses the way an attacker renting hosts would get
+// them: /24 for IPv4 and /64 for IPv6.
+func subnetOf(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+// acceptAllowed screens an inbound connection before the handshake, so
+// over-limit sources cost no key exchange.
+func (s *Server) acceptAllowed(addr net.Addr) error {
+	if s.cfg.Gater != nil && !s.cfg.Gater.InterceptAccept(addr) {
+		return ErrGated
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkSourceLocked(addr.String())
+}
@@ -0,0 +1,136 @@
+package p2p
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type denyGater struct{ addr string }
+
+func (g denyGater) InterceptDial(addr string) bool     { return addr != g.addr }
+func (g denyGater) InterceptAccept(addr net.Addr) bool { return true }
+func (g denyGater) InterceptPeer(info PeerInfo) bool   { return true }
+
+func TestSubnetOf(t *testing.T) {
+	if got := subnetOf(net.ParseIP("10.1.2.3")); got != "10.1.2.0/24" {
+		t.Fatalf("unexpected v4 subnet %s", got)
+	}
+	if got := subnetOf(net.ParseIP("2001:db8::1")); got != "2001:db8::/64" {
+		t.Fatalf("unexpected v6 subnet %s", got)
+	}
+}
+
+func TestInboundLimitsAndUnconditionalPeers(t *testing.T) {
+	_, unconditionalKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unconditionalID := NodeIDFromPublicKey(unconditionalKey.Public().(ed25519.PublicKey))
+	a := newTestServer(t, Config{MaxInbound: 1, MaxInboundPerIP: 2, UnconditionalPeerIDs: []string{unconditionalID}})
+	addr := a.listener.Addr().String()
+
+	dial := func(key ed25519.PrivateKey) error {
+		s := newTestServer(t, Config{NodeKey: key, PEXInterval: time.Hour})
+		s.AddPeerAddress(addr)
+		_, err := s.dial(addr)
+		return err
+	}
+	if err := dial(nil); err != nil {
+		t.Fatalf("first inbound peer: %v", err)
+	}
+	// The remote side learns of the rejection only when the connection drops.
+	waitInbound := func(n int) {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) && len(a.Peers()) != n {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := len(a.Peers()); got != n {
+			t.Fatalf("expected %d peers, got %d", n, got)
+		}
+	}
+	waitInbound(1)
+	dial(nil)
+	time.Sleep(100 * time.Millisecond)
+	waitInbound(1)
+
+	// Unconditional peers get in even with the inbound slots full.
+	if err := dial(unconditionalKey); err != nil {
+		t.Fatalf("unconditional peer: %v", err)
+	}
+	waitInbound(2)
+
+	a.mu.RLock()
+	err = a.checkSourceLocked("127.0.0.1:1")
+	a.mu.RUnlock()
+	if err != nil {
+		t.Fatalf("unconditional peers must not count against the per-IP cap: %v", err)
+	}
+}
+
+func TestPerIPInboundCap(t *testing.T) {
+	a := newTestServer(t, Config{MaxInboundPerIP: 1})
+	b := newTestServer(t, Config{Seeds: []string{a.listener.Addr().String()}})
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && len(a.Peers()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.mu.RLock()
+	err := a.checkSourceLocked("127.0.0.1:1")
+	a.mu.RUnlock()
+	if !errors.Is(err, ErrIPLimit) {
+		t.Fatalf("expected ErrIPLimit with %v connected, got %v", b.ID(), err)
+	}
+}
+
+func TestGaterVetoesDial(t *testing.T) {
+	a := newTestServer(t, Config{})
+	addr := a.listener.Addr().String()
+	b := newTestServer(t, Config{Gater: denyGater{addr: addr}, PEXInterval: time.Hour})
+	if _, err := b.dial(addr); !errors.Is(err, ErrGated) {
+		t.Fatalf("expected ErrGated, got %v", err)
+	}
+}
+
+func TestReservedPeersKeepSlotsFree(t *testing.T) {
+	_, reservedKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reservedID := NodeIDFromPublicKey(reservedKey.Public().(ed25519.PublicKey))
+	a := newTestServer(t, Config{MaxPeers: 2, ReservedPeers: []string{reservedID}})
+	addr := a.listener.Addr().String()
+
+	dial := func(key ed25519.PrivateKey) {
+		s := newTestServer(t, Config{NodeKey: key, PEXInterval: time.Hour})
+		s.dial(addr)
+	}
+	waitPeers := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) && len(a.Peers()) != n {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := len(a.Peers()); got != n {
+			t.Fatalf("expected %d peers, got %d", n, got)
+		}
+	}
+
+	dial(nil)
+	waitPeers(1)
+	// The second slot is held for the reserved peer.
+	dial(nil)
+	time.Sleep(100 * time.Millisecond)
+	waitPeers(1)
+
+	dial(reservedKey)
+	waitPeers(2)
+
+	// Reserved peers count against MaxPeers, unlike unconditional ones.
+	dial(nil)
+	time.Sleep(100 * time.Millisecond)
+	waitPeers(2)
+}
@@ -0,0 +1,112 @@
+package p2p
+
+import (
+	"crypto/sha256"
+	"sync"
+
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+const (
+	DefaultSeenCacheSize = 10_000
+	// knownCacheSize bounds the hashes remembered per peer.
+	knownCacheSize = 1024
+)
+
+// gossipTypes are deduplicated by payload hash: a message already seen is
+// neither dispatched again nor sent to a peer known to have it.
+var gossipTypes = map[MessageType]bool{
+	MessageTypeTx:           true,
+	MessageTypeBlock:        true,
+	MessageTypeConsensus:    true,
+	MessageTypeCompactBlock: true,
+}
+
+// DefaultRelayTypes are forwarded to other peers as soon as they are first
+// seen. Transactions are not relayed here since only the mempool can tell
+// whether one is worth passing on.
+var DefaultRelayTypes = []MessageType{MessageTypeConsensus}
+
+// hashCache is a bounded set of hashes that forgets the oldest entry once
+// full.
+type hashCache struct {
+	mu    sync.Mutex
+	set   map[types.Hash]struct{}
+	order []types.Hash
+	next  int
+}
+
+func newHashCache(size int) *hashCache {
+	return &hashCache{
+		set:   make(map[types.Hash]struct{}, size),
+		order: make([]types.Hash, 0, size),
+	}
+}
+
+// Add inserts h and reports whether it was new.
+func (c *hashCache) Add(h types.Hash) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.set[h]; ok {
+		return false
+	}
+	if len(c.order) < cap(c.order) {
+		c.order = append(c.order, h)
+	} else {
+		delete(c.set, c.order[c.next])
+		c.order[c.next] = h
+		c.next = (c.next + 1) % len(c.order)
+	}
+	c.set[h] = struct{}{}
+	return true
+}
+
+func (c *hashCache) Has(h types.Hash) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.set[h]
+	return ok
+}
+
+func newSeenCaches(size int) map[MessageType]*hashCache {
+	if size <= 0 {
+		size = DefaultSeenCacheSize
+	}
+	caches := make(map[MessageType]*hashCache, len(gossipTypes))
+	for t := range gossipTypes {
+		caches[t] = newHashCache(size)
+	}
+	return caches
+}
+
+func newRelaySet(relay []MessageType) map[MessageType]bool {
+	if relay == nil {
+		relay = DefaultRelayTypes
+	}
+	set := make(map[MessageType]bool, len(relay))
+	for _, t := range relay {
+		set[t] = true
+	}
+	return set
+}
+
+func gossipHash(env Envelope) (types.Hash, bool) {
+	if !gossipTypes[env.Type] {
+		return types.Hash{}, false
+	}
+	return sha256.Sum256(env.Payload), true
+}
+
+// receiveGossip handles a deduplicated message from p: it is dispatched and,
+// for relayed types, forwarded to every peer not known to have it. Messages
+// seen before are dropped.
+func (s *Server) receiveGossip(p *Peer, env Envelope, h types.Hash) {
+	p.known.Add(h)
+	if !s.seen[env.Type].Add(h) {
+		return
+	}
+	s.dispatch(p.info, env)
+	if s.relay[env.Type] {
+		s.broadcast(p.info.ID, env)
+	}
+}
@@ -0,0 +1,71 @@
+package p2p
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+func TestHashCacheEvictsOldest(t *testing.T) {
+	c := newHashCache(2)
+	a, b, d := types.Hash{1}, types.Hash{2}, types.Hash{3}
+	if !c.Add(a) || !c.Add(b) || c.Add(a) {
+		t.Fatal("unexpected membership before eviction")
+	}
+	c.Add(d)
+	if c.Has(a) || !c.Has(b) || !c.Has(d) {
+		t.Fatal("expected the oldest hash to be evicted")
+	}
+}
+
+func TestGossipRelaysAcrossHopsWithoutEchoes(t *testing.T) {
+	// a - b - c in a line; a long PEX interval keeps c from dialing a.
+	cfg := func(seed *Server) Config {
+		c := Config{PEXInterval: time.Hour}
+		if seed != nil {
+			c.Seeds = []string{seed.listener.Addr().String()}
+		}
+		return c
+	}
+	a := newTestServer(t, cfg(nil))
+	b := newTestServer(t, cfg(a))
+	c := newTestServer(t, cfg(b))
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) && !(len(a.Peers()) == 1 && len(b.Peers()) == 2 && len(c.Peers()) == 1) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(b.Peers()) != 2 {
+		t.Fatalf("line topology not formed: a=%d b=%d c=%d", len(a.Peers()), len(b.Peers()), len(c.Peers()))
+	}
+
+	var got [3]atomic.Int32
+	for i, s := range []*Server{a, b, c} {
+		i, s := i, s
+		s.RegisterHandler(MessageTypeConsensus, func(PeerInfo, []byte) { got[i].Add(1) })
+		// Transactions are passed on by the handler, as gchain-node does.
+		s.RegisterHandler(MessageTypeTx, func(peer PeerInfo, payload []byte) {
+			s.BroadcastExcept(peer.ID, NewEnvelope(MessageTypeTx, payload, ""))
+		})
+	}
+
+	msg := NewEnvelope(MessageTypeConsensus, []byte(`{"height":1}`), "")
+	a.Broadcast(msg)
+	a.Broadcast(msg)
+	deadline = time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) && got[2].Load() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	a.Broadcast(NewEnvelope(MessageTypeTx, []byte(`{"nonce":1}`), ""))
+	time.Sleep(200 * time.Millisecond)
+
+	if got[0].Load() != 0 || got[1].Load() != 1 || got[2].Load() != 1 {
+		t.Fatalf("expected exactly one delivery per remote hop, got a=%d b=%d c=%d", got[0].Load(), got[1].Load(), got[2].Load())
+	}
+	h, _ := gossipHash(NewEnvelope(MessageTypeTx, []byte(`{"nonce":1}`), ""))
+	if !c.seen[MessageTypeTx].Has(h) {
+		t.Fatal("tx did not propagate to c")
+	}
+}
@@ -0,0 +1,222 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+// ProtocolVersion is bumped on every incompatible wire change.
+const ProtocolVersion uint32 = 1
+
+const (
+	maxHandshakeMsgSize = 4096
+	handshakeNonceSize  = 32
+	handshakeDomain     = "gchain-p2p-handshake"
+)
+
+var (
+	ErrChainIDMismatch      = errors.New("p2p: chain id mismatch")
+	ErrGenesisMismatch      = errors.New("p2p: genesis hash mismatch")
+	ErrVersionMismatch      = errors.New("p2p: protocol version mismatch")
+	ErrSelfConnection       = errors.New("p2p: connected to self")
+	ErrDuplicatePeer        = errors.New("p2p: duplicate peer connection")
+	ErrBadNodeID            = errors.New("p2p: node id does not match public key")
+	ErrBadHandshakeProof    = errors.New("p2p: invalid handshake signature")
+	ErrHandshakeKeyMismatch = errors.New("p2p: handshake key differs from authenticated key")
+)
+
+// NodeInfo is what a node announces about itself when a connection opens.
+type NodeInfo struct {
+	ID          string     `json:"id"`
+	PublicKey   []byte     `json:"public_key"`
+	ChainID     string     `json:"chain_id"`
+	GenesisHash types.Hash `json:"genesis_hash"`
+	Version     uint32     `json:"version"`
+	BestHeight  uint64     `json:"best_height"`
+	ListenAddr  string     `json:"listen_addr,omitempty"`
+	// Compression lists the frame compression algorithms the node accepts,
+	// in order of preference.
+	Compression []string `json:"compression,omitempty"`
+}
+
+type handshakeHello struct {
+	NodeInfo
+	Nonce []byte `json:"nonce"`
+}
+
+type handshakeProof struct {
+	Signature []byte `json:"signature"`
+}
+
+// NodeIDFromPublicKey derives a node ID: the hex encoded first 20 bytes of
+// the SHA-256 of the node's public key.
+func NodeIDFromPublicKey(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:20])
+}
+
+func (s *Server) localNodeInfo() NodeInfo {
+	pub := s.cfg.NodeKey.Public().(ed25519.PublicKey)
+	info := NodeInfo{
+		ID:          s.id,
+		PublicKey:   pub,
+		ChainID:     s.cfg.ChainID,
+		GenesisHash: s.cfg.GenesisHash,
+		Version:     ProtocolVersion,
+		ListenAddr:  s.cfg.ExternalAddr,
+	}
+	for _, c := range s.cfg.Compression {
+		info.Compression = append(info.Compression, string(c))
+	}
+	if info.ListenAddr == "" && s.listener != nil {
+		info.ListenAddr = s.listener.Addr().String()
+	}
+	if s.cfg.BestHeight != nil {
+		info.BestHeight = s.cfg.BestHeight()
+	}
+	return info
+}
+
+// upgradeConnection encrypts conn and runs the handshake over the secure
+// channel. The key announced in the handshake must be the one that
+// authenticated the channel, otherwise a peer could relay another node's
+// identity.
+func (s *Server) upgradeConnection(conn net.Conn) (net.Conn, NodeInfo, error) {
+	if s.cfg.HandshakeTimeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(s.cfg.HandshakeTimeout)); err != nil {
+			return nil, NodeInfo{}, err
+		}
+	}
+	secure, err := MakeSecretConnection(conn, s.cfg.NodeKey)
+	if err != nil {
+		return nil, NodeInfo{}, fmt.Errorf("secret connection: %w", err)
+	}
+	node, err := s.handshake(secure)
+	if err != nil {
+		return nil, NodeInfo{}, err
+	}
+	if !bytes.Equal(node.PublicKey, secure.RemotePubKey()) {
+		return nil, NodeInfo{}, ErrHandshakeKeyMismatch
+	}
+	return secure, node, nil
+}
+
+// handshake exchanges NodeInfo with the remote side and proves ownership of
+// the announced key by signing the peer's nonce. The whole exchange must
+// finish within HandshakeTimeout.
+func (s *Server) handshake(conn net.Conn) (NodeInfo, error) {
+	if s.cfg.HandshakeTimeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(s.cfg.HandshakeTimeout)); err != nil {
+			return NodeInfo{}, err
+		}
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	local := handshakeHello{NodeInfo: s.localNodeInfo(), Nonce: make([]byte, handshakeNonceSize)}
+	if _, err := rand.Read(local.Nonce); err != nil {
+		return NodeInfo{}, fmt.Errorf("handshake nonce: %w", err)
+	}
+
+	var remote handshakeHello
+	if err := exchangeHandshakeMsg(conn, local, &remote); err != nil {
+		return NodeInfo{}, fmt.Errorf("exchange hello: %w", err)
+	}
+	if err := s.checkNodeInfo(remote.NodeInfo); err != nil {
+		return NodeInfo{}, err
+	}
+	if len(remote.Nonce) != handshakeNonceSize {
+		return NodeInfo{}, fmt.Errorf("handshake: bad nonce length %d", len(remote.Nonce))
+	}
+
+	proof := handshakeProof{Signature: ed25519.Sign(s.cfg.NodeKey, handshakeChallenge(remote.Nonce, local.Nonce))}
+	var remoteProof handshakeProof
+	if err := exchangeHandshakeMsg(conn, proof, &remoteProof); err != nil {
+		return NodeInfo{}, fmt.Errorf("exchange proof: %w", err)
+	}
+	if !ed25519.Verify(remote.PublicKey, handshakeChallenge(local.Nonce, remote.Nonce), remoteProof.Signature) {
+		return NodeInfo{}, ErrBadHandshakeProof
+	}
+	return remote.NodeInfo, nil
+}
+
+func (s *Server) checkNodeInfo(info NodeInfo) error {
+	if len(info.PublicKey) != ed25519.PublicKeySize || NodeIDFromPublicKey(info.PublicKey) != info.ID {
+		return ErrBadNodeID
+	}
+	if info.ID == s.id {
+		return ErrSelfConnection
+	}
+	if info.Version != ProtocolVersion {
+		return fmt.Errorf("%w: got %d want %d", ErrVersionMismatch, info.Version, ProtocolVersion)
+	}
+	if info.ChainID != s.cfg.ChainID {
+		return fmt.Errorf("%w: got %q want %q", ErrChainIDMismatch, info.ChainID, s.cfg.ChainID)
+	}
+	if info.GenesisHash != s.cfg.GenesisHash {
+		return ErrGenesisMismatch
+	}
+	return nil
+}
+
+// handshakeChallenge is what a node signs: the peer's nonce followed by its
+// own, so a proof cannot be replayed on another connection.
+func handshakeChallenge(peerNonce, ownNonce []byte) []byte {
+	msg := make([]byte, 0, len(handshakeDomain)+len(peerNonce)+len(ownNonce))
+	msg = append(msg, handshakeDomain...)
+	msg = append(msg, peerNonce...)
+	return append(msg, ownNonce...)
+}
+
+// exchangeHandshakeMsg writes out while reading into in. Writing happens in
+// the background so two peers sending at once cannot deadlock on an
+// unbuffered connection.
+func exchangeHandshakeMsg(conn net.Conn, out interface{}, in interface{}) error {
+	errc := make(chan error, 1)
+	go func() { errc <- writeHandshakeMsg(conn, out) }()
+	if err := readHandshakeMsg(conn, in); err != nil {
+		return err
+	}
+	return <-errc
+}
+
+// Handshake messages are length-prefixed rather than streamed through a
+// json.Decoder so nothing past the handshake is read ahead of readLoop.
+func writeHandshakeMsg(w io.Writer, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	copy(buf[4:], payload)
+	_, err = w.Write(buf)
+	return err
+}
+
+func readHandshakeMsg(r io.Reader, v interface{}) error {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxHandshakeMsgSize {
+		return fmt.Errorf("handshake message too large: %d bytes", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
@@ -0,0 +1,122 @@
+package p2p
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, cfg Config) *Server {
+	t.Helper()
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = "127.0.0.1:0"
+	}
+	if cfg.HandshakeTimeout == 0 {
+		cfg.HandshakeTimeout = 2 * time.Second
+	}
+	s := NewServer(cfg)
+	if err := s.Start(); err != nil {
+		t.Fatalf("start server: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func handshakePair(a, b *Server) (NodeInfo, error, NodeInfo, error) {
+	connA, connB := net.Pipe()
+	defer connA.Close()
+	defer connB.Close()
+
+	type result struct {
+		info NodeInfo
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		info, err := b.handshake(connB)
+		if err != nil {
+			connB.Close()
+		}
+		done <- result{info, err}
+	}()
+	infoA, errA := a.handshake(connA)
+	if errA != nil {
+		connA.Close()
+	}
+	res := <-done
+	return infoA, errA, res.info, res.err
+}
+
+func TestHandshakeExchangesIdentity(t *testing.T) {
+	a := NewServer(Config{ChainID: "test", HandshakeTimeout: time.Second, BestHeight: func() uint64 { return 7 }})
+	b := NewServer(Config{ChainID: "test", HandshakeTimeout: time.Second})
+
+	infoA, errA, infoB, errB := handshakePair(a, b)
+	if errA != nil || errB != nil {
+		t.Fatalf("handshake failed: %v / %v", errA, errB)
+	}
+	if infoA.ID != b.ID() || infoB.ID != a.ID() {
+		t.Fatalf("unexpected peer ids %s / %s", infoA.ID, infoB.ID)
+	}
+	if infoB.BestHeight != 7 {
+		t.Fatalf("expected best height 7, got %d", infoB.BestHeight)
+	}
+	if infoA.ID != NodeIDFromPublicKey(ed25519.PublicKey(infoA.PublicKey)) {
+		t.Fatal("node id not derived from public key")
+	}
+}
+
+func TestHandshakeRejectsChainMismatch(t *testing.T) {
+	a := NewServer(Config{ChainID: "main", HandshakeTimeout: time.Second})
+	b := NewServer(Config{ChainID: "test", HandshakeTimeout: time.Second})
+
+	_, errA, _, errB := handshakePair(a, b)
+	if !errors.Is(errA, ErrChainIDMismatch) || !errors.Is(errB, ErrChainIDMismatch) {
+		t.Fatalf("expected chain id mismatch on both sides, got %v / %v", errA, errB)
+	}
+}
+
+func TestServerRejectsSelfConnection(t *testing.T) {
+	s := newTestServer(t, Config{})
+	addr := s.listener.Addr().String()
+	s.AddPeerAddress(addr)
+
+	if _, err := s.dial(addr); !errors.Is(err, ErrSelfConnection) {
+		t.Fatalf("expected ErrSelfConnection, got %v", err)
+	}
+	if peers := s.Peers(); len(peers) != 0 {
+		t.Fatalf("expected no peers after dialing self, got %v", peers)
+	}
+	if s.book.Size() != 0 {
+		t.Fatal("own address should be dropped from the address book")
+	}
+}
+
+func TestServerDedupsDuplicateConnections(t *testing.T) {
+	a := newTestServer(t, Config{})
+	b := newTestServer(t, Config{Seeds: []string{a.listener.Addr().String()}})
+	conn, err := net.Dial("tcp", b.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial b: %v", err)
+	}
+	go a.handleConnection(conn, false)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if len(a.Peers()) == 1 && len(b.Peers()) == 1 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	peersA, peersB := a.Peers(), b.Peers()
+	if len(peersA) != 1 || len(peersB) != 1 {
+		t.Fatalf("expected exactly one peer each, got %d and %d", len(peersA), len(peersB))
+	}
+	if peersA[0].ID != b.ID() || peersB[0].ID != a.ID() {
+		t.Fatal("peers registered under the wrong ids")
+	}
+}
@@ -0,0 +1,127 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/metrics"
+)
+
+const (
+	DefaultPingInterval = 15 * time.Second
+	// DefaultPeerTimeout leaves room for a couple of lost pings before a
+	// silent peer is dropped.
+	DefaultPeerTimeout = 45 * time.Second
+)
+
+type pingMsg struct {
+	Nonce uint64 `json:"nonce"`
+}
+
+func (s *Server) pingInterval() time.Duration {
+	if s.cfg.PingInterval > 0 {
+		return s.cfg.PingInterval
+	}
+	return DefaultPingInterval
+}
+
+func (s *Server) peerTimeout() time.Duration {
+	if s.cfg.PeerTimeout > 0 {
+		return s.cfg.PeerTimeout
+	}
+	return DefaultPeerTimeout
+}
+
+// pingLoop pings p every PingInterval. Pongs keep the peer's read deadline
+// moving and yield its round-trip time.
+func (s *Server) pingLoop(p *Peer) {
+	ticker := time.NewTicker(s.pingInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.ping(p)
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (s *Server) ping(p *Peer) {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return
+	}
+	nonce := binary.BigEndian.Uint64(buf[:])
+	s.mu.Lock()
+	p.pingNonce = nonce
+	p.pingSent = time.Now()
+	s.mu.Unlock()
+	s.sendTo(p, NewEnvelope(MessageTypePing, MustMarshalPayload(pingMsg{Nonce: nonce}), ""))
+}
+
+func (s *Server) handlePing(p *Peer, payload []byte) {
+	var msg pingMsg
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		s.ReportPeer(p.info.ID, PenaltyBadPayload, "undecodable ping")
+		return
+	}
+	s.sendTo(p, NewEnvelope(MessageTypePong, MustMarshalPayload(msg), ""))
+}
+
+// handlePong records the round trip of the outstanding ping. Pongs with a
+// stale or unknown nonce are ignored.
+func (s *Server) handlePong(p *Peer, payload []byte) {
+	var msg pingMsg
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		s.ReportPeer(p.info.ID, PenaltyBadPayload, "undecodable pong")
+		return
+	}
+	s.mu.Lock()
+	if p.pingSent.IsZero() || msg.Nonce != p.pingNonce {
+		s.mu.Unlock()
+		return
+	}
+	sample := time.Since(p.pingSent)
+	p.pingSent = time.Time{}
+	if p.info.RTT == 0 {
+		p.info.RTT = sample
+	} else {
+		// Smooth like TCP's SRTT so one slow pong does not reorder peers.
+		p.info.RTT = (7*p.info.RTT + sample) / 8
+	}
+	rtt := p.info.RTT
+	s.mu.Unlock()
+	metrics.SetPeerRTT(p.info.ID, rtt)
+}
+
+// extendReadDeadline gives p another PeerTimeout to send something.
+func (s *Server) extendReadDeadline(p *Peer) {
+	if err := p.conn.SetReadDeadline(time.Now().Add(s.peerTimeout())); err != nil {
+		log.Printf("p2p: set read deadline for %s: %v", p.info.ID, err)
+	}
+}
+
+// PeersByLatency returns the connected peers ordered by round-trip time,
+// fastest first, for picking whom to ask for blocks.
+func (s *Server) PeersByLatency() []PeerInfo {
+	peers := s.Peers()
+	SortByLatency(peers)
+	return peers
+}
+
+// SortByLatency orders peers by RTT, fastest first. Peers not yet measured
+// come last.
+func SortByLatency(peers []PeerInfo) {
+	sort.SliceStable(peers, func(i, j int) bool {
+		a, b := peers[i].RTT, peers[j].RTT
+		if a == 0 || b == 0 {
+			return a != 0
+		}
+		return a < b
+	})
+}
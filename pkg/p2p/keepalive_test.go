@@ -0,0 +1,64 @@
+package p2p
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPingMeasuresRTT(t *testing.T) {
+	a := newTestServer(t, Config{PingInterval: 20 * time.Millisecond})
+	b := newTestServer(t, Config{
+		Seeds:        []string{a.listener.Addr().String()},
+		PingInterval: 20 * time.Millisecond,
+	})
+
+	measured := func(s *Server) bool {
+		peers := s.PeersByLatency()
+		return len(peers) == 1 && peers[0].RTT > 0
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) && !(measured(a) && measured(b)) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !measured(a) || !measured(b) {
+		t.Fatalf("rtt not measured: a=%+v b=%+v", a.Peers(), b.Peers())
+	}
+}
+
+func TestSilentPeerIsDropped(t *testing.T) {
+	a := newTestServer(t, Config{PingInterval: time.Hour, PeerTimeout: 200 * time.Millisecond})
+
+	// A peer that completes the handshake and then never says anything.
+	silent := NewServer(Config{HandshakeTimeout: 2 * time.Second})
+	conn, err := net.Dial("tcp", a.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if _, _, err := silent.upgradeConnection(conn); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) && len(a.Peers()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(a.Peers()) != 1 {
+		t.Fatal("silent peer never registered")
+	}
+	for time.Now().Before(deadline) && len(a.Peers()) != 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(a.Peers()) != 0 {
+		t.Fatal("silent peer was not dropped after PeerTimeout")
+	}
+}
+
+func TestSortByLatency(t *testing.T) {
+	peers := []PeerInfo{{ID: "unmeasured"}, {ID: "slow", RTT: 50 * time.Millisecond}, {ID: "fast", RTT: time.Millisecond}}
+	SortByLatency(peers)
+	if peers[0].ID != "fast" || peers[1].ID != "slow" || peers[2].ID != "unmeasured" {
+		t.Fatalf("unexpected order: %+v", peers)
+	}
+}
@@ -0,0 +1,333 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryHubConfig shapes the links of a MemoryHub. Every message is delayed
+// by Latency plus up to Jitter and dropped with probability Loss. Seed makes
+// the loss and jitter draws reproducible.
+type MemoryHubConfig struct {
+	Latency time.Duration
+	Jitter  time.Duration
+	Loss    float64
+	Seed    int64
+}
+
+// MemoryHub connects in-process transports by name so multi-node setups can
+// be tested without sockets. All nodes are fully connected unless the hub is
+// partitioned.
+type MemoryHub struct {
+	mu        sync.Mutex
+	cfg       MemoryHubConfig
+	rng       *rand.Rand
+	nodes     map[string]*MemoryTransport
+	partition map[string]int
+}
+
+func NewMemoryHub(cfg MemoryHubConfig) *MemoryHub {
+	return &MemoryHub{
+		cfg:   cfg,
+		rng:   rand.New(rand.NewSource(cfg.Seed)),
+		nodes: make(map[string]*MemoryTransport),
+	}
+}
+
+// Connect returns the transport for node name, which doubles as its peer ID.
+// Messages reach it once it is started.
+func (h *MemoryHub) Connect(name string) (*MemoryTransport, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.nodes[name]; ok {
+		return nil, ErrDuplicatePeer
+	}
+	t := &MemoryTransport{
+		hub:       h,
+		name:      name,
+		handlers:  make(map[MessageType]HandlerFunc),
+		serve:     make(map[MessageType]RequestHandlerFunc),
+		requests:  newRequestTracker(),
+		penalties: make(map[string]int),
+		wake:      make(chan struct{}, 1),
+	}
+	h.nodes[name] = t
+	return t, nil
+}
+
+func (h *MemoryHub) SetLatency(latency, jitter time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cfg.Latency, h.cfg.Jitter = latency, jitter
+}
+
+func (h *MemoryHub) SetLoss(loss float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cfg.Loss = loss
+}
+
+// Partition splits the nodes into groups that cannot reach each other. Nodes
+// not named in any group form one further group together.
+func (h *MemoryHub) Partition(groups ...[]string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.partition = make(map[string]int)
+	for i, group := range groups {
+		for _, name := range group {
+			h.partition[name] = i + 1
+		}
+	}
+}
+
+// Heal removes any partition.
+func (h *MemoryHub) Heal() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.partition = nil
+}
+
+func (h *MemoryHub) reachableLocked(from, to string) bool {
+	return h.partition[from] == h.partition[to]
+}
+
+// send queues env for every running node reachable from from, except the
+// sender and except.
+func (h *MemoryHub) send(from, except string, env Envelope) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for name, node := range h.nodes {
+		if name == from || name == except || !h.reachableLocked(from, name) {
+			continue
+		}
+		h.deliverLocked(from, node, env)
+	}
+}
+
+// sendTo queues env for node to alone.
+func (h *MemoryHub) sendTo(from, to string, env Envelope) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	node, ok := h.nodes[to]
+	if !ok || to == from || !node.running() || !h.reachableLocked(from, to) {
+		return fmt.Errorf("%w: %s", ErrUnknownPeer, to)
+	}
+	h.deliverLocked(from, node, env)
+	return nil
+}
+
+func (h *MemoryHub) deliverLocked(from string, node *MemoryTransport, env Envelope) {
+	if h.cfg.Loss > 0 && h.rng.Float64() < h.cfg.Loss {
+		return
+	}
+	delay := h.cfg.Latency
+	if h.cfg.Jitter > 0 {
+		delay += time.Duration(h.rng.Int63n(int64(h.cfg.Jitter)))
+	}
+	node.enqueue(memoryDelivery{from: from, env: env.Clone(), at: time.Now().Add(delay)})
+}
+
+func (h *MemoryHub) peers(of string) []PeerInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []PeerInfo
+	for name, node := range h.nodes {
+		if name != of && node.running() && h.reachableLocked(of, name) {
+			out = append(out, PeerInfo{ID: name, Addr: name})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+type memoryDelivery struct {
+	from string
+	env  Envelope
+	at   time.Time
+}
+
+// MemoryTransport is a Transport attached to a MemoryHub. Each node handles
+// its messages one at a time in arrival order, like a TCP peer's read loop.
+type MemoryTransport struct {
+	hub  *MemoryHub
+	name string
+
+	mu        sync.Mutex
+	handlers  map[MessageType]HandlerFunc
+	serve     map[MessageType]RequestHandlerFunc
+	requests  *requestTracker
+	penalties map[string]int
+	inbox     []memoryDelivery
+	wake      chan struct{}
+	quit      chan struct{}
+}
+
+func (t *MemoryTransport) ID() string {
+	return t.name
+}
+
+func (t *MemoryTransport) Start() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.quit != nil {
+		return nil
+	}
+	t.quit = make(chan struct{})
+	go t.deliverLoop(t.quit)
+	return nil
+}
+
+// Close stops delivery; messages queued for the node are discarded. The
+// transport can be started again to rejoin the hub.
+func (t *MemoryTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.quit != nil {
+		close(t.quit)
+		t.quit = nil
+	}
+	t.inbox = nil
+	return nil
+}
+
+func (t *MemoryTransport) Broadcast(env Envelope) {
+	t.hub.send(t.name, env.PeerID, env)
+}
+
+func (t *MemoryTransport) BroadcastExcept(peerID string, env Envelope) {
+	t.hub.send(t.name, peerID, env)
+}
+
+func (t *MemoryTransport) RegisterHandler(msgType MessageType, handler HandlerFunc) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handlers[msgType] = handler
+}
+
+func (t *MemoryTransport) Send(peerID string, env Envelope) error {
+	return t.hub.sendTo(t.name, peerID, env)
+}
+
+// Request waits for the response of peerID's request handler. Lost
+// messages make it time out like on a real network.
+func (t *MemoryTransport) Request(ctx context.Context, peerID string, env Envelope) (Envelope, error) {
+	return t.requests.do(ctx, 0, peerID, env, func(req Envelope) error {
+		return t.Send(peerID, req)
+	})
+}
+
+func (t *MemoryTransport) RegisterRequestHandler(msgType MessageType, handler RequestHandlerFunc) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.serve[msgType] = handler
+}
+
+func (t *MemoryTransport) requestHandler(msgType MessageType) RequestHandlerFunc {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.serve[msgType]
+}
+
+// ReportPeer records the penalty; the hub never disconnects anyone.
+func (t *MemoryTransport) ReportPeer(peerID string, penalty int, reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.penalties[peerID] += penalty
+}
+
+// Penalty is the sum of penalties this node reported against peerID.
+func (t *MemoryTransport) Penalty(peerID string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.penalties[peerID]
+}
+
+// Peers returns the running nodes this one can currently reach.
+func (t *MemoryTransport) Peers() []PeerInfo {
+	return t.hub.peers(t.name)
+}
+
+// PeersByLatency returns Peers in the order Server.PeersByLatency would. The
+// hub does not measure round trips, so every peer counts as unmeasured.
+func (t *MemoryTransport) PeersByLatency() []PeerInfo {
+	peers := t.Peers()
+	SortByLatency(peers)
+	return peers
+}
+
+func (t *MemoryTransport) running() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.quit != nil
+}
+
+func (t *MemoryTransport) enqueue(d memoryDelivery) {
+	t.mu.Lock()
+	if t.quit == nil {
+		t.mu.Unlock()
+		return
+	}
+	t.inbox = append(t.inbox, d)
+	t.mu.Unlock()
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (t *MemoryTransport) deliverLoop(quit chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		t.mu.Lock()
+		if t.quit != quit {
+			// Closed, and possibly restarted with a new loop.
+			t.mu.Unlock()
+			return
+		}
+		if len(t.inbox) == 0 {
+			t.mu.Unlock()
+			select {
+			case <-t.wake:
+				continue
+			case <-quit:
+				return
+			}
+		}
+		next := t.inbox[0]
+		if wait := time.Until(next.at); wait > 0 {
+			t.mu.Unlock()
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+				continue
+			case <-quit:
+				return
+			}
+		}
+		t.inbox = t.inbox[1:]
+		handler := t.handlers[next.env.Type]
+		t.mu.Unlock()
+		peer := PeerInfo{ID: next.from, Addr: next.from}
+		switch next.env.Type {
+		case MessageTypeRequest:
+			go func() {
+				if resp, err := serveRPC(t.requestHandler, peer, next.env.Payload); err == nil {
+					t.Send(peer.ID, resp)
+				}
+			}()
+		case MessageTypeResponse:
+			if f, err := decodeRPC(next.env.Payload); err == nil {
+				t.requests.resolve(peer.ID, f)
+			}
+		default:
+			if handler != nil {
+				handler(peer, next.env.Payload)
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newMemoryNodes(t *testing.T, hub *MemoryHub, names ...string) []*MemoryTransport {
+	t.Helper()
+	nodes := make([]*MemoryTransport, len(names))
+	for i, name := range names {
+		node, err := hub.Connect(name)
+		if err != nil {
+			t.Fatalf("connect %s: %v", name, err)
+		}
+		if err := node.Start(); err != nil {
+			t.Fatalf("start %s: %v", name, err)
+		}
+		t.Cleanup(func() { node.Close() })
+		nodes[i] = node
+	}
+	return nodes
+}
+
+func collect(node *MemoryTransport, msgType MessageType) chan string {
+	got := make(chan string, 16)
+	node.RegisterHandler(msgType, func(peer PeerInfo, payload []byte) {
+		got <- peer.ID + ":" + string(payload)
+	})
+	return got
+}
+
+func expectMessage(t *testing.T, got chan string, want string) {
+	t.Helper()
+	select {
+	case msg := <-got:
+		if msg != want {
+			t.Fatalf("got %q, want %q", msg, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for %q", want)
+	}
+}
+
+func expectNothing(t *testing.T, got chan string) {
+	t.Helper()
+	select {
+	case msg := <-got:
+		t.Fatalf("unexpected message %q", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemoryHubDeliversInOrderWithLatency(t *testing.T) {
+	hub := NewMemoryHub(MemoryHubConfig{Latency: 30 * time.Millisecond, Jitter: 10 * time.Millisecond, Seed: 1})
+	nodes := newMemoryNodes(t, hub, "a", "b")
+	got := collect(nodes[1], MessageTypeTx)
+	self := collect(nodes[0], MessageTypeTx)
+
+	if _, err := hub.Connect("a"); err != ErrDuplicatePeer {
+		t.Fatalf("expected duplicate name to be rejected, got %v", err)
+	}
+
+	start := time.Now()
+	for _, payload := range []string{"1", "2", "3"} {
+		nodes[0].Broadcast(NewEnvelope(MessageTypeTx, []byte(payload), ""))
+	}
+	for _, payload := range []string{"1", "2", "3"} {
+		expectMessage(t, got, "a:"+payload)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("messages arrived after %v, before the configured latency", elapsed)
+	}
+	expectNothing(t, self)
+}
+
+func TestMemoryHubLossAndPartitions(t *testing.T) {
+	hub := NewMemoryHub(MemoryHubConfig{})
+	nodes := newMemoryNodes(t, hub, "a", "b", "c")
+	gotB := collect(nodes[1], MessageTypeTx)
+	gotC := collect(nodes[2], MessageTypeTx)
+
+	hub.SetLoss(1)
+	nodes[0].Broadcast(NewEnvelope(MessageTypeTx, []byte("lost"), ""))
+	expectNothing(t, gotB)
+	hub.SetLoss(0)
+
+	hub.Partition([]string{"a", "b"})
+	if peers := nodes[2].Peers(); len(peers) != 0 {
+		t.Fatalf("partitioned node still sees %v", peers)
+	}
+	nodes[0].Broadcast(NewEnvelope(MessageTypeTx, []byte("split"), ""))
+	expectMessage(t, gotB, "a:split")
+	expectNothing(t, gotC)
+
+	hub.Heal()
+	nodes[0].BroadcastExcept("b", NewEnvelope(MessageTypeTx, []byte("healed"), ""))
+	expectMessage(t, gotC, "a:healed")
+	expectNothing(t, gotB)
+
+	nodes[2].Close()
+	nodes[0].Broadcast(NewEnvelope(MessageTypeTx, []byte("offline"), ""))
+	expectMessage(t, gotB, "a:offline")
+	expectNothing(t, gotC)
+}
+
+func TestMemoryHubRequests(t *testing.T) {
+	hub := NewMemoryHub(MemoryHubConfig{Latency: time.Millisecond})
+	nodes := newMemoryNodes(t, hub, "a", "b")
+	nodes[1].RegisterRequestHandler(MessageTypeBlock, func(peer PeerInfo, req Envelope) (Envelope, error) {
+		return NewEnvelope(MessageTypeBlock, append([]byte(peer.ID+" asked "), req.Payload...), ""), nil
+	})
+	got := collect(nodes[1], MessageTypeTx)
+
+	resp, err := nodes[0].Request(context.Background(), "b", NewEnvelope(MessageTypeBlock, []byte("1"), ""))
+	if err != nil || string(resp.Payload) != "a asked 1" {
+		t.Fatalf("unexpected response %q, %v", resp.Payload, err)
+	}
+	if err := nodes[0].Send("b", NewEnvelope(MessageTypeTx, []byte("direct"), "")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	expectMessage(t, got, "a:direct")
+
+	hub.Partition([]string{"a"})
+	if err := nodes[0].Send("b", NewEnvelope(MessageTypeTx, nil, "")); !errors.Is(err, ErrUnknownPeer) {
+		t.Fatalf("expected ErrUnknownPeer across a partition, got %v", err)
+	}
+}
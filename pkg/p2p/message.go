@@ -1,6 +1,9 @@
 package p2p
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 type MessageType uint8
 
@@ -10,8 +13,46 @@ const (
 	MessageTypeConsensus
 	MessageTypePing
 	MessageTypePong
+	MessageTypeRaft
+	MessageTypePexRequest
+	MessageTypePexAddrs
+	MessageTypeRequest
+	MessageTypeResponse
+	MessageTypeCompactBlock
+	MessageTypeBlockTxs
 )
 
+func (t MessageType) String() string {
+	switch t {
+	case MessageTypeTx:
+		return "tx"
+	case MessageTypeBlock:
+		return "block"
+	case MessageTypeConsensus:
+		return "consensus"
+	case MessageTypePing:
+		return "ping"
+	case MessageTypePong:
+		return "pong"
+	case MessageTypeRaft:
+		return "raft"
+	case MessageTypePexRequest:
+		return "pex_request"
+	case MessageTypePexAddrs:
+		return "pex_addrs"
+	case MessageTypeRequest:
+		return "request"
+	case MessageTypeResponse:
+		return "response"
+	case MessageTypeCompactBlock:
+		return "compact_block"
+	case MessageTypeBlockTxs:
+		return "block_txs"
+	default:
+		return fmt.Sprintf("type_%d", uint8(t))
+	}
+}
+
 type Envelope struct {
 	Type    MessageType `json:"type"`
 	Payload []byte      `json:"payload"`
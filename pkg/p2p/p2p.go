@@ -1,22 +1,133 @@
 package p2p
 
 import (
+	"context"
+	"crypto/ed25519"
 	"sync"
 	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/types"
 )
 
 type Config struct {
-	ListenAddr       string
-	Seeds            []string
-	MaxPeers         int
+	ListenAddr string
+	// Seeds are dialed until the first successful connection.
+	Seeds []string
+	// PersistentPeers are redialed whenever their connection drops, with
+	// exponential backoff between DialBackoffBase and DialBackoffMax.
+	PersistentPeers    []string
+	DialBackoffBase    time.Duration
+	DialBackoffMax     time.Duration
+	MaxConcurrentDials int
+
+	// MaxPeers caps all connections; MaxInbound and MaxOutbound cap each
+	// direction so inbound connections cannot crowd out the peers we chose.
+	// MaxInboundPerIP and MaxInboundPerSubnet (/24 or /64) limit how much of
+	// the inbound budget one source can take. Zero means no limit.
+	MaxPeers            int
+	MaxInbound          int
+	MaxOutbound         int
+	MaxInboundPerIP     int
+	MaxInboundPerSubnet int
+	// ReservedPeers (node IDs) and persistent peers have slots reserved for
+	// them within MaxPeers: while one is disconnected its slot is held free
+	// of other peers. They count against MaxPeers but not the direction or
+	// per-source caps.
+	ReservedPeers []string
+	// UnconditionalPeerIDs bypass every limit and are not counted.
+	UnconditionalPeerIDs []string
+	// Gater, if set, is consulted before every dial and accept.
+	Gater ConnectionGater
+	// PrivatePeerIDs are never advertised to other peers, e.g. validators
+	// behind sentries.
+	PrivatePeerIDs []string
+	// SentryOnly confines a validator to its PersistentPeers (its sentries)
+	// and UnconditionalPeerIDs: it accepts and dials nobody else and takes
+	// no part in seeding, PEX or discovery.
+	SentryOnly bool
+
 	HandshakeTimeout time.Duration
-	ReadBufferSize   int
-	WriteBufferSize  int
+	// PingInterval is how often peers are pinged; a peer that sends nothing
+	// for PeerTimeout is disconnected. Zero values use the package defaults.
+	PingInterval time.Duration
+	PeerTimeout  time.Duration
+	// RequestTimeout bounds Request; zero uses DefaultRequestTimeout.
+	RequestTimeout time.Duration
+	// MaxInflightRequests caps how many of one peer's requests are served
+	// at once; further requests are dropped and the peer penalised. Zero
+	// uses DefaultMaxInflightRequests.
+	MaxInflightRequests int
+
+	ReadBufferSize  int
+	WriteBufferSize int
+	// Compression lists the algorithms this node offers, in order of
+	// preference; each connection uses the first one both ends support.
+	// Payloads under CompressionThreshold bytes are sent as-is.
+	Compression          []Compression
+	CompressionThreshold int
+	// MaxMessageSize caps every frame's payload; MaxMessageSizes overrides
+	// it per message type. Peers exceeding a limit are disconnected.
+	MaxMessageSize  int
+	MaxMessageSizes map[MessageType]int
+	// SeenCacheSize bounds how many tx, block and consensus hashes are
+	// remembered per type to drop duplicate gossip. RelayTypes are
+	// forwarded to other peers when first seen; nil uses DefaultRelayTypes.
+	SeenCacheSize int
+	RelayTypes    []MessageType
+	// MessageRateLimits cap how many messages of each type one peer may send;
+	// excess messages are dropped. Nil uses DefaultMessageRateLimits.
+	// RecvRate and SendRate cap per-peer bytes per second; zero is unlimited.
+	MessageRateLimits map[MessageType]RateLimit
+	RecvRate          int
+	SendRate          int
+
+	// NodeKey identifies this node; the node ID is derived from its public
+	// key. A random key is generated when unset.
+	NodeKey ed25519.PrivateKey
+	// Peers must agree on ChainID and GenesisHash to connect.
+	ChainID     string
+	GenesisHash types.Hash
+	// BestHeight reports the local chain height during handshakes.
+	BestHeight func() uint64
+	// ExternalAddr is the address advertised to peers; defaults to the
+	// listener address.
+	ExternalAddr string
+
+	// AddrBookPath persists known peer addresses; empty keeps them in memory.
+	AddrBookPath string
+	// MaxAddrBookSize caps the address book; zero uses DefaultAddrBookSize.
+	MaxAddrBookSize int
+	// TargetOutbound is how many outbound peers the server keeps dialing
+	// addresses from its book to maintain. Zero uses DefaultTargetOutbound.
+	TargetOutbound int
+	// PEXInterval is how often outbound peers are topped up and addresses
+	// requested. Zero uses DefaultPEXInterval.
+	PEXInterval time.Duration
+	// SeedMode nodes crawl the network for addresses, hand them to every
+	// peer that connects, then disconnect.
+	SeedMode bool
+
+	// DiscoveryAddr enables the Kademlia DHT on this UDP address; nodes it
+	// finds are added to the address book. Bootnodes are DHT UDP addresses.
+	DiscoveryAddr    string
+	Bootnodes        []string
+	DiscoveryRefresh time.Duration
+
+	// Peers reported through ReportPeer are banned by node ID and IP for
+	// BanDuration once their penalty reaches BanThreshold. Penalties halve
+	// every ScoreHalfLife. Zero values use the package defaults.
+	BanThreshold  float64
+	BanDuration   time.Duration
+	ScoreHalfLife time.Duration
 }
 
 type PeerInfo struct {
-	ID   string
-	Addr string
+	ID         string
+	Addr       string
+	ListenAddr string
+	Inbound    bool
+	// RTT is the smoothed ping round-trip time, zero until measured.
+	RTT time.Duration
 }
 
 type HandlerFunc func(peer PeerInfo, payload []byte)
@@ -27,6 +138,22 @@ type Transport interface {
 	Broadcast(env Envelope)
 	BroadcastExcept(peerID string, env Envelope)
 	RegisterHandler(msgType MessageType, handler HandlerFunc)
+	// Send delivers env to one connected peer.
+	Send(peerID string, env Envelope) error
+	// Request sends env to one peer and waits for its response, matched by
+	// request ID. The peer answers from its RegisterRequestHandler handler
+	// for env.Type.
+	Request(ctx context.Context, peerID string, env Envelope) (Envelope, error)
+	RegisterRequestHandler(msgType MessageType, handler RequestHandlerFunc)
+	// ReportPeer penalizes a peer for misbehaviour, e.g. from a HandlerFunc
+	// that received an undecodable or invalid payload.
+	ReportPeer(peerID string, penalty int, reason string)
+}
+
+// PeerLister is implemented by transports that can report their connected
+// peers.
+type PeerLister interface {
+	Peers() []PeerInfo
 }
 
 type peerManager struct {
@@ -0,0 +1,238 @@
+package p2p
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	DefaultTargetOutbound = 10
+	DefaultPEXInterval    = 30 * time.Second
+
+	// maxPexAddrs bounds both the addresses we hand out and those we accept
+	// from one message.
+	maxPexAddrs = 100
+	// seedDisconnectDelay gives a seed's reply time to flush before it hangs up.
+	seedDisconnectDelay = 500 * time.Millisecond
+)
+
+type pexAddr struct {
+	ID   string `json:"id,omitempty"`
+	Addr string `json:"addr"`
+}
+
+type pexAddrs struct {
+	Addrs []pexAddr `json:"addrs"`
+}
+
+// AddPeerAddress adds addr to the address book so the server may dial it
+// when it needs more outbound peers.
+func (s *Server) AddPeerAddress(addr string) {
+	s.book.Add(addr, "")
+}
+
+// KnownAddresses returns a snapshot of the address book.
+func (s *Server) KnownAddresses() []KnownAddress {
+	return s.book.Sample(s.book.Size())
+}
+
+func (s *Server) targetOutbound() int {
+	target := DefaultTargetOutbound
+	if s.cfg.TargetOutbound > 0 {
+		target = s.cfg.TargetOutbound
+	}
+	if s.cfg.MaxOutbound > 0 {
+		target = min(target, s.cfg.MaxOutbound)
+	}
+	return target
+}
+
+func (s *Server) pexInterval() time.Duration {
+	if s.cfg.PEXInterval > 0 {
+		return s.cfg.PEXInterval
+	}
+	return DefaultPEXInterval
+}
+
+// peerAdded runs once a peer is registered: learn its listen address, ask
+// outbound peers for more addresses, and in seed mode serve and hang up.
+func (s *Server) peerAdded(p *Peer) {
+	if s.cfg.SentryOnly {
+		return
+	}
+	if p.info.Inbound && p.node.ListenAddr != "" {
+		s.book.Add(dialableAddr(p.node.ListenAddr, p.info.Addr), p.info.ID)
+	}
+	if !p.info.Inbound || s.cfg.SeedMode {
+		s.requestAddrs(p)
+	}
+	if s.cfg.SeedMode {
+		if p.info.Inbound {
+			s.sendAddrs(p)
+		}
+		time.AfterFunc(seedDisconnectDelay, func() { s.removePeer(p) })
+	}
+}
+
+func (s *Server) handlePexRequest(p *Peer) {
+	if s.cfg.SentryOnly {
+		return
+	}
+	s.mu.Lock()
+	limited := !p.lastPexRequest.IsZero() && time.Since(p.lastPexRequest) < s.pexInterval()/2
+	if !limited {
+		p.lastPexRequest = time.Now()
+	}
+	s.mu.Unlock()
+	if !limited {
+		s.sendAddrs(p)
+	}
+}
+
+func (s *Server) sendAddrs(p *Peer) {
+	var msg pexAddrs
+	for _, known := range s.book.Sample(maxPexAddrs + 1) {
+		if known.ID == p.info.ID || (known.ID != "" && known.ID == s.id) || s.private[known.ID] {
+			continue
+		}
+		if len(msg.Addrs) == maxPexAddrs {
+			break
+		}
+		msg.Addrs = append(msg.Addrs, pexAddr{ID: known.ID, Addr: known.Addr})
+	}
+	s.sendTo(p, NewEnvelope(MessageTypePexAddrs, MustMarshalPayload(msg), ""))
+}
+
+// requestAddrs asks p for addresses and marks the reply as expected.
+func (s *Server) requestAddrs(p *Peer) {
+	s.mu.Lock()
+	p.pexRequested = true
+	s.mu.Unlock()
+	s.sendTo(p, NewEnvelope(MessageTypePexRequest, nil, ""))
+}
+
+func (s *Server) handlePexAddrs(p *Peer, payload []byte) {
+	s.mu.Lock()
+	solicited := p.pexRequested
+	p.pexRequested = false
+	s.mu.Unlock()
+	if !solicited {
+		log.Printf("p2p: ignoring unsolicited pex addresses from %s", p.info.ID)
+		return
+	}
+	var msg pexAddrs
+	if err := json.Unmarshal(payload, &msg); err != nil || len(msg.Addrs) > maxPexAddrs {
+		log.Printf("p2p: dropping peer %s: invalid pex addresses", p.info.ID)
+		s.removePeer(p)
+		return
+	}
+	if s.cfg.SentryOnly {
+		return
+	}
+	for _, a := range msg.Addrs {
+		if a.ID == s.id {
+			continue
+		}
+		s.book.Add(a.Addr, a.ID)
+	}
+	if s.cfg.SeedMode && !p.info.Inbound {
+		// Crawled peers are only visited for their addresses.
+		s.removePeer(p)
+	}
+}
+
+func (s *Server) sendTo(p *Peer, env Envelope) {
+	select {
+	case p.outgoing <- env:
+	case <-p.quit:
+	default:
+		go s.removePeer(p)
+	}
+}
+
+func (s *Server) ensurePeersLoop() {
+	ticker := time.NewTicker(s.pexInterval())
+	defer ticker.Stop()
+	for {
+		s.ensurePeers()
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ensurePeers dials book addresses until the outbound target is met, asking
+// a connected peer for more addresses when the book runs dry.
+func (s *Server) ensurePeers() {
+	s.mu.RLock()
+	connected := make(map[string]bool, len(s.peers))
+	outbound := len(s.dialing)
+	var asked *Peer
+	for id, peer := range s.peers {
+		connected[id] = true
+		if !peer.info.Inbound {
+			outbound++
+		}
+		if asked == nil {
+			asked = peer
+		}
+	}
+	skip := make(map[string]bool, len(s.dialing))
+	for addr := range s.dialing {
+		skip[addr] = true
+	}
+	s.mu.RUnlock()
+
+	// Seeds only hand out addresses, so stop bothering them once we have
+	// any peer to exchange addresses with.
+	if len(connected) > 0 {
+		for _, seed := range s.cfg.Seeds {
+			skip[seed] = true
+		}
+	}
+	// Persistent peers have their own redial loops.
+	for addr := range s.persistent {
+		skip[addr] = true
+	}
+
+	need := s.targetOutbound() - outbound
+	for ; need > 0; need-- {
+		known, ok := s.book.Pick(s.pexInterval(), func(k KnownAddress) bool {
+			return skip[k.Addr] || k.ID == s.id || connected[k.ID] || s.isBanned(k.ID, k.Addr)
+		})
+		if !ok || !s.startDial(known.Addr) {
+			break
+		}
+		skip[known.Addr] = true
+		go func(addr string) {
+			defer s.finishDial(addr)
+			s.dial(addr)
+		}(known.Addr)
+	}
+	if need > 0 && asked != nil {
+		s.requestAddrs(asked)
+	}
+
+	if err := s.book.Save(); err != nil {
+		log.Printf("p2p: %v", err)
+	}
+}
+
+// dialableAddr turns an advertised listen address such as ":9000" into one
+// that can be dialed, using the host the connection came from.
+func dialableAddr(listenAddr, remoteAddr string) string {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return listenAddr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		if remoteHost, _, err := net.SplitHostPort(remoteAddr); err == nil {
+			host = remoteHost
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,63 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPEXDiscoversPeersThroughSeed(t *testing.T) {
+	seed := newTestServer(t, Config{SeedMode: true, PEXInterval: 100 * time.Millisecond})
+	seedAddr := seed.listener.Addr().String()
+
+	a := newTestServer(t, Config{Seeds: []string{seedAddr}, PEXInterval: 100 * time.Millisecond})
+	b := newTestServer(t, Config{Seeds: []string{seedAddr}, PEXInterval: 100 * time.Millisecond})
+
+	connected := func(s *Server, id string) bool {
+		for _, peer := range s.Peers() {
+			if peer.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if connected(a, b.ID()) && connected(b, a.ID()) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !connected(a, b.ID()) || !connected(b, a.ID()) {
+		t.Fatalf("a and b did not find each other via the seed: a=%v b=%v", a.Peers(), b.Peers())
+	}
+
+	// The seed hangs up once it has served its addresses.
+	deadline = time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && len(seed.Peers()) > 0 {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if peers := seed.Peers(); len(peers) != 0 {
+		t.Fatalf("seed kept %d peers connected", len(peers))
+	}
+}
+
+func TestPEXIgnoresUnsolicitedAddresses(t *testing.T) {
+	a := newTestServer(t, Config{})
+	b := newTestServer(t, Config{Seeds: []string{a.listener.Addr().String()}})
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && len(a.Peers()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a never asked its inbound peer b for addresses.
+	payload := MustMarshalPayload(pexAddrs{Addrs: []pexAddr{{Addr: "10.9.9.9:9000"}}})
+	if err := b.Send(a.ID(), NewEnvelope(MessageTypePexAddrs, payload, "")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for _, known := range a.KnownAddresses() {
+		if known.Addr == "10.9.9.9:9000" {
+			t.Fatal("unsolicited address was added to the book")
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package p2p
+
+import (
+	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/metrics"
+)
+
+// RateLimit is a token bucket: Rate events per second on average, with
+// bursts of up to Burst.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// DefaultMessageRateLimits apply when Config.MessageRateLimits is nil.
+var DefaultMessageRateLimits = map[MessageType]RateLimit{
+	MessageTypeTx:      {Rate: 200, Burst: 400},
+	MessageTypeRequest: {Rate: 50, Burst: 100},
+	// Address exchange happens every PEX interval, so a few per peer are
+	// plenty.
+	MessageTypePexRequest: {Rate: 0.2, Burst: 4},
+	MessageTypePexAddrs:   {Rate: 0.2, Burst: 4},
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: limit.Rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// allow takes one token if available.
+func (b *tokenBucket) allow() bool {
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// reserve takes n tokens, going into debt if needed, and returns how long
+// the caller must wait for the debt to be repaid.
+func (b *tokenBucket) reserve(n int) time.Duration {
+	b.refill(time.Now())
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// peerLimits holds one peer's buckets. The message buckets and recv are only
+// touched by the peer's read loop and send by its write loop, so they need no
+// locking.
+type peerLimits struct {
+	messages map[MessageType]*tokenBucket
+	recv     *tokenBucket
+	send     *tokenBucket
+}
+
+func (s *Server) newPeerLimits() peerLimits {
+	policies := s.cfg.MessageRateLimits
+	if policies == nil {
+		policies = DefaultMessageRateLimits
+	}
+	limits := peerLimits{messages: make(map[MessageType]*tokenBucket, len(policies))}
+	for t, limit := range policies {
+		if limit.Rate > 0 {
+			limits.messages[t] = newTokenBucket(limit)
+		}
+	}
+	// A byte bucket holds one second's worth so short bursts pass untouched.
+	if s.cfg.RecvRate > 0 {
+		limits.recv = newTokenBucket(RateLimit{Rate: float64(s.cfg.RecvRate), Burst: s.cfg.RecvRate})
+	}
+	if s.cfg.SendRate > 0 {
+		limits.send = newTokenBucket(RateLimit{Rate: float64(s.cfg.SendRate), Burst: s.cfg.SendRate})
+	}
+	return limits
+}
+
+// allowMessage reports whether p may send another message of type t;
+// messages over the limit are dropped unhandled.
+func (s *Server) allowMessage(p *Peer, t MessageType) bool {
+	bucket := p.limits.messages[t]
+	if bucket == nil || bucket.allow() {
+		return true
+	}
+	metrics.IncMessageDropped(t.String())
+	return false
+}
+
+// throttle blocks until bucket permits n more bytes, or p disconnects.
+func (s *Server) throttle(p *Peer, bucket *tokenBucket, n int, direction string) {
+	if bucket == nil {
+		return
+	}
+	wait := bucket.reserve(n)
+	if wait <= 0 {
+		return
+	}
+	metrics.IncThrottled(direction)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-p.quit:
+	}
+}
@@ -0,0 +1,48 @@
+package p2p
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(RateLimit{Rate: 10, Burst: 2})
+	if !b.allow() || !b.allow() || b.allow() {
+		t.Fatal("expected exactly the burst to pass")
+	}
+
+	bytes := newTokenBucket(RateLimit{Rate: 1000, Burst: 1000})
+	if wait := bytes.reserve(1000); wait != 0 {
+		t.Fatalf("burst should not wait, got %v", wait)
+	}
+	if wait := bytes.reserve(500); wait < 400*time.Millisecond || wait > 500*time.Millisecond {
+		t.Fatalf("expected about 500ms to repay the debt, got %v", wait)
+	}
+}
+
+func TestServerDropsMessagesOverRateLimit(t *testing.T) {
+	a := newTestServer(t, Config{})
+	b := newTestServer(t, Config{
+		Seeds:             []string{a.listener.Addr().String()},
+		MessageRateLimits: map[MessageType]RateLimit{MessageTypeTx: {Rate: 1, Burst: 2}},
+	})
+	var handled atomic.Int32
+	b.RegisterHandler(MessageTypeTx, func(PeerInfo, []byte) { handled.Add(1) })
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && len(a.Peers()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		a.Broadcast(NewEnvelope(MessageTypeTx, []byte(fmt.Sprintf(`{"nonce":%d}`, i)), ""))
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := handled.Load(); n < 2 || n > 3 {
+		t.Fatalf("expected the burst of 2 to be handled, got %d", n)
+	}
+	if len(b.Peers()) != 1 {
+		t.Fatal("rate-limited peer should stay connected")
+	}
+}
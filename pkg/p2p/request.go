@@ -0,0 +1,252 @@
+package p2p
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/metrics"
+)
+
+const (
+	DefaultRequestTimeout      = 10 * time.Second
+	DefaultMaxInflightRequests = 16
+)
+
+var (
+	ErrUnknownPeer      = errors.New("p2p: unknown peer")
+	ErrSendQueueFull    = errors.New("p2p: peer send queue full")
+	ErrPeerGone         = errors.New("p2p: peer disconnected before responding")
+	ErrRemoteRequest    = errors.New("p2p: request failed on peer")
+	ErrNoRequestHandler = errors.New("p2p: no handler for request type")
+	ErrBadRPCFrame      = errors.New("p2p: malformed request frame")
+)
+
+// RequestHandlerFunc answers a request from peer. A returned error is sent
+// back to the requester, whose Request then fails with ErrRemoteRequest.
+type RequestHandlerFunc func(peer PeerInfo, req Envelope) (Envelope, error)
+
+// rpcFrame is the payload of MessageTypeRequest and MessageTypeResponse: the
+// inner envelope plus the ID correlating a response with its request.
+type rpcFrame struct {
+	ID  uint64
+	Env Envelope
+	Err string
+}
+
+// encodeRPC lays the frame out as uvarint ID, inner type byte, uvarint error
+// length, error text, then the inner payload as-is.
+func encodeRPC(f rpcFrame) []byte {
+	buf := make([]byte, 0, 2*binary.MaxVarintLen64+1+len(f.Err)+len(f.Env.Payload))
+	buf = binary.AppendUvarint(buf, f.ID)
+	buf = append(buf, byte(f.Env.Type))
+	buf = binary.AppendUvarint(buf, uint64(len(f.Err)))
+	buf = append(buf, f.Err...)
+	return append(buf, f.Env.Payload...)
+}
+
+func decodeRPC(data []byte) (rpcFrame, error) {
+	var f rpcFrame
+	id, n := binary.Uvarint(data)
+	if n <= 0 || len(data) == n {
+		return f, ErrBadRPCFrame
+	}
+	f.ID = id
+	f.Env.Type = MessageType(data[n])
+	data = data[n+1:]
+	errLen, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < errLen {
+		return f, ErrBadRPCFrame
+	}
+	f.Err = string(data[n : n+int(errLen)])
+	f.Env.Payload = data[n+int(errLen):]
+	return f, nil
+}
+
+type rpcResult struct {
+	env Envelope
+	err error
+}
+
+type pendingRequest struct {
+	peer string
+	done chan rpcResult
+}
+
+// requestTracker correlates responses with outstanding requests. A response
+// is only accepted from the peer the request went to.
+type requestTracker struct {
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64]*pendingRequest
+}
+
+func newRequestTracker() *requestTracker {
+	return &requestTracker{pending: make(map[uint64]*pendingRequest)}
+}
+
+func (rt *requestTracker) add(peer string) (uint64, chan rpcResult) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.next++
+	done := make(chan rpcResult, 1)
+	rt.pending[rt.next] = &pendingRequest{peer: peer, done: done}
+	return rt.next, done
+}
+
+func (rt *requestTracker) remove(id uint64) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	delete(rt.pending, id)
+}
+
+// resolve completes request id with the response from peer. Unknown IDs,
+// e.g. of requests that already timed out, are ignored.
+func (rt *requestTracker) resolve(peer string, f rpcFrame) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	req, ok := rt.pending[f.ID]
+	if !ok || req.peer != peer {
+		return
+	}
+	delete(rt.pending, f.ID)
+	res := rpcResult{env: f.Env}
+	if f.Err != "" {
+		res.err = fmt.Errorf("%w: %s", ErrRemoteRequest, f.Err)
+	}
+	req.done <- res
+}
+
+// failPeer fails every request outstanding to peer.
+func (rt *requestTracker) failPeer(peer string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	for id, req := range rt.pending {
+		if req.peer == peer {
+			delete(rt.pending, id)
+			req.done <- rpcResult{err: ErrPeerGone}
+		}
+	}
+}
+
+// do sends env to peer through send and waits for the response, for ctx
+// or timeout, whichever ends first.
+func (rt *requestTracker) do(ctx context.Context, timeout time.Duration, peer string, env Envelope, send func(Envelope) error) (Envelope, error) {
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	id, done := rt.add(peer)
+	frame := encodeRPC(rpcFrame{ID: id, Env: env})
+	if err := send(NewEnvelope(MessageTypeRequest, frame, "")); err != nil {
+		rt.remove(id)
+		return Envelope{}, err
+	}
+	select {
+	case res := <-done:
+		return res.env, res.err
+	case <-ctx.Done():
+		rt.remove(id)
+		return Envelope{}, ctx.Err()
+	}
+}
+
+// serveRPC runs the handler for a request frame and builds the response.
+func serveRPC(handler func(MessageType) RequestHandlerFunc, peer PeerInfo, payload []byte) (Envelope, error) {
+	req, err := decodeRPC(payload)
+	if err != nil {
+		return Envelope{}, err
+	}
+	resp := rpcFrame{ID: req.ID}
+	if h := handler(req.Env.Type); h == nil {
+		resp.Err = fmt.Sprintf("%v: %s", ErrNoRequestHandler, req.Env.Type)
+	} else if env, err := h(peer, req.Env); err != nil {
+		resp.Err = err.Error()
+	} else {
+		resp.Env = env
+	}
+	return NewEnvelope(MessageTypeResponse, encodeRPC(resp), ""), nil
+}
+
+func (s *Server) requestHandler(t MessageType) RequestHandlerFunc {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.requestHandlers[t]
+}
+
+func (s *Server) RegisterRequestHandler(msgType MessageType, handler RequestHandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requestHandlers[msgType] = handler
+}
+
+// Send queues env for one peer.
+func (s *Server) Send(peerID string, env Envelope) error {
+	s.mu.RLock()
+	peer, ok := s.peers[peerID]
+	s.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownPeer, peerID)
+	}
+	select {
+	case peer.outgoing <- env.Clone():
+		return nil
+	case <-peer.quit:
+		return ErrPeerGone
+	default:
+		go s.removePeer(peer)
+		return ErrSendQueueFull
+	}
+}
+
+func (s *Server) maxInflightRequests() int {
+	if s.cfg.MaxInflightRequests > 0 {
+		return s.cfg.MaxInflightRequests
+	}
+	return DefaultMaxInflightRequests
+}
+
+// Request sends env to peerID and waits for the response produced by the
+// peer's RegisterRequestHandler handler for env.Type. It gives up after
+// RequestTimeout even if ctx has a later deadline.
+func (s *Server) Request(ctx context.Context, peerID string, env Envelope) (Envelope, error) {
+	return s.requests.do(ctx, s.cfg.RequestTimeout, peerID, env, func(req Envelope) error {
+		return s.Send(peerID, req)
+	})
+}
+
+// handleRequest serves a request off the read loop so a slow handler does
+// not stall the peer's other traffic. A peer with MaxInflightRequests
+// already being served has the request dropped.
+func (s *Server) handleRequest(p *Peer, payload []byte) {
+	select {
+	case p.inflight <- struct{}{}:
+	default:
+		metrics.IncMessageDropped(MessageTypeRequest.String())
+		s.ReportPeer(p.info.ID, PenaltyRequestFlood, "too many concurrent requests")
+		return
+	}
+	go func() {
+		defer func() { <-p.inflight }()
+		resp, err := serveRPC(s.requestHandler, p.info, payload)
+		if err != nil {
+			s.ReportPeer(p.info.ID, PenaltyBadPayload, "malformed request")
+			return
+		}
+		s.sendTo(p, resp)
+	}()
+}
+
+func (s *Server) handleResponse(p *Peer, payload []byte) {
+	f, err := decodeRPC(payload)
+	if err != nil {
+		s.ReportPeer(p.info.ID, PenaltyBadPayload, "malformed response")
+		return
+	}
+	s.requests.resolve(p.info.ID, f)
+}
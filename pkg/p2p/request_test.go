@@ -0,0 +1,98 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRPCFrameRoundTrip(t *testing.T) {
+	in := rpcFrame{ID: 300, Env: NewEnvelope(MessageTypeBlock, []byte("body"), ""), Err: "boom"}
+	out, err := decodeRPC(encodeRPC(in))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if out.ID != in.ID || out.Env.Type != in.Env.Type || string(out.Env.Payload) != "body" || out.Err != "boom" {
+		t.Fatalf("round trip mismatch: %+v", out)
+	}
+	if _, err := decodeRPC([]byte{0x80}); !errors.Is(err, ErrBadRPCFrame) {
+		t.Fatalf("expected ErrBadRPCFrame, got %v", err)
+	}
+}
+
+func TestServerRequestResponse(t *testing.T) {
+	a := newTestServer(t, Config{RequestTimeout: 200 * time.Millisecond})
+	b := newTestServer(t, Config{Seeds: []string{a.listener.Addr().String()}})
+	b.RegisterRequestHandler(MessageTypeBlock, func(peer PeerInfo, req Envelope) (Envelope, error) {
+		if peer.ID != a.ID() {
+			t.Errorf("request attributed to %s", peer.ID)
+		}
+		switch string(req.Payload) {
+		case "fail":
+			return Envelope{}, errors.New("no such block")
+		case "slow":
+			time.Sleep(time.Second)
+		}
+		return NewEnvelope(MessageTypeBlock, append([]byte("block "), req.Payload...), ""), nil
+	})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && len(a.Peers()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx := context.Background()
+	resp, err := a.Request(ctx, b.ID(), NewEnvelope(MessageTypeBlock, []byte("7"), ""))
+	if err != nil || string(resp.Payload) != "block 7" {
+		t.Fatalf("unexpected response %q, %v", resp.Payload, err)
+	}
+	if _, err := a.Request(ctx, b.ID(), NewEnvelope(MessageTypeBlock, []byte("fail"), "")); !errors.Is(err, ErrRemoteRequest) {
+		t.Fatalf("expected ErrRemoteRequest, got %v", err)
+	}
+	if _, err := a.Request(ctx, b.ID(), NewEnvelope(MessageTypeTx, nil, "")); !errors.Is(err, ErrRemoteRequest) {
+		t.Fatalf("expected ErrRemoteRequest without a handler, got %v", err)
+	}
+	if _, err := a.Request(ctx, b.ID(), NewEnvelope(MessageTypeBlock, []byte("slow"), "")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if _, err := a.Request(ctx, "nobody", NewEnvelope(MessageTypeBlock, nil, "")); !errors.Is(err, ErrUnknownPeer) {
+		t.Fatalf("expected ErrUnknownPeer, got %v", err)
+	}
+}
+
+func TestServerDropsRequestsOverInflightLimit(t *testing.T) {
+	a := newTestServer(t, Config{RequestTimeout: 200 * time.Millisecond})
+	b := newTestServer(t, Config{Seeds: []string{a.listener.Addr().String()}, MaxInflightRequests: 1})
+	release := make(chan struct{})
+	b.RegisterRequestHandler(MessageTypeBlock, func(peer PeerInfo, req Envelope) (Envelope, error) {
+		if string(req.Payload) == "block" {
+			<-release
+		}
+		return NewEnvelope(MessageTypeBlock, req.Payload, ""), nil
+	})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && len(a.Peers()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := a.Request(context.Background(), b.ID(), NewEnvelope(MessageTypeBlock, []byte("block"), ""))
+		blocked <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := a.Request(context.Background(), b.ID(), NewEnvelope(MessageTypeBlock, []byte("next"), "")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the request over the limit to be dropped, got %v", err)
+	}
+	if b.PeerScore(a.ID()) >= 0 {
+		t.Fatal("expected the flooding peer to be penalised")
+	}
+
+	close(release)
+	<-blocked
+	if resp, err := a.Request(context.Background(), b.ID(), NewEnvelope(MessageTypeBlock, []byte("next"), "")); err != nil || string(resp.Payload) != "next" {
+		t.Fatalf("expected requests to be served again once the slot frees, got %q, %v", resp.Payload, err)
+	}
+}
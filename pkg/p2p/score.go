@@ -0,0 +1,259 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Penalties handlers pass to ReportPeer. A peer is banned once its
+// accumulated, decaying penalty reaches Config.BanThreshold.
+const (
+	PenaltyInvalidTx    = 5
+	PenaltyRequestFlood = 5
+	PenaltyBadPayload   = 20
+	PenaltyInvalidBlock = 50
+)
+
+const (
+	DefaultBanThreshold  = 100
+	DefaultBanDuration   = 10 * time.Minute
+	DefaultScoreHalfLife = time.Minute
+)
+
+var (
+	ErrPeerBanned     = errors.New("p2p: peer is banned")
+	ErrUnknownBanKind = errors.New("p2p: unknown ban kind")
+)
+
+type BanKind string
+
+const (
+	BanByID BanKind = "id"
+	BanByIP BanKind = "ip"
+)
+
+type Ban struct {
+	Kind   BanKind   `json:"kind"`
+	Target string    `json:"target"`
+	Reason string    `json:"reason,omitempty"`
+	Until  time.Time `json:"until"`
+}
+
+// BanManager is implemented by transports that track peer bans, letting
+// callers such as the RPC server manage them without depending on *Server.
+type BanManager interface {
+	Bans() []Ban
+	BanPeer(kind BanKind, target string, d time.Duration, reason string) error
+	Unban(kind BanKind, target string) bool
+}
+
+type peerScore struct {
+	penalty float64
+	updated time.Time
+}
+
+// scoreboard keeps decaying penalties per node ID and the active bans. It
+// outlives connections so reconnecting does not reset a peer's record.
+type scoreboard struct {
+	mu       sync.Mutex
+	halfLife time.Duration
+	scores   map[string]*peerScore
+	bans     map[BanKind]map[string]Ban
+}
+
+func newScoreboard(halfLife time.Duration) *scoreboard {
+	return &scoreboard{
+		halfLife: halfLife,
+		scores:   make(map[string]*peerScore),
+		bans: map[BanKind]map[string]Ban{
+			BanByID: {},
+			BanByIP: {},
+		},
+	}
+}
+
+// decayedLocked returns the penalty of score as of now. Penalties halve every
+// halfLife.
+func (sb *scoreboard) decayedLocked(score *peerScore, now time.Time) float64 {
+	elapsed := now.Sub(score.updated)
+	if elapsed <= 0 || sb.halfLife <= 0 {
+		return score.penalty
+	}
+	return score.penalty * math.Exp2(-float64(elapsed)/float64(sb.halfLife))
+}
+
+func (sb *scoreboard) penalize(id string, penalty float64) float64 {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	now := time.Now()
+	score, ok := sb.scores[id]
+	if !ok {
+		score = &peerScore{updated: now}
+		sb.scores[id] = score
+	}
+	score.penalty = sb.decayedLocked(score, now) + penalty
+	score.updated = now
+	return score.penalty
+}
+
+func (sb *scoreboard) penalty(id string) float64 {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	score, ok := sb.scores[id]
+	if !ok {
+		return 0
+	}
+	return sb.decayedLocked(score, time.Now())
+}
+
+func (sb *scoreboard) ban(ban Ban) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.bans[ban.Kind][ban.Target] = ban
+	if ban.Kind == BanByID {
+		// Start over once the ban lifts.
+		delete(sb.scores, ban.Target)
+	}
+}
+
+func (sb *scoreboard) unban(kind BanKind, target string) bool {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	bans, ok := sb.bans[kind]
+	if !ok {
+		return false
+	}
+	_, found := bans[target]
+	delete(bans, target)
+	return found
+}
+
+func (sb *scoreboard) banned(kind BanKind, target string) bool {
+	if target == "" {
+		return false
+	}
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	ban, ok := sb.bans[kind][target]
+	if ok && time.Now().After(ban.Until) {
+		delete(sb.bans[kind], target)
+		return false
+	}
+	return ok
+}
+
+func (sb *scoreboard) list() []Ban {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	now := time.Now()
+	var out []Ban
+	for _, bans := range sb.bans {
+		for target, ban := range bans {
+			if now.After(ban.Until) {
+				delete(bans, target)
+				continue
+			}
+			out = append(out, ban)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Kind != out[j].Kind {
+			return out[i].Kind < out[j].Kind
+		}
+		return out[i].Target < out[j].Target
+	})
+	return out
+}
+
+func (s *Server) banThreshold() float64 {
+	if s.cfg.BanThreshold > 0 {
+		return s.cfg.BanThreshold
+	}
+	return DefaultBanThreshold
+}
+
+func (s *Server) banDuration() time.Duration {
+	if s.cfg.BanDuration > 0 {
+		return s.cfg.BanDuration
+	}
+	return DefaultBanDuration
+}
+
+// ReportPeer penalizes a peer for misbehaving. Once the peer's decayed
+// penalty reaches the ban threshold it is disconnected and both its node ID
+// and IP are banned for BanDuration.
+func (s *Server) ReportPeer(peerID string, penalty int, reason string) {
+	if penalty <= 0 || peerID == "" {
+		return
+	}
+	total := s.scores.penalize(peerID, float64(penalty))
+	if total < s.banThreshold() {
+		return
+	}
+	log.Printf("p2p: banning peer %s: %s", peerID, reason)
+	s.mu.RLock()
+	peer := s.peers[peerID]
+	s.mu.RUnlock()
+	if peer != nil {
+		if ip := hostOf(peer.info.Addr); ip != "" {
+			s.BanPeer(BanByIP, ip, s.banDuration(), reason)
+		}
+	}
+	s.BanPeer(BanByID, peerID, s.banDuration(), reason)
+}
+
+// PeerScore is the peer's current score: zero for a well-behaved peer,
+// negative by the decayed sum of its penalties otherwise.
+func (s *Server) PeerScore(peerID string) float64 {
+	return -s.scores.penalty(peerID)
+}
+
+// BanPeer bans a node ID or IP for d and disconnects matching peers.
+func (s *Server) BanPeer(kind BanKind, target string, d time.Duration, reason string) error {
+	if kind != BanByID && kind != BanByIP {
+		return fmt.Errorf("%w: %q", ErrUnknownBanKind, kind)
+	}
+	if d <= 0 {
+		d = s.banDuration()
+	}
+	s.scores.ban(Ban{Kind: kind, Target: target, Reason: reason, Until: time.Now().Add(d)})
+
+	s.mu.RLock()
+	var drop []*Peer
+	for id, peer := range s.peers {
+		if (kind == BanByID && id == target) || (kind == BanByIP && hostOf(peer.info.Addr) == target) {
+			drop = append(drop, peer)
+		}
+	}
+	s.mu.RUnlock()
+	for _, peer := range drop {
+		s.removePeer(peer)
+	}
+	return nil
+}
+
+func (s *Server) Unban(kind BanKind, target string) bool {
+	return s.scores.unban(kind, target)
+}
+
+func (s *Server) Bans() []Ban {
+	return s.scores.list()
+}
+
+func (s *Server) isBanned(id, addr string) bool {
+	return s.scores.banned(BanByID, id) || s.scores.banned(BanByIP, hostOf(addr))
+}
+
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	return host
+}
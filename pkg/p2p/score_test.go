@@ -0,0 +1,67 @@
+package p2p
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestScoreDecays(t *testing.T) {
+	sb := newScoreboard(50 * time.Millisecond)
+	sb.penalize("peer", 80)
+	time.Sleep(100 * time.Millisecond)
+	if got := sb.penalty("peer"); got > 25 || got < 10 {
+		t.Fatalf("expected penalty to roughly quarter after two half-lives, got %.1f", got)
+	}
+}
+
+func TestReportPeerBansAndRejectsReconnect(t *testing.T) {
+	a := newTestServer(t, Config{BanThreshold: 50})
+	b := newTestServer(t, Config{Seeds: []string{a.listener.Addr().String()}})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && len(a.Peers()) == 0 {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(a.Peers()) != 1 {
+		t.Fatal("peers failed to connect")
+	}
+
+	a.ReportPeer(b.ID(), PenaltyBadPayload, "bad payload")
+	if a.PeerScore(b.ID()) >= 0 || len(a.Peers()) != 1 {
+		t.Fatal("a single penalty should lower the score without disconnecting")
+	}
+	a.ReportPeer(b.ID(), PenaltyInvalidBlock, "invalid block")
+	if len(a.Peers()) != 0 {
+		t.Fatal("peer below the ban threshold was not disconnected")
+	}
+
+	bans := a.Bans()
+	if len(bans) != 2 || bans[0].Kind != BanByID || bans[1].Kind != BanByIP || bans[1].Target != "127.0.0.1" {
+		t.Fatalf("expected id and ip bans, got %+v", bans)
+	}
+
+	if !a.isBanned("", "127.0.0.1:4000") {
+		t.Fatal("connections from the banned ip should be refused")
+	}
+
+	// A pipe has no IP, so this reconnect is rejected by node ID alone.
+	connA, connB := net.Pipe()
+	defer connB.Close()
+	go b.upgradeConnection(connB)
+	if _, err := a.handleConnection(connA, true); !errors.Is(err, ErrPeerBanned) {
+		t.Fatalf("expected ErrPeerBanned, got %v", err)
+	}
+
+	if !a.Unban(BanByID, b.ID()) || !a.Unban(BanByIP, "127.0.0.1") || len(a.Bans()) != 0 {
+		t.Fatal("unban did not lift the ban")
+	}
+}
+
+func TestBanPeerRejectsUnknownKind(t *testing.T) {
+	s := NewServer(Config{})
+	if err := s.BanPeer("subnet", "10.0.0.0/8", time.Minute, ""); !errors.Is(err, ErrUnknownBanKind) {
+		t.Fatalf("expected ErrUnknownBanKind, got %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	secretConnKeyInfo = "gchain-secret-connection-v1"
+	// maxSecretFrameData bounds the plaintext carried by one sealed frame.
+	maxSecretFrameData = 16 * 1024
+)
+
+var (
+	ErrSecretConnAuth    = errors.New("p2p: secret connection authentication failed")
+	ErrSecretFrameTooBig = errors.New("p2p: secret connection frame too large")
+)
+
+// SecretConnection is an authenticated, encrypted net.Conn. Both sides run an
+// ephemeral X25519 exchange, derive directional AES-256-GCM keys from the
+// shared secret, then prove ownership of their long-term ed25519 node keys by
+// signing a challenge bound to the exchange. Every write is sealed as a
+// length-prefixed frame; tampering, reordering or replaying frames fails
+// decryption because nonces are per-direction counters.
+type SecretConnection struct {
+	conn      net.Conn
+	remotePub ed25519.PublicKey
+
+	sendMu    sync.Mutex
+	sendAEAD  cipher.AEAD
+	sendNonce uint64
+
+	recvMu    sync.Mutex
+	recvAEAD  cipher.AEAD
+	recvNonce uint64
+	recvBuf   []byte
+}
+
+type secretAuth struct {
+	PublicKey ed25519.PublicKey
+	Signature []byte
+}
+
+// MakeSecretConnection upgrades conn. It blocks until the key exchange and
+// authentication complete, so callers should set a deadline on conn.
+func MakeSecretConnection(conn net.Conn, key ed25519.PrivateKey) (*SecretConnection, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate ephemeral key: %w", err)
+	}
+	localEph := ephemeral.PublicKey().Bytes()
+
+	remoteEph := make([]byte, len(localEph))
+	if err := exchangeRaw(conn, localEph, remoteEph); err != nil {
+		return nil, fmt.Errorf("exchange ephemeral keys: %w", err)
+	}
+	remoteKey, err := ecdh.X25519().NewPublicKey(remoteEph)
+	if err != nil {
+		return nil, fmt.Errorf("parse remote ephemeral key: %w", err)
+	}
+	shared, err := ephemeral.ECDH(remoteKey)
+	if err != nil {
+		return nil, fmt.Errorf("derive shared secret: %w", err)
+	}
+
+	lo, hi := localEph, remoteEph
+	localIsLo := bytes.Compare(localEph, remoteEph) < 0
+	if !localIsLo {
+		lo, hi = hi, lo
+	}
+	okm := hkdfSHA256(shared, append(append([]byte(secretConnKeyInfo), lo...), hi...), 96)
+	loToHi, hiToLo, challenge := okm[:32], okm[32:64], okm[64:]
+
+	sendKey, recvKey := loToHi, hiToLo
+	if !localIsLo {
+		sendKey, recvKey = hiToLo, loToHi
+	}
+	sc := &SecretConnection{conn: conn}
+	if sc.sendAEAD, err = newGCM(sendKey); err != nil {
+		return nil, err
+	}
+	if sc.recvAEAD, err = newGCM(recvKey); err != nil {
+		return nil, err
+	}
+
+	local := secretAuth{
+		PublicKey: key.Public().(ed25519.PublicKey),
+		Signature: ed25519.Sign(key, challenge),
+	}
+	remote, err := sc.exchangeAuth(local)
+	if err != nil {
+		return nil, fmt.Errorf("exchange auth: %w", err)
+	}
+	if len(remote.PublicKey) != ed25519.PublicKeySize || !ed25519.Verify(remote.PublicKey, challenge, remote.Signature) {
+		return nil, ErrSecretConnAuth
+	}
+	sc.remotePub = remote.PublicKey
+	return sc, nil
+}
+
+// RemotePubKey is the authenticated long-term key of the remote node.
+func (sc *SecretConnection) RemotePubKey() ed25519.PublicKey {
+	return sc.remotePub
+}
+
+func (sc *SecretConnection) exchangeAuth(local secretAuth) (secretAuth, error) {
+	out := make([]byte, 0, ed25519.PublicKeySize+ed25519.SignatureSize)
+	out = append(out, local.PublicKey...)
+	out = append(out, local.Signature...)
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := sc.Write(out)
+		errc <- err
+	}()
+	in := make([]byte, ed25519.PublicKeySize+ed25519.SignatureSize)
+	if _, err := io.ReadFull(sc, in); err != nil {
+		return secretAuth{}, err
+	}
+	if err := <-errc; err != nil {
+		return secretAuth{}, err
+	}
+	return secretAuth{
+		PublicKey: ed25519.PublicKey(in[:ed25519.PublicKeySize]),
+		Signature: in[ed25519.PublicKeySize:],
+	}, nil
+}
+
+func (sc *SecretConnection) Write(data []byte) (int, error) {
+	sc.sendMu.Lock()
+	defer sc.sendMu.Unlock()
+
+	written := 0
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > maxSecretFrameData {
+			chunk = chunk[:maxSecretFrameData]
+		}
+		sealed := sc.sendAEAD.Seal(nil, counterNonce(sc.sendNonce, sc.sendAEAD.NonceSize()), chunk, nil)
+		sc.sendNonce++
+
+		frame := make([]byte, 4+len(sealed))
+		binary.BigEndian.PutUint32(frame, uint32(len(sealed)))
+		copy(frame[4:], sealed)
+		if _, err := sc.conn.Write(frame); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		data = data[len(chunk):]
+	}
+	return written, nil
+}
+
+func (sc *SecretConnection) Read(out []byte) (int, error) {
+	sc.recvMu.Lock()
+	defer sc.recvMu.Unlock()
+
+	if len(sc.recvBuf) == 0 {
+		var header [4]byte
+		if _, err := io.ReadFull(sc.conn, header[:]); err != nil {
+			return 0, err
+		}
+		size := binary.BigEndian.Uint32(header[:])
+		if size > uint32(maxSecretFrameData+sc.recvAEAD.Overhead()) {
+			return 0, ErrSecretFrameTooBig
+		}
+		sealed := make([]byte, size)
+		if _, err := io.ReadFull(sc.conn, sealed); err != nil {
+			return 0, err
+		}
+		plain, err := sc.recvAEAD.Open(sealed[:0], counterNonce(sc.recvNonce, sc.recvAEAD.NonceSize()), sealed, nil)
+		if err != nil {
+			return 0, fmt.Errorf("p2p: decrypt frame: %w", err)
+		}
+		sc.recvNonce++
+		sc.recvBuf = plain
+	}
+
+	n := copy(out, sc.recvBuf)
+	sc.recvBuf = sc.recvBuf[n:]
+	return n, nil
+}
+
+func (sc *SecretConnection) Close() error                       { return sc.conn.Close() }
+func (sc *SecretConnection) LocalAddr() net.Addr                { return sc.conn.LocalAddr() }
+func (sc *SecretConnection) RemoteAddr() net.Addr               { return sc.conn.RemoteAddr() }
+func (sc *SecretConnection) SetDeadline(t time.Time) error      { return sc.conn.SetDeadline(t) }
+func (sc *SecretConnection) SetReadDeadline(t time.Time) error  { return sc.conn.SetReadDeadline(t) }
+func (sc *SecretConnection) SetWriteDeadline(t time.Time) error { return sc.conn.SetWriteDeadline(t) }
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("new cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func counterNonce(counter uint64, size int) []byte {
+	nonce := make([]byte, size)
+	binary.LittleEndian.PutUint64(nonce, counter)
+	return nonce
+}
+
+// exchangeRaw writes out while filling in from conn.
+func exchangeRaw(conn net.Conn, out []byte, in []byte) error {
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(out)
+		errc <- err
+	}()
+	if _, err := io.ReadFull(conn, in); err != nil {
+		return err
+	}
+	return <-errc
+}
+
+// hkdfSHA256 is RFC 5869 HKDF with an empty salt.
+func hkdfSHA256(secret, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, make([]byte, sha256.Size))
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	var out, prev []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		expand := hmac.New(sha256.New, prk)
+		expand.Write(prev)
+		expand.Write(info)
+		expand.Write([]byte{counter})
+		prev = expand.Sum(nil)
+		out = append(out, prev...)
+	}
+	return out[:length]
+}
@@ -0,0 +1,102 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func secretPair(t *testing.T) (*SecretConnection, *SecretConnection, ed25519.PublicKey, ed25519.PublicKey) {
+	t.Helper()
+	pubA, keyA, _ := ed25519.GenerateKey(rand.Reader)
+	pubB, keyB, _ := ed25519.GenerateKey(rand.Reader)
+	connA, connB := net.Pipe()
+	t.Cleanup(func() {
+		connA.Close()
+		connB.Close()
+	})
+
+	type result struct {
+		sc  *SecretConnection
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		sc, err := MakeSecretConnection(connB, keyB)
+		done <- result{sc, err}
+	}()
+	scA, err := MakeSecretConnection(connA, keyA)
+	if err != nil {
+		t.Fatalf("secret connection a: %v", err)
+	}
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("secret connection b: %v", res.err)
+	}
+	return scA, res.sc, pubA, pubB
+}
+
+func TestSecretConnectionRoundTrip(t *testing.T) {
+	scA, scB, pubA, pubB := secretPair(t)
+	if !bytes.Equal(scA.RemotePubKey(), pubB) || !bytes.Equal(scB.RemotePubKey(), pubA) {
+		t.Fatal("remote keys not authenticated correctly")
+	}
+
+	// Larger than one frame so chunking is exercised.
+	msg := make([]byte, 3*maxSecretFrameData+17)
+	rand.Read(msg)
+	go scA.Write(msg)
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(scB, got); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatal("payload corrupted in transit")
+	}
+}
+
+func TestSecretConnectionRejectsForgedFrame(t *testing.T) {
+	scA, scB, _, _ := secretPair(t)
+
+	forged := make([]byte, 4+32)
+	binary.BigEndian.PutUint32(forged, 32)
+	rand.Read(forged[4:])
+	go scA.conn.Write(forged)
+
+	if _, err := scB.Read(make([]byte, 64)); err == nil {
+		t.Fatal("expected forged frame to fail decryption")
+	}
+}
+
+func TestUpgradeConnectionAuthenticatesPeers(t *testing.T) {
+	a := newTestServer(t, Config{})
+	b := newTestServer(t, Config{})
+	connA, connB := net.Pipe()
+	defer connA.Close()
+	defer connB.Close()
+
+	type result struct {
+		info NodeInfo
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		_, info, err := b.upgradeConnection(connB)
+		done <- result{info, err}
+	}()
+	_, infoA, err := a.upgradeConnection(connA)
+	if err != nil {
+		t.Fatalf("upgrade a: %v", err)
+	}
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("upgrade b: %v", res.err)
+	}
+	if infoA.ID != b.ID() || res.info.ID != a.ID() {
+		t.Fatal("upgraded connection reported the wrong peer ids")
+	}
+}
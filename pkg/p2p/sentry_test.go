@@ -0,0 +1,64 @@
+package p2p
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func TestSentryTopology(t *testing.T) {
+	_, valKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valID := NodeIDFromPublicKey(valKey.Public().(ed25519.PublicKey))
+
+	sentry := newTestServer(t, Config{PrivatePeerIDs: []string{valID}, UnconditionalPeerIDs: []string{valID}})
+	sentryAddr := sentry.listener.Addr().String()
+	stranger := newTestServer(t, Config{PEXInterval: time.Hour})
+	val := newTestServer(t, Config{
+		NodeKey:         valKey,
+		SentryOnly:      true,
+		PersistentPeers: []string{sentryAddr},
+		Seeds:           []string{stranger.listener.Addr().String()},
+	})
+
+	waitFor := func(cond func() bool) bool {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) && !cond() {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return cond()
+	}
+	if !waitFor(func() bool { return len(val.Peers()) == 1 && len(sentry.Peers()) == 1 }) {
+		t.Fatal("validator did not connect to its sentry")
+	}
+
+	// The validator refuses anyone but its sentries.
+	stranger.dial(val.listener.Addr().String())
+	time.Sleep(100 * time.Millisecond)
+	if peers := val.Peers(); len(peers) != 1 || peers[0].ID != sentry.ID() {
+		t.Fatalf("validator should only be connected to its sentry, got %+v", peers)
+	}
+
+	// The sentry knows the validator but never hands its address out.
+	sentry.AddPeerAddress("10.0.0.1:26656")
+	public := newTestServer(t, Config{Seeds: []string{sentryAddr}, PEXInterval: time.Hour})
+	learned := func() bool {
+		for _, known := range public.KnownAddresses() {
+			if known.Addr == "10.0.0.1:26656" {
+				return true
+			}
+		}
+		return false
+	}
+	if !waitFor(learned) {
+		t.Fatal("public node did not receive the sentry's addresses")
+	}
+	for _, known := range public.KnownAddresses() {
+		if known.ID == valID {
+			t.Fatalf("private validator address leaked: %+v", known)
+		}
+	}
+}
@@ -1,7 +1,12 @@
 package p2p
 
 import (
-	"encoding/json"
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
 	"net"
 	"sync"
 	"time"
@@ -9,38 +14,181 @@ import (
 	"github.com/0xphantomotr/gchain/pkg/metrics"
 )
 
+var (
+	ErrTooManyPeers = errors.New("p2p: too many peers")
+	ErrServerClosed = errors.New("p2p: server closed")
+)
+
 type Peer struct {
 	info     PeerInfo
+	node     NodeInfo
 	conn     net.Conn
 	outgoing chan Envelope
 	quit     chan struct{}
+
+	// known holds hashes of gossip the peer sent us or we sent it.
+	known  *hashCache
+	limits peerLimits
+	// inflight holds a token per request being served for the peer.
+	inflight chan struct{}
+	// codec compresses frames; nil when the peers share no algorithm.
+	codec codec
+
+	lastPexRequest time.Time
+	pingNonce      uint64
+	pingSent       time.Time
+
+	// pexRequested is set while we await the peer's addresses; unsolicited
+	// ones are ignored.
+	pexRequested bool
 }
 
 type Server struct {
 	cfg      Config
+	id       string
 	peers    map[string]*Peer
 	handlers map[MessageType]HandlerFunc
 	mu       sync.RWMutex
-	listener net.Listener
-	dialer   *Dialer
+
+	requestHandlers map[MessageType]RequestHandlerFunc
+	requests        *requestTracker
+	codecs          map[Compression]codec
+	listener        net.Listener
+	dialer          *Dialer
+
+	book      *AddrBook
+	discovery *Discovery
+	scores    *scoreboard
+	seen      map[MessageType]*hashCache
+	relay     map[MessageType]bool
+
+	persistent    map[string]*persistentPeer
+	reserved      map[string]bool
+	unconditional map[string]bool
+	private       map[string]bool
+	dialSlots     chan struct{}
+	dialing       map[string]bool
+	quit          chan struct{}
+	closeOnce     sync.Once
 }
 
 func NewServer(cfg Config) *Server {
-	return &Server{
+	if cfg.NodeKey == nil {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		cfg.NodeKey = key
+	}
+	halfLife := cfg.ScoreHalfLife
+	if halfLife <= 0 {
+		halfLife = DefaultScoreHalfLife
+	}
+	maxDials := cfg.MaxConcurrentDials
+	if maxDials <= 0 {
+		maxDials = DefaultMaxConcurrentDials
+	}
+	reserved := make(map[string]bool, len(cfg.ReservedPeers))
+	for _, id := range cfg.ReservedPeers {
+		reserved[id] = true
+	}
+	unconditional := make(map[string]bool, len(cfg.UnconditionalPeerIDs))
+	for _, id := range cfg.UnconditionalPeerIDs {
+		unconditional[id] = true
+	}
+	private := make(map[string]bool, len(cfg.PrivatePeerIDs))
+	for _, id := range cfg.PrivatePeerIDs {
+		private[id] = true
+	}
+	persistent := make(map[string]*persistentPeer)
+	for _, addr := range cfg.PersistentPeers {
+		persistent[addr] = &persistentPeer{addr: addr, down: make(chan struct{}, 1)}
+	}
+	s := &Server{
 		cfg:      cfg,
+		id:       NodeIDFromPublicKey(cfg.NodeKey.Public().(ed25519.PublicKey)),
 		peers:    make(map[string]*Peer),
 		handlers: make(map[MessageType]HandlerFunc),
+
+		requestHandlers: make(map[MessageType]RequestHandlerFunc),
+		requests:        newRequestTracker(),
+
+		book:   NewAddrBook(cfg.AddrBookPath),
+		scores: newScoreboard(halfLife),
+		seen:   newSeenCaches(cfg.SeenCacheSize),
+		relay:  newRelaySet(cfg.RelayTypes),
+
+		persistent:    persistent,
+		reserved:      reserved,
+		unconditional: unconditional,
+		private:       private,
+		dialSlots:     make(chan struct{}, maxDials),
+		dialing:       make(map[string]bool),
+		quit:          make(chan struct{}),
 	}
+	s.book.SetMaxSize(cfg.MaxAddrBookSize)
+	return s
+}
+
+// ID returns this node's ID as derived from its node key.
+func (s *Server) ID() string {
+	return s.id
+}
+
+// Discovery returns the DHT, or nil when discovery is disabled.
+func (s *Server) Discovery() *Discovery {
+	return s.discovery
+}
+
+// Peers returns the currently connected peers.
+func (s *Server) Peers() []PeerInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]PeerInfo, 0, len(s.peers))
+	for _, peer := range s.peers {
+		out = append(out, peer.info)
+	}
+	return out
 }
 
 func (s *Server) Start() error {
+	codecs, err := newCodecs(s.cfg)
+	if err != nil {
+		return err
+	}
+	s.codecs = codecs
+	if err := s.book.Load(); err != nil {
+		return err
+	}
+	for _, seed := range s.cfg.Seeds {
+		s.book.Add(seed, "")
+	}
+
 	ln, err := net.Listen("tcp", s.cfg.ListenAddr)
 	if err != nil {
 		return err
 	}
 	s.listener = ln
+	if s.cfg.DiscoveryAddr != "" && !s.cfg.SentryOnly {
+		s.discovery = NewDiscovery(DiscoveryConfig{
+			ListenAddr:      s.cfg.DiscoveryAddr,
+			Bootnodes:       s.cfg.Bootnodes,
+			TCPAddr:         s.localNodeInfo().ListenAddr,
+			RefreshInterval: s.cfg.DiscoveryRefresh,
+		}, s.cfg.NodeKey, func(node DHTNode) {
+			s.book.Add(node.TCPAddr, node.ID)
+		})
+		if err := s.discovery.Start(); err != nil {
+			ln.Close()
+			return fmt.Errorf("start discovery: %w", err)
+		}
+	}
 	go s.acceptLoop()
-	go s.connectSeeds()
+	s.connectPersistent()
+	if !s.cfg.SentryOnly {
+		s.connectSeeds()
+		go s.ensurePeersLoop()
+	}
 	return nil
 }
 
@@ -58,24 +206,73 @@ func (s *Server) acceptLoop() {
 }
 
 func (s *Server) readLoop(p *Peer) {
-	dec := json.NewDecoder(p.conn)
+	r := bufio.NewReaderSize(p.conn, bufferSize(s.cfg.ReadBufferSize))
 	for {
-		var env Envelope
-		if err := dec.Decode(&env); err != nil {
-			s.removePeer(p.info.ID)
+		s.extendReadDeadline(p)
+		env, err := readFrame(r, s.maxMessageSize)
+		if err == nil {
+			s.throttle(p, p.limits.recv, frameSize(env), "recv")
+			env, err = s.decompressFrame(p, env)
+		}
+		if err != nil {
+			var ne net.Error
+			switch {
+			case errors.Is(err, ErrMessageTooLarge), errors.Is(err, ErrUnexpectedCompression):
+				log.Printf("p2p: dropping peer %s: %v", p.info.ID, err)
+			case errors.As(err, &ne) && ne.Timeout():
+				log.Printf("p2p: dropping silent peer %s", p.info.ID)
+			}
+			s.removePeer(p)
 			return
 		}
-		s.dispatch(p.info, env)
+		if !s.allowMessage(p, env.Type) {
+			continue
+		}
+		switch env.Type {
+		case MessageTypePing:
+			s.handlePing(p, env.Payload)
+		case MessageTypePong:
+			s.handlePong(p, env.Payload)
+		case MessageTypeRequest:
+			s.handleRequest(p, env.Payload)
+		case MessageTypeResponse:
+			s.handleResponse(p, env.Payload)
+		case MessageTypePexRequest:
+			s.handlePexRequest(p)
+		case MessageTypePexAddrs:
+			s.handlePexAddrs(p, env.Payload)
+		default:
+			if h, ok := gossipHash(env); ok {
+				s.receiveGossip(p, env, h)
+			} else {
+				s.dispatch(p.info, env)
+			}
+		}
 	}
 }
 
 func (s *Server) writeLoop(p *Peer) {
-	enc := json.NewEncoder(p.conn)
+	w := bufio.NewWriterSize(p.conn, bufferSize(s.cfg.WriteBufferSize))
 	for {
 		select {
 		case env := <-p.outgoing:
-			if err := enc.Encode(env); err != nil {
-				s.removePeer(p.info.ID)
+			env = s.compressFrame(p, env)
+			err := writeFrame(w, env)
+			n := frameSize(env)
+			// Batch whatever is already queued into the same flush.
+			for err == nil && len(p.outgoing) > 0 {
+				env = s.compressFrame(p, <-p.outgoing)
+				err = writeFrame(w, env)
+				n += frameSize(env)
+			}
+			if err == nil {
+				err = w.Flush()
+			}
+			if err == nil {
+				s.throttle(p, p.limits.send, n, "send")
+			}
+			if err != nil {
+				s.removePeer(p)
 				return
 			}
 		case <-p.quit:
@@ -94,45 +291,51 @@ func (s *Server) dispatch(peer PeerInfo, env Envelope) {
 }
 
 func (s *Server) Broadcast(env Envelope) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	metrics.SetPeerCount(len(s.peers))
-	for id, peer := range s.peers {
-		if env.PeerID != "" && env.PeerID == id {
-			continue
-		}
-		select {
-		case peer.outgoing <- env.Clone():
-		default:
-			go s.removePeer(id) // drop slow peers
-		}
-	}
+	s.broadcast(env.PeerID, env)
 }
 
 func (s *Server) BroadcastExcept(peerID string, env Envelope) {
+	s.broadcast(peerID, env)
+}
+
+// broadcast queues env for every peer but except. Gossip messages are
+// marked seen so echoes are dropped, and skip peers that already have them.
+func (s *Server) broadcast(except string, env Envelope) {
+	h, gossip := gossipHash(env)
+	if gossip {
+		s.seen[env.Type].Add(h)
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	metrics.SetPeerCount(len(s.peers))
 	for id, peer := range s.peers {
-		if id == peerID {
+		if except != "" && id == except {
+			continue
+		}
+		if gossip && !peer.known.Add(h) {
 			continue
 		}
 		select {
 		case peer.outgoing <- env.Clone():
 		default:
-			go s.removePeer(id)
+			go s.removePeer(peer) // drop slow peers
 		}
 	}
 }
 
-func (s *Server) removePeer(id string) {
+// removePeer drops p if it is still the registered connection for its ID; a
+// replaced duplicate must not take its successor down with it.
+func (s *Server) removePeer(p *Peer) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if peer, ok := s.peers[id]; ok {
+	if peer, ok := s.peers[p.info.ID]; ok && peer == p {
 		close(peer.quit)
 		peer.conn.Close()
-		delete(s.peers, id)
+		delete(s.peers, p.info.ID)
 		metrics.SetPeerCount(len(s.peers))
+		metrics.RemovePeerRTT(p.info.ID)
+		s.persistentDownLocked(p.info.ID)
+		s.requests.failPeer(p.info.ID)
 	}
 }
 
@@ -143,60 +346,122 @@ func (s *Server) RegisterHandler(msgType MessageType, handler HandlerFunc) {
 }
 
 func (s *Server) Close() error {
+	s.closeOnce.Do(func() { close(s.quit) })
+	if s.discovery != nil {
+		s.discovery.Close()
+	}
 	if s.listener != nil {
 		s.listener.Close()
 	}
 	s.mu.Lock()
-	for _, peer := range s.peers {
+	for id, peer := range s.peers {
 		close(peer.quit)
 		peer.conn.Close()
+		metrics.RemovePeerRTT(id)
+		s.requests.failPeer(id)
 	}
 	s.peers = map[string]*Peer{}
 	s.mu.Unlock()
-	return nil
+	for _, c := range s.codecs {
+		c.close()
+	}
+	return s.book.Save()
 }
 
-func (s *Server) connectSeeds() {
-	if len(s.cfg.Seeds) == 0 {
-		return
+func (s *Server) handleConnection(conn net.Conn, inbound bool) (NodeInfo, error) {
+	if s.isBanned("", conn.RemoteAddr().String()) {
+		conn.Close()
+		return NodeInfo{}, ErrPeerBanned
 	}
-	for _, addr := range s.cfg.Seeds {
-		go func(target string) {
-			for {
-				conn, err := net.DialTimeout("tcp", target, s.cfg.HandshakeTimeout)
-				if err != nil {
-					time.Sleep(time.Second)
-					continue
-				}
-				s.handleConnection(conn, false)
-				return
-			}
-		}(addr)
+	if inbound {
+		if err := s.acceptAllowed(conn.RemoteAddr()); err != nil {
+			conn.Close()
+			return NodeInfo{}, err
+		}
+	}
+	secure, node, err := s.upgradeConnection(conn)
+	if err != nil {
+		log.Printf("p2p: handshake with %s failed: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return NodeInfo{}, err
+	}
+	if s.isBanned(node.ID, "") {
+		secure.Close()
+		return node, ErrPeerBanned
 	}
-}
 
-func (s *Server) handleConnection(conn net.Conn, inbound bool) {
-	peerID := conn.RemoteAddr().String()
 	peer := &Peer{
 		info: PeerInfo{
-			ID:   peerID,
-			Addr: conn.RemoteAddr().String(),
+			ID:         node.ID,
+			Addr:       conn.RemoteAddr().String(),
+			ListenAddr: node.ListenAddr,
+			Inbound:    inbound,
 		},
-		conn:     conn,
+		node:     node,
+		conn:     secure,
+		known:    newHashCache(knownCacheSize),
+		codec:    s.negotiateCompression(node),
+		limits:   s.newPeerLimits(),
+		inflight: make(chan struct{}, s.maxInflightRequests()),
 		outgoing: make(chan Envelope, 32),
 		quit:     make(chan struct{}),
 	}
 
-	s.mu.Lock()
-	if s.cfg.MaxPeers > 0 && len(s.peers) >= s.cfg.MaxPeers {
-		s.mu.Unlock()
-		conn.Close()
-		return
+	if s.cfg.Gater != nil && !s.cfg.Gater.InterceptPeer(peer.info) {
+		secure.Close()
+		return node, ErrGated
+	}
+	if err := s.addPeer(peer); err != nil {
+		if !errors.Is(err, ErrDuplicatePeer) {
+			log.Printf("p2p: rejected peer %s: %v", node.ID, err)
+		}
+		secure.Close()
+		return node, err
 	}
-	s.peers[peerID] = peer
-	metrics.SetPeerCount(len(s.peers))
-	s.mu.Unlock()
 
 	go s.readLoop(peer)
 	go s.writeLoop(peer)
+	go s.pingLoop(peer)
+	s.peerAdded(peer)
+	return node, nil
+}
+
+func (s *Server) addPeer(peer *Peer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.quit:
+		return ErrServerClosed
+	default:
+	}
+	id := peer.info.ID
+	if existing, ok := s.peers[id]; ok {
+		if !s.preferConnection(peer, existing) {
+			return ErrDuplicatePeer
+		}
+		close(existing.quit)
+		existing.conn.Close()
+		delete(s.peers, id)
+	}
+	if err := s.checkSlotsLocked(peer); err != nil {
+		return err
+	}
+	s.peers[id] = peer
+	metrics.SetPeerCount(len(s.peers))
+	return nil
+}
+
+// preferConnection decides which of two connections to the same peer to keep.
+// Both ends must pick the same one, so the rule only depends on who dialed:
+// keep the connection opened by the node with the lower ID.
+func (s *Server) preferConnection(candidate, existing *Peer) bool {
+	dialer := func(p *Peer) string {
+		if p.info.Inbound {
+			return p.info.ID
+		}
+		return s.id
+	}
+	lower := min(s.id, candidate.info.ID)
+	return dialer(candidate) == lower && dialer(existing) != lower
 }
@@ -0,0 +1,82 @@
+package p2p
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxMessageSize caps a frame's payload when Config sets no limit.
+const DefaultMaxMessageSize = 4 << 20
+
+const defaultBufferSize = 64 * 1024
+
+var (
+	ErrMessageTooLarge = errors.New("p2p: message too large")
+	ErrEmptyFrame      = errors.New("p2p: empty frame")
+)
+
+// A frame on the wire is a uvarint length followed by that many bytes: one
+// MessageType byte and the raw payload. Payloads are never re-encoded, so a
+// block serialized once by its sender is delivered to handlers as-is.
+
+func (s *Server) maxMessageSize(t MessageType) int {
+	if limit, ok := s.cfg.MaxMessageSizes[t]; ok && limit > 0 {
+		return limit
+	}
+	if s.cfg.MaxMessageSize > 0 {
+		return s.cfg.MaxMessageSize
+	}
+	return DefaultMaxMessageSize
+}
+
+func writeFrame(w *bufio.Writer, env Envelope) error {
+	var header [binary.MaxVarintLen64 + 1]byte
+	n := binary.PutUvarint(header[:], uint64(len(env.Payload))+1)
+	header[n] = byte(env.Type)
+	if _, err := w.Write(header[:n+1]); err != nil {
+		return err
+	}
+	_, err := w.Write(env.Payload)
+	return err
+}
+
+// frameSize is the number of bytes env occupies on the wire.
+func frameSize(env Envelope) int {
+	var header [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(header[:], uint64(len(env.Payload))+1) + 1 + len(env.Payload)
+}
+
+// readFrame reads one frame, rejecting it before allocating if the payload
+// exceeds the limit for its type.
+func readFrame(r *bufio.Reader, limit func(MessageType) int) (Envelope, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return Envelope{}, err
+	}
+	if size == 0 {
+		return Envelope{}, ErrEmptyFrame
+	}
+	typ, err := r.ReadByte()
+	if err != nil {
+		return Envelope{}, err
+	}
+	env := Envelope{Type: MessageType(typ)}
+	if max := limit(env.Type &^ compressedFlag); size-1 > uint64(max) {
+		return Envelope{}, fmt.Errorf("%w: type %d, %d bytes (limit %d)", ErrMessageTooLarge, typ, size-1, max)
+	}
+	env.Payload = make([]byte, size-1)
+	if _, err := io.ReadFull(r, env.Payload); err != nil {
+		return Envelope{}, err
+	}
+	return env, nil
+}
+
+func bufferSize(configured int) int {
+	if configured > 0 {
+		return configured
+	}
+	return defaultBufferSize
+}
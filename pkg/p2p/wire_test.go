@@ -0,0 +1,85 @@
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	sent := []Envelope{
+		{Type: MessageTypeTx, Payload: []byte(`{"nonce":1}`)},
+		{Type: MessageTypePing, Payload: nil},
+		{Type: MessageTypeBlock, Payload: bytes.Repeat([]byte{0xab}, 300)},
+	}
+	for _, env := range sent {
+		if err := writeFrame(w, env); err != nil {
+			t.Fatalf("write frame: %v", err)
+		}
+	}
+	w.Flush()
+
+	r := bufio.NewReader(&buf)
+	limit := func(MessageType) int { return DefaultMaxMessageSize }
+	for i, want := range sent {
+		got, err := readFrame(r, limit)
+		if err != nil {
+			t.Fatalf("read frame %d: %v", i, err)
+		}
+		if got.Type != want.Type || !bytes.Equal(got.Payload, want.Payload) {
+			t.Fatalf("frame %d mismatch: got %+v want %+v", i, got, want)
+		}
+	}
+}
+
+func TestReadFrameEnforcesPerTypeLimit(t *testing.T) {
+	s := NewServer(Config{
+		MaxMessageSize:  1024,
+		MaxMessageSizes: map[MessageType]int{MessageTypeTx: 16},
+	})
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeFrame(w, Envelope{Type: MessageTypeBlock, Payload: make([]byte, 512)})
+	writeFrame(w, Envelope{Type: MessageTypeTx, Payload: make([]byte, 17)})
+	w.Flush()
+
+	r := bufio.NewReader(&buf)
+	if _, err := readFrame(r, s.maxMessageSize); err != nil {
+		t.Fatalf("block under the default limit rejected: %v", err)
+	}
+	if _, err := readFrame(r, s.maxMessageSize); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("expected ErrMessageTooLarge, got %v", err)
+	}
+}
+
+func TestServerDropsPeerSendingOversizedFrame(t *testing.T) {
+	a := newTestServer(t, Config{})
+	b := newTestServer(t, Config{
+		Seeds:           []string{a.listener.Addr().String()},
+		MaxMessageSizes: map[MessageType]int{MessageTypeTx: 8},
+	})
+
+	waitFor := func(cond func() bool) bool {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if cond() {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	}
+	if !waitFor(func() bool { return len(a.Peers()) == 1 && len(b.Peers()) == 1 }) {
+		t.Fatal("peers failed to connect")
+	}
+
+	a.Broadcast(NewEnvelope(MessageTypeTx, make([]byte, 64), ""))
+	if !waitFor(func() bool { return len(b.Peers()) == 0 }) {
+		t.Fatal("peer sending an oversized frame was not disconnected")
+	}
+}
@@ -0,0 +1,361 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/0xphantomotr/gchain/pkg/chain"
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+// JSON-RPC 2.0 error codes. -32000 to -32099 are reserved for server errors.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeServerError    = -32000
+	CodeNotFound       = -32001
+)
+
+const (
+	jsonrpcVersion  = "2.0"
+	maxBatchSize    = 100
+	maxRequestBytes = 1 << 20
+)
+
+var (
+	ErrMethodExists = errors.New("rpc: method already registered")
+	errTxNotFound   = errors.New("transaction not found")
+)
+
+// MethodFunc serves one JSON-RPC method. params is the raw "params" member,
+// nil when absent. Returning an *Error sets the response error code; any
+// other error is reported as CodeServerError.
+type MethodFunc func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+// Error is a JSON-RPC error object.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// InvalidParams wraps err as a CodeInvalidParams error.
+func InvalidParams(err error) *Error {
+	return &Error{Code: CodeInvalidParams, Message: err.Error()}
+}
+
+type jsonrpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type AccountResponse struct {
+	Address string `json:"address"`
+	Balance uint64 `json:"balance"`
+	Nonce   uint64 `json:"nonce"`
+}
+
+// Transaction statuses reported by tx_get and GET /tx/{hash}.
+const (
+	TxStatusPending  = "pending"
+	TxStatusIncluded = "included"
+	TxStatusFailed   = "failed"
+	TxStatusUnknown  = "unknown"
+)
+
+type TxResponse struct {
+	Hash        string             `json:"hash"`
+	Status      string             `json:"status"`
+	BlockHeight uint64             `json:"block_height,omitempty"`
+	Transaction *types.Transaction `json:"transaction,omitempty"`
+	Receipt     *types.Receipt     `json:"receipt,omitempty"`
+}
+
+type MempoolStatusResponse struct {
+	Pending int `json:"pending"`
+}
+
+// RegisterMethod adds a JSON-RPC method served on POST /.
+func (s *Server) RegisterMethod(name string, fn MethodFunc) error {
+	s.methodsMu.Lock()
+	defer s.methodsMu.Unlock()
+	if _, ok := s.methods[name]; ok {
+		return fmt.Errorf("%w: %s", ErrMethodExists, name)
+	}
+	s.methods[name] = fn
+	return nil
+}
+
+func (s *Server) registerBuiltinMethods() {
+	for name, fn := range map[string]MethodFunc{
+		"chain_getBlockByHeight": s.rpcGetBlockByHeight,
+		"chain_getBlockByHash":   s.rpcGetBlockByHash,
+		"state_getAccount":       s.rpcGetAccount,
+		"tx_send":                s.rpcSendTx,
+		"tx_get":                 s.rpcGetTx,
+		"mempool_status":         s.rpcMempoolStatus,
+	} {
+		if err := s.RegisterMethod(name, fn); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// handleJSONRPC serves single and batch JSON-RPC 2.0 requests on POST /.
+func (s *Server) handleJSONRPC(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBytes+1))
+	if err != nil {
+		writeJSON(w, http.StatusOK, errorReply(nil, &Error{Code: CodeParseError, Message: err.Error()}))
+		return
+	}
+	if len(body) > maxRequestBytes {
+		writeJSON(w, http.StatusOK, errorReply(nil, &Error{Code: CodeInvalidRequest, Message: "request too large"}))
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		var raw json.RawMessage
+		if err := json.Unmarshal(body, &raw); err != nil {
+			writeJSON(w, http.StatusOK, errorReply(nil, &Error{Code: CodeParseError, Message: err.Error()}))
+			return
+		}
+		if resp := s.callJSONRPC(r.Context(), raw); resp != nil {
+			writeJSON(w, http.StatusOK, resp)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		writeJSON(w, http.StatusOK, errorReply(nil, &Error{Code: CodeParseError, Message: err.Error()}))
+		return
+	}
+	if len(batch) == 0 {
+		writeJSON(w, http.StatusOK, errorReply(nil, &Error{Code: CodeInvalidRequest, Message: "empty batch"}))
+		return
+	}
+	if len(batch) > maxBatchSize {
+		writeJSON(w, http.StatusOK, errorReply(nil, &Error{Code: CodeInvalidRequest, Message: fmt.Sprintf("batch exceeds %d requests", maxBatchSize)}))
+		return
+	}
+	replies := make([]*jsonrpcResponse, 0, len(batch))
+	for _, raw := range batch {
+		if resp := s.callJSONRPC(r.Context(), raw); resp != nil {
+			replies = append(replies, resp)
+		}
+	}
+	if len(replies) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, replies)
+}
+
+// callJSONRPC runs one request. Notifications (requests without an id) get
+// no response.
+func (s *Server) callJSONRPC(ctx context.Context, raw json.RawMessage) *jsonrpcResponse {
+	var req jsonrpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return errorReply(nil, &Error{Code: CodeInvalidRequest, Message: "invalid request object"})
+	}
+	if req.JSONRPC != jsonrpcVersion || req.Method == "" {
+		return errorReply(req.ID, &Error{Code: CodeInvalidRequest, Message: "invalid request object"})
+	}
+	notification := req.ID == nil
+
+	s.methodsMu.RLock()
+	fn, ok := s.methods[req.Method]
+	s.methodsMu.RUnlock()
+	if !ok {
+		if notification {
+			return nil
+		}
+		return errorReply(req.ID, &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("method %q not found", req.Method)})
+	}
+
+	result, err := fn(ctx, req.Params)
+	if notification {
+		return nil
+	}
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: CodeServerError, Message: err.Error()}
+		}
+		return errorReply(req.ID, rpcErr)
+	}
+	if result == nil {
+		result = json.RawMessage("null")
+	}
+	return &jsonrpcResponse{JSONRPC: jsonrpcVersion, ID: req.ID, Result: result}
+}
+
+func errorReply(id json.RawMessage, err *Error) *jsonrpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &jsonrpcResponse{JSONRPC: jsonrpcVersion, ID: id, Error: err}
+}
+
+// decodeParams decodes positional params into args.
+func decodeParams(params json.RawMessage, args ...interface{}) error {
+	var list []json.RawMessage
+	if err := json.Unmarshal(params, &list); err != nil {
+		return InvalidParams(errors.New("params must be an array"))
+	}
+	if len(list) != len(args) {
+		return InvalidParams(fmt.Errorf("expected %d params, got %d", len(args), len(list)))
+	}
+	for i, raw := range list {
+		if err := json.Unmarshal(raw, args[i]); err != nil {
+			return InvalidParams(fmt.Errorf("param %d: %v", i, err))
+		}
+	}
+	return nil
+}
+
+func blockResponse(block *types.Block) BlockResponse {
+	return BlockResponse{Header: block.Header, Transactions: block.Transactions}
+}
+
+func (s *Server) rpcGetBlockByHeight(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var height uint64
+	if err := decodeParams(params, &height); err != nil {
+		return nil, err
+	}
+	block, err := s.chain.GetBlockByHeight(height)
+	if err != nil {
+		return nil, &Error{Code: CodeNotFound, Message: err.Error()}
+	}
+	return blockResponse(block), nil
+}
+
+func (s *Server) rpcGetBlockByHash(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var hashStr string
+	if err := decodeParams(params, &hashStr); err != nil {
+		return nil, err
+	}
+	hash, err := parseHash(hashStr)
+	if err != nil {
+		return nil, InvalidParams(err)
+	}
+	block, err := s.chain.GetBlockByHash(hash)
+	if err != nil {
+		return nil, &Error{Code: CodeNotFound, Message: err.Error()}
+	}
+	return blockResponse(block), nil
+}
+
+func (s *Server) rpcGetAccount(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var addrStr string
+	if err := decodeParams(params, &addrStr); err != nil {
+		return nil, err
+	}
+	addr, err := parseAddress(addrStr)
+	if err != nil {
+		return nil, InvalidParams(err)
+	}
+	account, err := s.state.GetAccount(addr)
+	if err != nil {
+		return nil, err
+	}
+	return AccountResponse{Address: addr.String(), Balance: account.Balance, Nonce: account.Nonce}, nil
+}
+
+func (s *Server) rpcSendTx(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var req SubmitTxRequest
+	if err := decodeParams(params, &req); err != nil {
+		return nil, err
+	}
+	tx, err := txFromRequest(req)
+	if err != nil {
+		return nil, InvalidParams(err)
+	}
+	if err := s.submitTx(tx); err != nil {
+		return nil, err
+	}
+	return SubmitTxResponse{TxHash: tx.Hash.String()}, nil
+}
+
+func (s *Server) rpcGetTx(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var hashStr string
+	if err := decodeParams(params, &hashStr); err != nil {
+		return nil, err
+	}
+	hash, err := parseHash(hashStr)
+	if err != nil {
+		return nil, InvalidParams(err)
+	}
+	resp, err := s.lookupTx(hash)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status == TxStatusUnknown {
+		return nil, &Error{Code: CodeNotFound, Message: errTxNotFound.Error()}
+	}
+	return resp, nil
+}
+
+func (s *Server) rpcMempoolStatus(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	return MempoolStatusResponse{Pending: s.mempool.Size()}, nil
+}
+
+// lookupTx reports a transaction from the mempool or the chain's tx index.
+// Hashes found in neither come back with TxStatusUnknown.
+func (s *Server) lookupTx(hash types.Hash) (TxResponse, error) {
+	if tx, ok := s.mempool.Get(hash); ok {
+		return TxResponse{Hash: hash.String(), Status: TxStatusPending, Transaction: &tx}, nil
+	}
+	tx, loc, err := s.chain.GetTransaction(hash)
+	if errors.Is(err, chain.ErrTxNotFound) {
+		return TxResponse{Hash: hash.String(), Status: TxStatusUnknown}, nil
+	}
+	if err != nil {
+		return TxResponse{}, err
+	}
+	resp := TxResponse{Hash: hash.String(), Status: TxStatusIncluded, BlockHeight: loc.Height, Transaction: tx}
+	receipt, err := s.chain.GetReceipt(hash)
+	switch {
+	case err == nil:
+		resp.Receipt = receipt
+		if !receipt.Success {
+			resp.Status = TxStatusFailed
+		}
+	case !errors.Is(err, chain.ErrReceiptNotFound):
+		return TxResponse{}, err
+	}
+	return resp, nil
+}
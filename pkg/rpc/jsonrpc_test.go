@@ -0,0 +1,127 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postJSONRPC(t *testing.T, url, body string) (int, []byte) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	var raw json.RawMessage
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+	}
+	return resp.StatusCode, raw
+}
+
+func TestJSONRPCSingleAndErrors(t *testing.T) {
+	server, _, _, _ := newTestServer(t)
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+
+	_, raw := postJSONRPC(t, ts.URL, `{"jsonrpc":"2.0","id":1,"method":"mempool_status"}`)
+	var resp struct {
+		ID     int                   `json:"id"`
+		Result MempoolStatusResponse `json:"result"`
+		Error  *Error                `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &resp); err != nil || resp.ID != 1 || resp.Error != nil {
+		t.Fatalf("unexpected response: %s", raw)
+	}
+
+	for body, code := range map[string]int{
+		`{"jsonrpc":"2.0","id":1`:                                                 CodeParseError,
+		`{"jsonrpc":"1.0","id":1,"method":"mempool_status"}`:                      CodeInvalidRequest,
+		`{"jsonrpc":"2.0","id":1,"method":"nope"}`:                                CodeMethodNotFound,
+		`{"jsonrpc":"2.0","id":1,"method":"chain_getBlockByHeight"}`:              CodeInvalidParams,
+		`{"jsonrpc":"2.0","id":1,"method":"chain_getBlockByHeight","params":[7]}`: CodeNotFound,
+	} {
+		_, raw := postJSONRPC(t, ts.URL, body)
+		var resp jsonrpcResponse
+		if err := json.Unmarshal(raw, &resp); err != nil || resp.Error == nil || resp.Error.Code != code {
+			t.Fatalf("%s: expected code %d, got %s", body, code, raw)
+		}
+	}
+
+	if status, _ := postJSONRPC(t, ts.URL, `{"jsonrpc":"2.0","method":"mempool_status"}`); status != http.StatusNoContent {
+		t.Fatalf("expected no content for a notification, got %d", status)
+	}
+}
+
+func TestJSONRPCBatch(t *testing.T) {
+	server, _, _, pool := newTestServer(t)
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+
+	_, raw := postJSONRPC(t, ts.URL, `[
+		{"jsonrpc":"2.0","id":"send","method":"tx_send","params":[{"from":"0101010101010101010101010101010101010101010101010101010101010101","to":"0202020202020202020202020202020202020202020202020202020202020202","amount":5}]},
+		{"jsonrpc":"2.0","method":"mempool_status"},
+		{"jsonrpc":"2.0","id":"bad","method":"missing"}
+	]`)
+	var replies []struct {
+		ID     string           `json:"id"`
+		Result SubmitTxResponse `json:"result"`
+		Error  *Error           `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &replies); err != nil || len(replies) != 2 {
+		t.Fatalf("expected two replies, got %s", raw)
+	}
+	if replies[0].ID != "send" || replies[0].Error != nil || pool.Size() != 1 {
+		t.Fatalf("tx_send failed: %s", raw)
+	}
+	if replies[1].ID != "bad" || replies[1].Error == nil || replies[1].Error.Code != CodeMethodNotFound {
+		t.Fatalf("expected method not found, got %s", raw)
+	}
+
+	_, raw = postJSONRPC(t, ts.URL, `{"jsonrpc":"2.0","id":2,"method":"tx_get","params":["`+replies[0].Result.TxHash+`"]}`)
+	var got struct {
+		Result TxResponse `json:"result"`
+	}
+	if err := json.Unmarshal(raw, &got); err != nil || got.Result.Status != "pending" {
+		t.Fatalf("expected pending tx, got %s", raw)
+	}
+}
+
+func TestRegisterMethod(t *testing.T) {
+	server, _, _, _ := newTestServer(t)
+	echo := func(ctx context.Context, params json.RawMessage) (interface{}, error) { return params, nil }
+	if err := server.RegisterMethod("test_echo", echo); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := server.RegisterMethod("test_echo", echo); !errors.Is(err, ErrMethodExists) {
+		t.Fatalf("expected ErrMethodExists, got %v", err)
+	}
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+
+	_, raw := postJSONRPC(t, ts.URL, `{"jsonrpc":"2.0","id":1,"method":"test_echo","params":["hi"]}`)
+	if !strings.Contains(string(raw), `"result":["hi"]`) {
+		t.Fatalf("unexpected echo: %s", raw)
+	}
+}
+
+func TestJSONRPCSendTxRejectsBadParams(t *testing.T) {
+	server, _, _, _ := newTestServer(t)
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+
+	for _, params := range []string{`[{"from":"zz","to":"zz","amount":1}]`, `[{"type":"nope"}]`} {
+		_, raw := postJSONRPC(t, ts.URL, `{"jsonrpc":"2.0","id":1,"method":"tx_send","params":`+params+`}`)
+		var resp jsonrpcResponse
+		if err := json.Unmarshal(raw, &resp); err != nil || resp.Error == nil || resp.Error.Code != CodeInvalidParams {
+			t.Fatalf("%s: expected invalid params, got %s", params, raw)
+		}
+	}
+}
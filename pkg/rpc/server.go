@@ -2,6 +2,7 @@ package rpc
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"expvar"
@@ -9,9 +10,11 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/0xphantomotr/gchain/pkg/chain"
+	"github.com/0xphantomotr/gchain/pkg/events"
 	"github.com/0xphantomotr/gchain/pkg/mempool"
 	"github.com/0xphantomotr/gchain/pkg/metrics"
 	"github.com/0xphantomotr/gchain/pkg/p2p"
@@ -20,6 +23,7 @@ import (
 )
 
 type SubmitTxRequest struct {
+	Type   string `json:"type,omitempty"`
 	From   string `json:"from"`
 	To     string `json:"to"`
 	Amount uint64 `json:"amount"`
@@ -31,10 +35,69 @@ type BlockResponse struct {
 	Header       types.BlockHeader   `json:"header"`
 	Transactions []types.Transaction `json:"transactions"`
 }
+
+// BlocksResponse is a page of /blocks. It always carries the array that was
+// asked for, blocks or headers (with headers_only), even when it is empty.
+type BlocksResponse struct {
+	Blocks     []BlockResponse     `json:"blocks,omitempty"`
+	Headers    []types.BlockHeader `json:"headers,omitempty"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+
+	headersOnly bool
+}
+
+func (r BlocksResponse) MarshalJSON() ([]byte, error) {
+	if r.headersOnly {
+		if r.Headers == nil {
+			r.Headers = []types.BlockHeader{}
+		}
+		return json.Marshal(struct {
+			Headers    []types.BlockHeader `json:"headers"`
+			NextCursor string              `json:"next_cursor,omitempty"`
+		}{r.Headers, r.NextCursor})
+	}
+	if r.Blocks == nil {
+		r.Blocks = []BlockResponse{}
+	}
+	return json.Marshal(struct {
+		Blocks     []BlockResponse `json:"blocks"`
+		NextCursor string          `json:"next_cursor,omitempty"`
+	}{r.Blocks, r.NextCursor})
+}
+
 type BalanceResponse struct {
 	Address string `json:"address"`
 	Balance uint64 `json:"balance"`
 }
+type SupplyResponse struct {
+	Height      uint64 `json:"height"`
+	TotalSupply uint64 `json:"total_supply"`
+}
+type ValidatorResponse struct {
+	Address  string `json:"address"`
+	SelfBond uint64 `json:"self_bond"`
+	Stake    uint64 `json:"stake"`
+}
+
+type BanRequest struct {
+	Kind     string `json:"kind"`
+	Target   string `json:"target"`
+	Duration string `json:"duration,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type PeerResponse struct {
+	ID         string  `json:"id"`
+	Addr       string  `json:"addr"`
+	ListenAddr string  `json:"listen_addr,omitempty"`
+	Inbound    bool    `json:"inbound"`
+	RTTMillis  float64 `json:"rtt_ms"`
+}
+
+const (
+	defaultBlocksLimit = 20
+	maxBlocksLimit     = 100
+)
 
 type Server struct {
 	chain      *chain.Manager
@@ -42,16 +105,31 @@ type Server struct {
 	mempool    *mempool.Mempool
 	transport  p2p.Transport
 	httpServer *http.Server
+
+	methodsMu sync.RWMutex
+	methods   map[string]MethodFunc
+	events    *events.Bus
+	wsOrigins []string
 }
 
 func NewServer(chain *chain.Manager, state *state.Manager, pool *mempool.Mempool, transport p2p.Transport, listenAddr string) *Server {
 	mux := http.NewServeMux()
-	srv := &Server{chain: chain, state: state, mempool: pool, transport: transport}
+	srv := &Server{chain: chain, state: state, mempool: pool, transport: transport, methods: make(map[string]MethodFunc)}
+	srv.registerBuiltinMethods()
+	mux.HandleFunc("/", srv.handleJSONRPC)
 	mux.HandleFunc("/healthz", srv.handleHealth)
 	mux.HandleFunc("/tx", srv.handleSubmitTx)
+	mux.HandleFunc("/tx/", srv.handleGetTx)
 	mux.HandleFunc("/block/", srv.handleGetBlock)
+	mux.HandleFunc("/block/hash/", srv.handleGetBlockByHash)
+	mux.HandleFunc("/blocks", srv.handleGetBlocks)
 	mux.HandleFunc("/balance/", srv.handleGetBalance)
 	mux.HandleFunc("/tip", srv.handleGetTip)
+	mux.HandleFunc("/validators", srv.handleGetValidators)
+	mux.HandleFunc("/supply", srv.handleGetSupply)
+	mux.HandleFunc("/p2p/bans", srv.handleBans)
+	mux.HandleFunc("/p2p/peers", srv.handlePeers)
+	mux.HandleFunc("/ws", srv.handleWS)
 	mux.Handle("/metrics", expvar.Handler())
 	srv.httpServer = &http.Server{Addr: listenAddr, Handler: mux}
 	return srv
@@ -81,30 +159,49 @@ func (s *Server) handleSubmitTx(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, errorResponse(err))
 		return
 	}
-
-	from, err := parseAddress(req.From)
+	tx, err := txFromRequest(req)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, errorResponse(err))
 		return
 	}
-	to, err := parseAddress(req.To)
-	if err != nil {
+	if err := s.submitTx(tx); err != nil {
 		writeJSON(w, http.StatusBadRequest, errorResponse(err))
 		return
 	}
 
+	writeJSON(w, http.StatusOK, SubmitTxResponse{TxHash: tx.Hash.String()})
+}
+
+// txFromRequest builds and hashes the transaction described by req.
+func txFromRequest(req SubmitTxRequest) (types.Transaction, error) {
+	txType, err := parseTxType(req.Type)
+	if err != nil {
+		return types.Transaction{}, err
+	}
+	from, err := parseAddress(req.From)
+	if err != nil {
+		return types.Transaction{}, err
+	}
+	to, err := parseAddress(req.To)
+	if err != nil {
+		return types.Transaction{}, err
+	}
+
 	tx := types.Transaction{
+		Type:      txType,
 		From:      from,
 		To:        to,
 		Amount:    req.Amount,
 		Timestamp: time.Now(),
 	}
-
 	tx.Hash = tx.CalculateHash()
+	return tx, nil
+}
 
+// submitTx adds tx to the mempool and gossips it to peers.
+func (s *Server) submitTx(tx types.Transaction) error {
 	if err := s.mempool.Add(tx); err != nil {
-		writeJSON(w, http.StatusBadRequest, errorResponse(err))
-		return
+		return err
 	}
 	metrics.IncTxSubmitted()
 
@@ -112,8 +209,31 @@ func (s *Server) handleSubmitTx(w http.ResponseWriter, r *http.Request) {
 		payload := p2p.MustMarshalPayload(tx)
 		s.transport.Broadcast(p2p.NewEnvelope(p2p.MessageTypeTx, payload, ""))
 	}
+	return nil
+}
 
-	writeJSON(w, http.StatusOK, SubmitTxResponse{TxHash: tx.Hash.String()})
+// handleGetTx reports a transaction's status: pending in the mempool,
+// included or failed in a block (with its receipt), or unknown.
+func (s *Server) handleGetTx(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hash, err := parseHash(strings.TrimPrefix(r.URL.Path, "/tx/"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse(err))
+		return
+	}
+	resp, err := s.lookupTx(hash)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	if resp.Status == TxStatusUnknown {
+		writeJSON(w, http.StatusNotFound, resp)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
 func (s *Server) handleGetBlock(w http.ResponseWriter, r *http.Request) {
@@ -134,10 +254,83 @@ func (s *Server) handleGetBlock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, http.StatusOK, BlockResponse{
-		Header:       block.Header,
-		Transactions: block.Transactions,
-	})
+	writeJSON(w, http.StatusOK, blockResponse(block))
+}
+
+func (s *Server) handleGetBlockByHash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hash, err := parseHash(strings.TrimPrefix(r.URL.Path, "/block/hash/"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	block, err := s.chain.GetBlockByHash(hash)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, blockResponse(block))
+}
+
+// handleGetBlocks returns blocks from..to (default 1..tip) in ascending
+// order, at most limit per page. Pass the returned next_cursor as cursor to
+// fetch the following page; headers_only=true omits transactions.
+func (s *Server) handleGetBlocks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	tip, _ := s.chain.Tip()
+	from, err := parseUintParam(query.Get("from"), 1)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorPayload{Error: "invalid from: " + err.Error()})
+		return
+	}
+	to, err := parseUintParam(query.Get("to"), tip)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorPayload{Error: "invalid to: " + err.Error()})
+		return
+	}
+	limit, err := parseUintParam(query.Get("limit"), defaultBlocksLimit)
+	if err != nil || limit == 0 {
+		writeJSON(w, http.StatusBadRequest, errorPayload{Error: "invalid limit"})
+		return
+	}
+	limit = min(limit, maxBlocksLimit)
+	if cursor := query.Get("cursor"); cursor != "" {
+		if from, err = parseCursor(cursor); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorPayload{Error: "invalid cursor"})
+			return
+		}
+	}
+	headersOnly, _ := strconv.ParseBool(query.Get("headers_only"))
+
+	from = max(from, 1)
+	to = min(to, tip)
+	out := BlocksResponse{headersOnly: headersOnly}
+	height := from
+	for ; height <= to && height-from < limit; height++ {
+		block, err := s.chain.GetBlockByHeight(height)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorResponse(err))
+			return
+		}
+		if headersOnly {
+			out.Headers = append(out.Headers, block.Header)
+		} else {
+			out.Blocks = append(out.Blocks, blockResponse(block))
+		}
+	}
+	if height <= to {
+		out.NextCursor = formatCursor(height)
+	}
+	writeJSON(w, http.StatusOK, out)
 }
 
 func (s *Server) handleGetBalance(w http.ResponseWriter, r *http.Request) {
@@ -172,6 +365,121 @@ func (s *Server) handleGetTip(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (s *Server) handleGetValidators(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	validators, err := s.state.ActiveValidators(0)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+
+	out := make([]ValidatorResponse, 0, len(validators))
+	for _, v := range validators {
+		out = append(out, ValidatorResponse{
+			Address:  v.Address.String(),
+			SelfBond: v.SelfBond,
+			Stake:    v.Stake,
+		})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleGetSupply(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	supply, err := s.state.TotalSupply()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse(err))
+		return
+	}
+	height, _ := s.chain.Tip()
+	writeJSON(w, http.StatusOK, SupplyResponse{Height: height, TotalSupply: supply})
+}
+
+// handleBans lists bans on GET, adds one on POST and lifts one on DELETE
+// (?kind=&target=). It needs a transport that implements p2p.BanManager.
+func (s *Server) handleBans(w http.ResponseWriter, r *http.Request) {
+	bans, ok := s.transport.(p2p.BanManager)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, errorPayload{Error: "transport does not support bans"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		list := bans.Bans()
+		if list == nil {
+			list = []p2p.Ban{}
+		}
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		defer r.Body.Close()
+		var req BanRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse(err))
+			return
+		}
+		if req.Target == "" {
+			writeJSON(w, http.StatusBadRequest, errorPayload{Error: "target is required"})
+			return
+		}
+		var d time.Duration
+		if req.Duration != "" {
+			var err error
+			if d, err = time.ParseDuration(req.Duration); err != nil {
+				writeJSON(w, http.StatusBadRequest, errorResponse(err))
+				return
+			}
+		}
+		if err := bans.BanPeer(p2p.BanKind(req.Kind), req.Target, d, req.Reason); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "banned"})
+	case http.MethodDelete:
+		kind := p2p.BanKind(r.URL.Query().Get("kind"))
+		if !bans.Unban(kind, r.URL.Query().Get("target")) {
+			writeJSON(w, http.StatusNotFound, errorPayload{Error: "ban not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "unbanned"})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePeers lists connected peers, fastest round trip first. It needs a
+// transport that implements p2p.PeerLister.
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	lister, ok := s.transport.(p2p.PeerLister)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, errorPayload{Error: "transport does not list peers"})
+		return
+	}
+	peers := lister.Peers()
+	p2p.SortByLatency(peers)
+	out := make([]PeerResponse, 0, len(peers))
+	for _, peer := range peers {
+		out = append(out, PeerResponse{
+			ID:         peer.ID,
+			Addr:       peer.Addr,
+			ListenAddr: peer.ListenAddr,
+			Inbound:    peer.Inbound,
+			RTTMillis:  float64(peer.RTT) / float64(time.Millisecond),
+		})
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
 // Helpers
 
 type errorPayload struct {
@@ -200,3 +508,49 @@ func parseAddress(hexStr string) (types.Address, error) {
 	copy(addr[:], b)
 	return addr, nil
 }
+
+func parseHash(hexStr string) (types.Hash, error) {
+	var hash types.Hash
+	b, err := hex.DecodeString(strings.TrimPrefix(hexStr, "0x"))
+	if err != nil {
+		return hash, err
+	}
+	if len(b) != len(hash) {
+		return hash, fmt.Errorf("invalid hash length")
+	}
+	copy(hash[:], b)
+	return hash, nil
+}
+
+func parseUintParam(value string, fallback uint64) (uint64, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// Cursors are opaque to clients; they encode the next height to return.
+func formatCursor(height uint64) string {
+	return base64.RawURLEncoding.EncodeToString(strconv.AppendUint(nil, height, 10))
+}
+
+func parseCursor(cursor string) (uint64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(raw), 10, 64)
+}
+
+func parseTxType(name string) (types.TxType, error) {
+	switch strings.ToLower(name) {
+	case "", "transfer":
+		return types.TxTypeTransfer, nil
+	case "bond":
+		return types.TxTypeBond, nil
+	case "unbond":
+		return types.TxTypeUnbond, nil
+	default:
+		return 0, fmt.Errorf("unknown tx type %q", name)
+	}
+}
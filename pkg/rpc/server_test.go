@@ -3,14 +3,17 @@ package rpc
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/0xphantomotr/gchain/pkg/chain"
 	"github.com/0xphantomotr/gchain/pkg/mempool"
+	"github.com/0xphantomotr/gchain/pkg/p2p"
 	"github.com/0xphantomotr/gchain/pkg/state"
 	"github.com/0xphantomotr/gchain/pkg/types"
 )
@@ -138,3 +141,251 @@ func seedAccount(t *testing.T, store *state.MemoryStore, account state.Account)
 		t.Fatalf("seed store: %v", err)
 	}
 }
+
+func TestBanEndpoints(t *testing.T) {
+	chainMgr, err := chain.NewManager(chain.NewMemoryStore())
+	if err != nil {
+		t.Fatalf("new chain manager: %v", err)
+	}
+	transport := p2p.NewServer(p2p.Config{})
+	server := NewServer(chainMgr, state.NewManager(state.NewMemoryStore()), mempool.New(10, nil), transport, ":0")
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+
+	body, _ := json.Marshal(BanRequest{Kind: "ip", Target: "10.0.0.7", Duration: "1h", Reason: "spam"})
+	resp, err := http.Post(ts.URL+"/p2p/bans", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("post ban: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL + "/p2p/bans")
+	if err != nil {
+		t.Fatalf("list bans: %v", err)
+	}
+	var bans []p2p.Ban
+	json.NewDecoder(resp.Body).Decode(&bans)
+	resp.Body.Close()
+	if len(bans) != 1 || bans[0].Target != "10.0.0.7" || bans[0].Reason != "spam" {
+		t.Fatalf("unexpected bans: %+v", bans)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/p2p/bans?kind=ip&target=10.0.0.7", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("delete ban: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(transport.Bans()) != 0 {
+		t.Fatalf("ban was not lifted (status %d)", resp.StatusCode)
+	}
+}
+
+func TestBanEndpointsNeedBanManager(t *testing.T) {
+	server, _, _, _ := newTestServer(t)
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/p2p/bans")
+	if err != nil {
+		t.Fatalf("list bans: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("expected 501 without a ban manager, got %d", resp.StatusCode)
+	}
+}
+
+func TestPeersEndpoint(t *testing.T) {
+	chainMgr, err := chain.NewManager(chain.NewMemoryStore())
+	if err != nil {
+		t.Fatalf("new chain manager: %v", err)
+	}
+	server := NewServer(chainMgr, state.NewManager(state.NewMemoryStore()), mempool.New(10, nil), p2p.NewServer(p2p.Config{}), ":0")
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/p2p/peers")
+	if err != nil {
+		t.Fatalf("list peers: %v", err)
+	}
+	defer resp.Body.Close()
+	var peers []PeerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&peers); err != nil {
+		t.Fatalf("decode peers: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || peers == nil || len(peers) != 0 {
+		t.Fatalf("expected an empty peer list, got %d %+v", resp.StatusCode, peers)
+	}
+}
+
+func TestSubmittedTxGossipsToPeers(t *testing.T) {
+	hub := p2p.NewMemoryHub(p2p.MemoryHubConfig{Latency: time.Millisecond})
+	nodeA, _ := hub.Connect("a")
+	nodeB, _ := hub.Connect("b")
+	for _, node := range []*p2p.MemoryTransport{nodeA, nodeB} {
+		node.Start()
+		defer node.Close()
+	}
+	remotePool := mempool.New(10, nil)
+	nodeB.RegisterHandler(p2p.MessageTypeTx, func(peer p2p.PeerInfo, payload []byte) {
+		var tx types.Transaction
+		if err := json.Unmarshal(payload, &tx); err == nil {
+			remotePool.Add(tx)
+		}
+	})
+
+	chainMgr, err := chain.NewManager(chain.NewMemoryStore())
+	if err != nil {
+		t.Fatalf("new chain manager: %v", err)
+	}
+	server := NewServer(chainMgr, state.NewManager(state.NewMemoryStore()), mempool.New(10, nil), nodeA, ":0")
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+
+	body, _ := json.Marshal(SubmitTxRequest{
+		From:   "0101010101010101010101010101010101010101010101010101010101010101",
+		To:     "0202020202020202020202020202020202020202020202020202020202020202",
+		Amount: 10,
+	})
+	resp, err := http.Post(ts.URL+"/tx", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("submit tx: %v", err)
+	}
+	resp.Body.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && remotePool.Size() == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if remotePool.Size() != 1 {
+		t.Fatal("submitted tx did not reach the peer's mempool")
+	}
+}
+
+func TestGetTxStatus(t *testing.T) {
+	server, chainMgr, stateMgr, pool := newTestServer(t)
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+
+	failed := types.Transaction{From: types.Address{1}, To: types.Address{2}, Amount: 5, Timestamp: time.Unix(1, 0)}
+	failed.Hash = failed.CalculateHash()
+	block := &types.Block{Header: types.BlockHeader{Height: 1}, Transactions: []types.Transaction{failed}}
+	block.Header.TxRoot = block.CalculateTxRoot()
+	receipts, err := stateMgr.ExecuteBlock(*block)
+	if err != nil {
+		t.Fatalf("execute block: %v", err)
+	}
+	if err := chainMgr.AddBlockWithReceipts(block, receipts); err != nil {
+		t.Fatalf("add block: %v", err)
+	}
+	pending := types.Transaction{From: types.Address{3}, Amount: 1, Timestamp: time.Unix(2, 0)}
+	pool.Add(pending)
+
+	for hash, want := range map[string]string{
+		failed.Hash.String():             TxStatusFailed,
+		pending.CalculateHash().String(): TxStatusPending,
+		types.Hash{0xee}.String():        TxStatusUnknown,
+	} {
+		resp, err := http.Get(ts.URL + "/tx/" + hash)
+		if err != nil {
+			t.Fatalf("get tx: %v", err)
+		}
+		var out TxResponse
+		json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if out.Status != want {
+			t.Fatalf("tx %s: expected %s, got %+v", hash, want, out)
+		}
+		if want == TxStatusFailed && (out.Receipt == nil || out.Receipt.Error == "" || out.BlockHeight != 1) {
+			t.Fatalf("expected a failure receipt, got %+v", out)
+		}
+		if want == TxStatusUnknown && resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("expected 404 for an unknown tx, got %d", resp.StatusCode)
+		}
+	}
+}
+
+func TestGetBlockByHashAndRange(t *testing.T) {
+	server, chainMgr, _, _ := newTestServer(t)
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+
+	var prev types.Hash
+	for h := uint64(1); h <= 5; h++ {
+		block := &types.Block{Header: types.BlockHeader{Height: h, PreviousHash: prev, Timestamp: time.Unix(int64(h), 0)}}
+		block.Header.TxRoot = block.CalculateTxRoot()
+		if err := chainMgr.AddBlock(block); err != nil {
+			t.Fatalf("add block %d: %v", h, err)
+		}
+		prev = block.Header.Hash()
+	}
+
+	resp, err := http.Get(ts.URL + "/block/hash/" + prev.String())
+	if err != nil {
+		t.Fatalf("get block by hash: %v", err)
+	}
+	var block BlockResponse
+	json.NewDecoder(resp.Body).Decode(&block)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || block.Header.Height != 5 {
+		t.Fatalf("unexpected block by hash: %d %+v", resp.StatusCode, block.Header)
+	}
+
+	var heights []uint64
+	url := ts.URL + "/blocks?from=2&limit=2&headers_only=true"
+	for pages := 0; url != ""; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination did not terminate")
+		}
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("get blocks: %v", err)
+		}
+		var page BlocksResponse
+		json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if page.Blocks != nil {
+			t.Fatal("expected headers only")
+		}
+		for _, h := range page.Headers {
+			heights = append(heights, h.Height)
+		}
+		url = ""
+		if page.NextCursor != "" {
+			url = ts.URL + "/blocks?limit=2&headers_only=true&cursor=" + page.NextCursor
+		}
+	}
+	if len(heights) != 4 || heights[0] != 2 || heights[3] != 5 {
+		t.Fatalf("unexpected heights: %v", heights)
+	}
+
+	resp, err = http.Get(ts.URL + "/blocks?from=1&to=2")
+	if err != nil {
+		t.Fatalf("get blocks: %v", err)
+	}
+	var full BlocksResponse
+	json.NewDecoder(resp.Body).Decode(&full)
+	resp.Body.Close()
+	if len(full.Blocks) != 2 || full.NextCursor != "" {
+		t.Fatalf("unexpected full page: %+v", full)
+	}
+
+	for query, want := range map[string]string{
+		"from=9":                   `{"blocks":[]}`,
+		"from=9&headers_only=true": `{"headers":[]}`,
+	} {
+		resp, err := http.Get(ts.URL + "/blocks?" + query)
+		if err != nil {
+			t.Fatalf("get blocks: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if strings.TrimSpace(string(body)) != want {
+			t.Fatalf("%s: expected %s, got %s", query, want, body)
+		}
+	}
+}
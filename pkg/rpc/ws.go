@@ -0,0 +1,299 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/0xphantomotr/gchain/pkg/events"
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+// Subscription kinds accepted by the "subscribe" method on /ws.
+const (
+	SubNewHeads               = "newHeads"
+	SubNewPendingTransactions = "newPendingTransactions"
+	SubTxInclusion            = "txInclusion"
+	SubBalance                = "balance"
+)
+
+const maxWSSubscriptions = 64
+
+type HeadResponse struct {
+	Height uint64            `json:"height"`
+	Hash   string            `json:"hash"`
+	Header types.BlockHeader `json:"header"`
+}
+
+type wsNotification struct {
+	JSONRPC string        `json:"jsonrpc"`
+	Method  string        `json:"method"`
+	Params  wsSubResponse `json:"params"`
+}
+
+type wsSubResponse struct {
+	Subscription string      `json:"subscription"`
+	Result       interface{} `json:"result"`
+}
+
+type wsSubscription struct {
+	kind    string
+	hash    types.Hash
+	addr    types.Address
+	balance uint64
+}
+
+// wsConn is one WebSocket client and its subscriptions.
+type wsConn struct {
+	srv  *Server
+	conn *websocket.Conn
+
+	sendMu sync.Mutex
+
+	mu     sync.Mutex
+	subs   map[string]*wsSubscription
+	nextID uint64
+}
+
+// SetEventBus enables the /ws endpoint, fed by events published on bus.
+func (s *Server) SetEventBus(bus *events.Bus) {
+	s.events = bus
+}
+
+// SetWSOrigins lists origins besides the server's own host that may open
+// /ws; "*" allows any origin.
+func (s *Server) SetWSOrigins(origins []string) {
+	s.wsOrigins = origins
+}
+
+// handleWS upgrades to a WebSocket speaking JSON-RPC 2.0. Besides the
+// regular methods it serves "subscribe" (params [kind] or [kind, hash|addr])
+// and "unsubscribe" ([id]); matching events arrive as "subscription"
+// notifications.
+func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		writeJSON(w, http.StatusNotImplemented, errorPayload{Error: "subscriptions are not enabled"})
+		return
+	}
+	websocket.Server{Handshake: s.checkWSOrigin, Handler: s.serveWS}.ServeHTTP(w, r)
+}
+
+// checkWSOrigin stops other sites from driving the endpoint from a browser:
+// the Origin header, when present, must name this host or an allowed origin.
+func (s *Server) checkWSOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	for _, allowed := range s.wsOrigins {
+		if allowed == "*" || allowed == origin {
+			return nil
+		}
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != r.Host {
+		return fmt.Errorf("origin %q not allowed", origin)
+	}
+	return nil
+}
+
+func (s *Server) serveWS(conn *websocket.Conn) {
+	defer conn.Close()
+	sub := s.events.Subscribe(0)
+	defer sub.Unsubscribe()
+
+	c := &wsConn{srv: s, conn: conn, subs: make(map[string]*wsSubscription)}
+	go c.pump(sub)
+
+	ctx := conn.Request().Context()
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(conn, &data); err != nil {
+			return
+		}
+		resp, followUp := c.handle(ctx, data)
+		if resp != nil {
+			if err := c.send(resp); err != nil {
+				return
+			}
+		}
+		for _, n := range followUp {
+			if err := c.send(n); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsConn) send(v interface{}) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	return websocket.JSON.Send(c.conn, v)
+}
+
+// handle serves one request and returns its reply along with notifications
+// to send right after it.
+func (c *wsConn) handle(ctx context.Context, data []byte) (*jsonrpcResponse, []wsNotification) {
+	var req jsonrpcRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return errorReply(nil, &Error{Code: CodeParseError, Message: err.Error()}), nil
+	}
+	var (
+		result   interface{}
+		followUp []wsNotification
+		err      error
+	)
+	switch req.Method {
+	case "subscribe":
+		result, followUp, err = c.subscribe(req.Params)
+	case "unsubscribe":
+		result, err = c.unsubscribe(req.Params)
+	default:
+		return c.srv.callJSONRPC(ctx, data), nil
+	}
+	if req.ID == nil {
+		return nil, followUp
+	}
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: CodeServerError, Message: err.Error()}
+		}
+		return errorReply(req.ID, rpcErr), nil
+	}
+	return &jsonrpcResponse{JSONRPC: jsonrpcVersion, ID: req.ID, Result: result}, followUp
+}
+
+// subscribe registers a subscription. A txInclusion subscription for a
+// transaction that is already in a block is answered at once by the
+// returned notification instead of being kept.
+func (c *wsConn) subscribe(params json.RawMessage) (interface{}, []wsNotification, error) {
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 {
+		return nil, nil, InvalidParams(errors.New("params must be [kind] or [kind, target]"))
+	}
+	sub := &wsSubscription{kind: args[0]}
+	switch sub.kind {
+	case SubNewHeads, SubNewPendingTransactions:
+		if len(args) != 1 {
+			return nil, nil, InvalidParams(fmt.Errorf("%s takes no target", sub.kind))
+		}
+	case SubTxInclusion:
+		if len(args) != 2 {
+			return nil, nil, InvalidParams(errors.New("txInclusion needs a transaction hash"))
+		}
+		hash, err := parseHash(args[1])
+		if err != nil {
+			return nil, nil, InvalidParams(err)
+		}
+		sub.hash = hash
+	case SubBalance:
+		if len(args) != 2 {
+			return nil, nil, InvalidParams(errors.New("balance needs an address"))
+		}
+		addr, err := parseAddress(args[1])
+		if err != nil {
+			return nil, nil, InvalidParams(err)
+		}
+		account, err := c.srv.state.GetAccount(addr)
+		if err != nil {
+			return nil, nil, err
+		}
+		sub.addr, sub.balance = addr, account.Balance
+	default:
+		return nil, nil, InvalidParams(fmt.Errorf("unknown subscription %q", sub.kind))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.subs) >= maxWSSubscriptions {
+		return nil, nil, &Error{Code: CodeServerError, Message: "too many subscriptions"}
+	}
+	c.nextID++
+	id := "0x" + strconv.FormatUint(c.nextID, 16)
+	// Look the transaction up under c.mu so a block event matched by pump
+	// cannot report it a second time.
+	if sub.kind == SubTxInclusion {
+		resp, err := c.srv.lookupTx(sub.hash)
+		if err == nil && (resp.Status == TxStatusIncluded || resp.Status == TxStatusFailed) {
+			return id, []wsNotification{newWSNotification(id, resp)}, nil
+		}
+	}
+	c.subs[id] = sub
+	return id, nil, nil
+}
+
+func (c *wsConn) unsubscribe(params json.RawMessage) (interface{}, error) {
+	var id string
+	if err := decodeParams(params, &id); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.subs[id]
+	delete(c.subs, id)
+	return ok, nil
+}
+
+// pump turns bus events into notifications until the subscription ends.
+func (c *wsConn) pump(sub *events.Subscription) {
+	for ev := range sub.Events() {
+		for _, n := range c.match(ev) {
+			if err := c.send(n); err != nil {
+				return
+			}
+		}
+	}
+	if err := sub.Err(); err != nil {
+		log.Printf("rpc: closing websocket %s: %v", c.conn.Request().RemoteAddr, err)
+		c.conn.Close()
+	}
+}
+
+func newWSNotification(id string, result interface{}) wsNotification {
+	return wsNotification{JSONRPC: jsonrpcVersion, Method: "subscription", Params: wsSubResponse{Subscription: id, Result: result}}
+}
+
+func (c *wsConn) match(ev events.Event) []wsNotification {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []wsNotification
+	notify := func(id string, result interface{}) {
+		out = append(out, newWSNotification(id, result))
+	}
+	for id, sub := range c.subs {
+		switch {
+		case ev.Type == events.EventNewTx && sub.kind == SubNewPendingTransactions:
+			notify(id, TxResponse{Hash: ev.Tx.Hash.String(), Status: TxStatusPending, Transaction: ev.Tx})
+		case ev.Type == events.EventNewBlock && sub.kind == SubNewHeads:
+			notify(id, HeadResponse{Height: ev.Block.Header.Height, Hash: ev.Block.Header.Hash().String(), Header: ev.Block.Header})
+		case ev.Type == events.EventNewBlock && sub.kind == SubTxInclusion:
+			for _, tx := range ev.Block.Transactions {
+				if tx.ID() != sub.hash {
+					continue
+				}
+				if resp, err := c.srv.lookupTx(sub.hash); err == nil {
+					notify(id, resp)
+				}
+				delete(c.subs, id)
+				break
+			}
+		case ev.Type == events.EventNewBlock && sub.kind == SubBalance:
+			account, err := c.srv.state.GetAccount(sub.addr)
+			if err != nil || account.Balance == sub.balance {
+				continue
+			}
+			sub.balance = account.Balance
+			notify(id, AccountResponse{Address: sub.addr.String(), Balance: account.Balance, Nonce: account.Nonce})
+		}
+	}
+	return out
+}
@@ -0,0 +1,207 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/0xphantomotr/gchain/pkg/chain"
+	"github.com/0xphantomotr/gchain/pkg/events"
+	"github.com/0xphantomotr/gchain/pkg/mempool"
+	"github.com/0xphantomotr/gchain/pkg/state"
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+type wsFrame struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+	Params struct {
+		Subscription string          `json:"subscription"`
+		Result       json.RawMessage `json:"result"`
+	} `json:"params"`
+}
+
+func TestWebSocketSubscriptions(t *testing.T) {
+	chainMgr, err := chain.NewManager(chain.NewMemoryStore())
+	if err != nil {
+		t.Fatalf("new chain manager: %v", err)
+	}
+	from, to := types.Address{1}, types.Address{2}
+	stateMgr := state.NewManager(state.NewMemoryStore())
+	if err := stateMgr.SeedAccount(from, 100, 0); err != nil {
+		t.Fatalf("seed account: %v", err)
+	}
+	bus := events.NewBus()
+	pool := mempool.New(10, nil)
+	pool.SetEventBus(bus)
+
+	server := NewServer(chainMgr, stateMgr, pool, nil, ":0")
+	server.SetEventBus(bus)
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", "", ts.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	call := func(id int, method string, params ...string) string {
+		t.Helper()
+		raw, _ := json.Marshal(params)
+		req, _ := json.Marshal(jsonrpcRequest{JSONRPC: "2.0", ID: json.RawMessage(strconv.Itoa(id)), Method: method, Params: raw})
+		if err := websocket.Message.Send(conn, string(req)); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		var frame wsFrame
+		if err := websocket.JSON.Receive(conn, &frame); err != nil || frame.Error != nil {
+			t.Fatalf("%s: %v %+v", method, err, frame.Error)
+		}
+		var subID string
+		json.Unmarshal(frame.Result, &subID)
+		return subID
+	}
+	pending := call(1, "subscribe", SubNewPendingTransactions)
+	heads := call(2, "subscribe", SubNewHeads)
+	balance := call(3, "subscribe", SubBalance, to.String())
+
+	tx := types.Transaction{From: from, To: to, Amount: 7, Timestamp: time.Unix(1, 0)}
+	tx.Hash = tx.CalculateHash()
+	inclusion := call(4, "subscribe", SubTxInclusion, tx.Hash.String())
+	if err := pool.Add(tx); err != nil {
+		t.Fatalf("add tx: %v", err)
+	}
+
+	block := &types.Block{Header: types.BlockHeader{Height: 1, Timestamp: time.Unix(2, 0)}, Transactions: []types.Transaction{tx}}
+	block.Header.TxRoot = block.CalculateTxRoot()
+	receipts, err := stateMgr.ExecuteBlock(*block)
+	if err != nil {
+		t.Fatalf("execute block: %v", err)
+	}
+	if err := chainMgr.AddBlockWithReceipts(block, receipts); err != nil {
+		t.Fatalf("add block: %v", err)
+	}
+	pool.Remove(tx.Hash)
+	bus.PublishBlock(block)
+
+	got := make(map[string]json.RawMessage)
+	for len(got) < 4 {
+		var frame wsFrame
+		if err := websocket.JSON.Receive(conn, &frame); err != nil {
+			t.Fatalf("receive notification (have %d): %v", len(got), err)
+		}
+		if frame.Method != "subscription" {
+			t.Fatalf("unexpected frame: %+v", frame)
+		}
+		got[frame.Params.Subscription] = frame.Params.Result
+	}
+
+	var pendingTx, included TxResponse
+	json.Unmarshal(got[pending], &pendingTx)
+	json.Unmarshal(got[inclusion], &included)
+	if pendingTx.Hash != tx.Hash.String() || included.Status != TxStatusIncluded || included.Receipt == nil || included.BlockHeight != 1 {
+		t.Fatalf("unexpected tx notifications: %+v %+v", pendingTx, included)
+	}
+	var head HeadResponse
+	json.Unmarshal(got[heads], &head)
+	if head.Height != 1 || head.Hash != block.Header.Hash().String() {
+		t.Fatalf("unexpected head: %+v", head)
+	}
+	var account AccountResponse
+	json.Unmarshal(got[balance], &account)
+	if account.Balance != 7 {
+		t.Fatalf("expected balance 7, got %+v", account)
+	}
+}
+
+func TestWebSocketNeedsEventBus(t *testing.T) {
+	server, _, _, _ := newTestServer(t)
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/ws")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("expected 501 without an event bus, got %d", resp.StatusCode)
+	}
+}
+
+func TestWebSocketChecksOrigin(t *testing.T) {
+	server, _, _, _ := newTestServer(t)
+	server.SetEventBus(events.NewBus())
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+
+	if _, err := websocket.Dial(url, "", "http://evil.example"); err == nil {
+		t.Fatal("expected a foreign origin to be rejected")
+	}
+	server.SetWSOrigins([]string{"http://wallet.example"})
+	for _, origin := range []string{ts.URL, "http://wallet.example"} {
+		conn, err := websocket.Dial(url, "", origin)
+		if err != nil {
+			t.Fatalf("dial from %s: %v", origin, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestWebSocketTxInclusionForCommittedTx(t *testing.T) {
+	server, chainMgr, stateMgr, _ := newTestServer(t)
+	server.SetEventBus(events.NewBus())
+	ts := httptest.NewServer(server.httpServer.Handler)
+	defer ts.Close()
+
+	// The transaction leaves Hash unset, so it is known by its ID.
+	tx := types.Transaction{From: types.Address{1}, To: types.Address{2}, Timestamp: time.Unix(1, 0)}
+	block := &types.Block{Header: types.BlockHeader{Height: 1, Timestamp: time.Unix(2, 0)}, Transactions: []types.Transaction{tx}}
+	block.Header.TxRoot = block.CalculateTxRoot()
+	receipts, err := stateMgr.ExecuteBlock(*block)
+	if err != nil {
+		t.Fatalf("execute block: %v", err)
+	}
+	if err := chainMgr.AddBlockWithReceipts(block, receipts); err != nil {
+		t.Fatalf("add block: %v", err)
+	}
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", "", ts.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	params, _ := json.Marshal([]string{SubTxInclusion, tx.ID().String()})
+	req, _ := json.Marshal(jsonrpcRequest{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: "subscribe", Params: params})
+	if err := websocket.Message.Send(conn, string(req)); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	var reply wsFrame
+	if err := websocket.JSON.Receive(conn, &reply); err != nil || reply.Error != nil {
+		t.Fatalf("subscribe: %v %+v", err, reply.Error)
+	}
+	var subID string
+	json.Unmarshal(reply.Result, &subID)
+
+	var frame wsFrame
+	if err := websocket.JSON.Receive(conn, &frame); err != nil {
+		t.Fatalf("receive notification: %v", err)
+	}
+	var included TxResponse
+	json.Unmarshal(frame.Params.Result, &included)
+	if frame.Params.Subscription != subID || included.Status != TxStatusIncluded || included.BlockHeight != 1 {
+		t.Fatalf("unexpected notification: %+v %+v", frame, included)
+	}
+}
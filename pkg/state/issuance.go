@@ -0,0 +1,198 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+var supplyKey = []byte("supply")
+
+// IssuancePolicy decides how many new tokens are minted for a block. It must
+// be a pure function of its inputs so every node mints the same amount.
+type IssuancePolicy interface {
+	BlockReward(height uint64, supply uint64) uint64
+}
+
+// FixedReward mints the same amount for every block.
+type FixedReward struct {
+	Amount uint64
+}
+
+func (f FixedReward) BlockReward(height uint64, supply uint64) uint64 {
+	return f.Amount
+}
+
+// HalvingReward starts at Initial and halves every Interval blocks.
+type HalvingReward struct {
+	Initial  uint64
+	Interval uint64
+}
+
+func (h HalvingReward) BlockReward(height uint64, supply uint64) uint64 {
+	if h.Interval == 0 || height == 0 {
+		return h.Initial
+	}
+	halvings := (height - 1) / h.Interval
+	if halvings >= 64 {
+		return 0
+	}
+	return h.Initial >> halvings
+}
+
+// InflationReward grows the supply by RateBps basis points per year, spread
+// evenly over BlocksPerYear blocks.
+type InflationReward struct {
+	RateBps       uint64
+	BlocksPerYear uint64
+}
+
+func (i InflationReward) BlockReward(height uint64, supply uint64) uint64 {
+	if i.BlocksPerYear == 0 {
+		return 0
+	}
+	reward := new(big.Int).SetUint64(supply)
+	reward.Mul(reward, new(big.Int).SetUint64(i.RateBps))
+	reward.Div(reward, new(big.Int).SetUint64(10_000*i.BlocksPerYear))
+	if !reward.IsUint64() {
+		return 0
+	}
+	return reward.Uint64()
+}
+
+type RewardParams struct {
+	Policy IssuancePolicy
+	// VoterShareBps is the share of each block reward, in basis points, split
+	// among the header's LastVoters pro rata by bonded stake, or evenly when
+	// none of them is bonded. The proposer keeps the rest, and all of it when
+	// the header names no voters.
+	VoterShareBps uint64
+}
+
+func (m *Manager) SetRewardParams(params RewardParams) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rewardParams = params
+}
+
+// TotalSupply returns the number of tokens in existence: genesis allocations
+// plus everything minted as block rewards.
+func (m *Manager) TotalSupply() (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.supplyLocked()
+}
+
+func (m *Manager) supplyLocked() (uint64, error) {
+	if m.supply != nil {
+		return *m.supply, nil
+	}
+	var supply uint64
+	data, err := m.store.Get(supplyKey)
+	switch {
+	case errors.Is(err, ErrNotFound):
+	case err != nil:
+		return 0, fmt.Errorf("get supply: %w", err)
+	default:
+		if err := json.Unmarshal(data, &supply); err != nil {
+			return 0, fmt.Errorf("decode supply: %w", err)
+		}
+	}
+	m.supply = &supply
+	return supply, nil
+}
+
+// adjustSupplyLocked moves the total supply by the difference between after
+// and before, which keeps the arithmetic in uint64.
+func (m *Manager) adjustSupplyLocked(before, after uint64) error {
+	supply, err := m.supplyLocked()
+	if err != nil {
+		return err
+	}
+	supply = supply - before + after
+	m.supply = &supply
+	return nil
+}
+
+func (m *Manager) mintBlockRewardLocked(header types.BlockHeader) error {
+	if m.rewardParams.Policy == nil {
+		return nil
+	}
+	supply, err := m.supplyLocked()
+	if err != nil {
+		return err
+	}
+	reward := m.rewardParams.Policy.BlockReward(header.Height, supply)
+	if reward == 0 {
+		return nil
+	}
+
+	proposerShare := reward
+	if share := min(m.rewardParams.VoterShareBps, 10_000); share > 0 && len(header.LastVoters) > 0 {
+		cuts, err := m.voterCutsLocked(header.LastVoters, mulDiv(reward, share, 10_000))
+		if err != nil {
+			return err
+		}
+		for i, voter := range header.LastVoters {
+			m.getOrCreate(voter).Balance += cuts[i]
+			proposerShare -= cuts[i]
+		}
+	}
+	m.getOrCreate(header.Proposer).Balance += proposerShare
+
+	return m.adjustSupplyLocked(0, reward)
+}
+
+// voterCutsLocked splits pool among voters by bonded stake, evenly when none
+// of them is bonded. Rounding leftovers stay with the proposer.
+func (m *Manager) voterCutsLocked(voters []types.Address, pool uint64) ([]uint64, error) {
+	st, err := m.stakingLocked()
+	if err != nil {
+		return nil, err
+	}
+	stakes := make(map[types.Address]uint64)
+	for _, v := range st.validators(m.stakingParams.MinSelfBond) {
+		stakes[v.Address] = v.Stake
+	}
+	weights := make([]uint64, len(voters))
+	var total uint64
+	for i, voter := range voters {
+		weights[i] = stakes[voter]
+		total += weights[i]
+	}
+	if total == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+		total = uint64(len(voters))
+	}
+	cuts := make([]uint64, len(voters))
+	for i, weight := range weights {
+		cuts[i] = mulDiv(pool, weight, total)
+	}
+	return cuts, nil
+}
+
+func (m *Manager) commitSupplyLocked() error {
+	if m.supply == nil {
+		return nil
+	}
+	payload, err := json.Marshal(*m.supply)
+	if err != nil {
+		return fmt.Errorf("marshal supply: %w", err)
+	}
+	if err := m.store.Set(supplyKey, payload); err != nil {
+		return fmt.Errorf("persist supply: %w", err)
+	}
+	return nil
+}
+
+func mulDiv(a, b, c uint64) uint64 {
+	out := new(big.Int).SetUint64(a)
+	out.Mul(out, new(big.Int).SetUint64(b))
+	out.Div(out, new(big.Int).SetUint64(c))
+	return out.Uint64()
+}
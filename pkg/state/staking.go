@@ -0,0 +1,298 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+var (
+	ErrUnknownValidator  = errors.New("state: unknown validator")
+	ErrInsufficientStake = errors.New("state: insufficient stake")
+	ErrSelfBondTooLow    = errors.New("state: self bond below minimum")
+	ErrUnknownTxType     = errors.New("state: unknown transaction type")
+)
+
+var stakingKey = []byte("staking")
+
+type StakingParams struct {
+	// UnbondingPeriod is the number of blocks unbonded tokens stay locked
+	// before they are returned to the delegator's balance.
+	UnbondingPeriod uint64
+	// MinSelfBond is the smallest self bond that registers a validator.
+	MinSelfBond uint64
+}
+
+func DefaultStakingParams() StakingParams {
+	return StakingParams{
+		UnbondingPeriod: 100,
+		MinSelfBond:     1,
+	}
+}
+
+type Validator struct {
+	Address  types.Address `json:"address"`
+	SelfBond uint64        `json:"self_bond"`
+	Stake    uint64        `json:"stake"`
+}
+
+type Delegation struct {
+	Delegator types.Address `json:"delegator"`
+	Validator types.Address `json:"validator"`
+	Amount    uint64        `json:"amount"`
+}
+
+type UnbondingEntry struct {
+	Delegator        types.Address `json:"delegator"`
+	Validator        types.Address `json:"validator"`
+	Amount           uint64        `json:"amount"`
+	CompletionHeight uint64        `json:"completion_height"`
+}
+
+// stakingState is persisted as a single record; the store has no iteration
+// so keeping delegations in one place is what lets us rebuild the validator
+// set after a restart.
+type stakingState struct {
+	Delegations []Delegation     `json:"delegations"`
+	Unbonding   []UnbondingEntry `json:"unbonding"`
+}
+
+func (s *stakingState) clone() *stakingState {
+	dup := &stakingState{
+		Delegations: make([]Delegation, len(s.Delegations)),
+		Unbonding:   make([]UnbondingEntry, len(s.Unbonding)),
+	}
+	copy(dup.Delegations, s.Delegations)
+	copy(dup.Unbonding, s.Unbonding)
+	return dup
+}
+
+func (s *stakingState) delegation(delegator, validator types.Address) int {
+	for i, d := range s.Delegations {
+		if d.Delegator == delegator && d.Validator == validator {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *stakingState) selfBond(validator types.Address) uint64 {
+	if i := s.delegation(validator, validator); i >= 0 {
+		return s.Delegations[i].Amount
+	}
+	return 0
+}
+
+func (s *stakingState) bond(delegator, validator types.Address, amount uint64) {
+	if i := s.delegation(delegator, validator); i >= 0 {
+		s.Delegations[i].Amount += amount
+		return
+	}
+	s.Delegations = append(s.Delegations, Delegation{Delegator: delegator, Validator: validator, Amount: amount})
+}
+
+func (s *stakingState) unbond(delegator, validator types.Address, amount uint64) error {
+	i := s.delegation(delegator, validator)
+	if i < 0 || s.Delegations[i].Amount < amount {
+		return ErrInsufficientStake
+	}
+	s.Delegations[i].Amount -= amount
+	if s.Delegations[i].Amount == 0 {
+		s.Delegations = append(s.Delegations[:i], s.Delegations[i+1:]...)
+	}
+	return nil
+}
+
+func (s *stakingState) validators(minSelfBond uint64) []Validator {
+	byAddr := make(map[types.Address]*Validator)
+	var order []types.Address
+	for _, d := range s.Delegations {
+		v, ok := byAddr[d.Validator]
+		if !ok {
+			v = &Validator{Address: d.Validator}
+			byAddr[d.Validator] = v
+			order = append(order, d.Validator)
+		}
+		v.Stake += d.Amount
+		if d.Delegator == d.Validator {
+			v.SelfBond = d.Amount
+		}
+	}
+
+	out := make([]Validator, 0, len(order))
+	for _, addr := range order {
+		if v := byAddr[addr]; v.SelfBond > 0 && v.SelfBond >= minSelfBond {
+			out = append(out, *v)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Stake != out[j].Stake {
+			return out[i].Stake > out[j].Stake
+		}
+		return bytes.Compare(out[i].Address[:], out[j].Address[:]) < 0
+	})
+	return out
+}
+
+func (m *Manager) SetStakingParams(params StakingParams) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stakingParams = params
+}
+
+// ActiveValidators returns up to max validators ordered by total stake,
+// highest first. A max of zero returns every registered validator.
+func (m *Manager) ActiveValidators(max int) ([]Validator, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, err := m.stakingLocked()
+	if err != nil {
+		return nil, err
+	}
+	vals := st.validators(m.stakingParams.MinSelfBond)
+	if max > 0 && len(vals) > max {
+		vals = vals[:max]
+	}
+	return vals, nil
+}
+
+// Delegations returns every delegation bonded by delegator.
+func (m *Manager) Delegations(delegator types.Address) ([]Delegation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, err := m.stakingLocked()
+	if err != nil {
+		return nil, err
+	}
+	var out []Delegation
+	for _, d := range st.Delegations {
+		if d.Delegator == delegator {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// Unbonding returns the pending unbonding entries owned by delegator.
+func (m *Manager) Unbonding(delegator types.Address) ([]UnbondingEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, err := m.stakingLocked()
+	if err != nil {
+		return nil, err
+	}
+	var out []UnbondingEntry
+	for _, u := range st.Unbonding {
+		if u.Delegator == delegator {
+			out = append(out, u)
+		}
+	}
+	return out, nil
+}
+
+func (m *Manager) stakingLocked() (*stakingState, error) {
+	if m.staking != nil {
+		return m.staking, nil
+	}
+	st := &stakingState{}
+	data, err := m.store.Get(stakingKey)
+	switch {
+	case errors.Is(err, ErrNotFound):
+	case err != nil:
+		return nil, fmt.Errorf("get staking state: %w", err)
+	default:
+		if err := json.Unmarshal(data, st); err != nil {
+			return nil, fmt.Errorf("decode staking state: %w", err)
+		}
+	}
+	m.staking = st
+	return st, nil
+}
+
+func (m *Manager) applyBondLocked(tx types.Transaction) error {
+	st, err := m.stakingLocked()
+	if err != nil {
+		return err
+	}
+	sender := m.getOrCreate(tx.From)
+	if sender.Nonce != tx.Nonce {
+		return ErrNonceMismatch
+	}
+	if sender.Balance < tx.Amount {
+		return ErrInsufficientFunds
+	}
+	if tx.From == tx.To {
+		if st.selfBond(tx.To)+tx.Amount < m.stakingParams.MinSelfBond {
+			return ErrSelfBondTooLow
+		}
+	} else if st.selfBond(tx.To) == 0 {
+		return ErrUnknownValidator
+	}
+
+	sender.Balance -= tx.Amount
+	sender.Nonce++
+	st.bond(tx.From, tx.To, tx.Amount)
+	return nil
+}
+
+func (m *Manager) applyUnbondLocked(tx types.Transaction, height uint64) error {
+	st, err := m.stakingLocked()
+	if err != nil {
+		return err
+	}
+	sender := m.getOrCreate(tx.From)
+	if sender.Nonce != tx.Nonce {
+		return ErrNonceMismatch
+	}
+	if err := st.unbond(tx.From, tx.To, tx.Amount); err != nil {
+		return err
+	}
+
+	sender.Nonce++
+	st.Unbonding = append(st.Unbonding, UnbondingEntry{
+		Delegator:        tx.From,
+		Validator:        tx.To,
+		Amount:           tx.Amount,
+		CompletionHeight: height + m.stakingParams.UnbondingPeriod,
+	})
+	return nil
+}
+
+// releaseUnbondedLocked returns matured unbonding entries to their owners.
+func (m *Manager) releaseUnbondedLocked(height uint64) error {
+	st, err := m.stakingLocked()
+	if err != nil {
+		return err
+	}
+	pending := st.Unbonding[:0]
+	for _, u := range st.Unbonding {
+		if u.CompletionHeight > height {
+			pending = append(pending, u)
+			continue
+		}
+		m.getOrCreate(u.Delegator).Balance += u.Amount
+	}
+	st.Unbonding = pending
+	return nil
+}
+
+func (m *Manager) commitStakingLocked() error {
+	if m.staking == nil {
+		return nil
+	}
+	payload, err := json.Marshal(m.staking)
+	if err != nil {
+		return fmt.Errorf("marshal staking state: %w", err)
+	}
+	if err := m.store.Set(stakingKey, payload); err != nil {
+		return fmt.Errorf("persist staking state: %w", err)
+	}
+	return nil
+}
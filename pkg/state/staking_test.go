@@ -0,0 +1,101 @@
+package state
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/0xphantomotr/gchain/pkg/types"
+)
+
+func TestBondDelegateAndUnbond(t *testing.T) {
+	mgr := NewManager(NewMemoryStore())
+	mgr.SetStakingParams(StakingParams{UnbondingPeriod: 2, MinSelfBond: 10})
+
+	validator := types.Address{1}
+	delegator := types.Address{2}
+	mgr.cache[validator] = &Account{Address: validator, Balance: 100}
+	mgr.cache[delegator] = &Account{Address: delegator, Balance: 50}
+
+	block := types.Block{
+		Header: types.BlockHeader{Height: 1},
+		Transactions: []types.Transaction{
+			{Type: types.TxTypeBond, From: validator, To: validator, Amount: 60, Timestamp: time.Unix(0, 1)},
+			{Type: types.TxTypeBond, From: delegator, To: validator, Amount: 30, Timestamp: time.Unix(0, 2)},
+		},
+	}
+	if err := mgr.ApplyBlock(block); err != nil {
+		t.Fatalf("apply bond block: %v", err)
+	}
+
+	vals, err := mgr.ActiveValidators(0)
+	if err != nil {
+		t.Fatalf("active validators: %v", err)
+	}
+	if len(vals) != 1 || vals[0].Stake != 90 || vals[0].SelfBond != 60 {
+		t.Fatalf("unexpected validator set %+v", vals)
+	}
+	if acct, _ := mgr.GetAccount(delegator); acct.Balance != 20 {
+		t.Fatalf("expected delegator balance 20, got %d", acct.Balance)
+	}
+
+	unbond := types.Block{
+		Header: types.BlockHeader{Height: 2},
+		Transactions: []types.Transaction{
+			{Type: types.TxTypeUnbond, From: delegator, To: validator, Amount: 30, Nonce: 1, Timestamp: time.Unix(0, 3)},
+		},
+	}
+	if err := mgr.ApplyBlock(unbond); err != nil {
+		t.Fatalf("apply unbond block: %v", err)
+	}
+	if acct, _ := mgr.GetAccount(delegator); acct.Balance != 20 {
+		t.Fatalf("tokens released before unbonding period, balance %d", acct.Balance)
+	}
+
+	for h := uint64(3); h <= 4; h++ {
+		if err := mgr.ApplyBlock(types.Block{Header: types.BlockHeader{Height: h}}); err != nil {
+			t.Fatalf("apply empty block %d: %v", h, err)
+		}
+	}
+	if acct, _ := mgr.GetAccount(delegator); acct.Balance != 50 {
+		t.Fatalf("expected unbonded tokens returned, balance %d", acct.Balance)
+	}
+}
+
+func TestDelegateToUnknownValidator(t *testing.T) {
+	mgr := NewManager(NewMemoryStore())
+
+	delegator := types.Address{2}
+	mgr.cache[delegator] = &Account{Address: delegator, Balance: 50}
+
+	tx := types.Transaction{Type: types.TxTypeBond, From: delegator, To: types.Address{9}, Amount: 10}
+	if err := mgr.ApplyTransaction(tx); !errors.Is(err, ErrUnknownValidator) {
+		t.Fatalf("expected unknown validator error, got %v", err)
+	}
+}
+
+func TestStakingStatePersists(t *testing.T) {
+	store := NewMemoryStore()
+	mgr := NewManager(store)
+
+	validator := types.Address{1}
+	mgr.cache[validator] = &Account{Address: validator, Balance: 100}
+	block := types.Block{
+		Header: types.BlockHeader{Height: 1},
+		Transactions: []types.Transaction{
+			{Type: types.TxTypeBond, From: validator, To: validator, Amount: 40},
+		},
+	}
+	if err := mgr.ApplyBlock(block); err != nil {
+		t.Fatalf("apply block: %v", err)
+	}
+
+	reloaded := NewManager(store)
+	vals, err := reloaded.ActiveValidators(0)
+	if err != nil {
+		t.Fatalf("active validators: %v", err)
+	}
+	if len(vals) != 1 || vals[0].Address != validator || vals[0].Stake != 40 {
+		t.Fatalf("unexpected validators after reload %+v", vals)
+	}
+}
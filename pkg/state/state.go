@@ -22,15 +22,21 @@ type Account struct {
 }
 
 type Manager struct {
-	mu    sync.RWMutex
-	store Store
-	cache map[types.Address]*Account
+	mu            sync.RWMutex
+	store         Store
+	cache         map[types.Address]*Account
+	staking       *stakingState
+	stakingParams StakingParams
+	supply        *uint64
+	rewardParams  RewardParams
+	height        uint64
 }
 
 func NewManager(store Store) *Manager {
 	return &Manager{
-		store: store,
-		cache: make(map[types.Address]*Account),
+		store:         store,
+		cache:         make(map[types.Address]*Account),
+		stakingParams: DefaultStakingParams(),
 	}
 }
 
@@ -80,27 +86,111 @@ func (m *Manager) GetAccount(addr types.Address) (*Account, error) {
 func (m *Manager) ApplyTransaction(tx types.Transaction) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	return m.applyTransactionLocked(tx)
+	return m.applyTransactionLocked(tx, m.height+1)
 }
 
+// ApplyBlock executes block and fails, leaving state untouched, if any of its
+// transactions fails.
 func (m *Manager) ApplyBlock(block types.Block) error {
+	_, err := m.executeBlock(block, true)
+	return err
+}
+
+// ExecuteBlock executes block, recording a failed transaction in its receipt
+// instead of rejecting the block. Failed transactions leave state untouched.
+func (m *Manager) ExecuteBlock(block types.Block) ([]types.Receipt, error) {
+	return m.executeBlock(block, false)
+}
+
+func (m *Manager) executeBlock(block types.Block, strict bool) ([]types.Receipt, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if _, err := m.stakingLocked(); err != nil {
+		return nil, err
+	}
+	supply, err := m.supplyLocked()
+	if err != nil {
+		return nil, err
+	}
 	snapshot := m.cloneCache()
-	for _, tx := range block.Transactions {
-		if err := m.applyTransactionLocked(tx); err != nil {
-			m.cache = snapshot
-			return fmt.Errorf("apply tx %s: %w", tx.Hash.String(), err)
+	stakingSnapshot := m.staking.clone()
+	rollback := func() {
+		m.cache = snapshot
+		m.staking = stakingSnapshot
+		m.supply = &supply
+	}
+
+	receipts := make([]types.Receipt, 0, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		receipt := types.Receipt{TxHash: tx.ID(), Height: block.Header.Height, Index: i, Success: true}
+		if err := m.applyTransactionLocked(tx, block.Header.Height); err != nil {
+			if strict {
+				rollback()
+				return nil, fmt.Errorf("apply tx %s: %w", tx.Hash.String(), err)
+			}
+			receipt.Success, receipt.Error = false, err.Error()
 		}
+		receipts = append(receipts, receipt)
 	}
+	if err := m.releaseUnbondedLocked(block.Header.Height); err != nil {
+		rollback()
+		return nil, err
+	}
+	if err := m.mintBlockRewardLocked(block.Header); err != nil {
+		rollback()
+		return nil, fmt.Errorf("mint block reward: %w", err)
+	}
+	m.height = block.Header.Height
 	if err := m.commitLocked(); err != nil {
-		return fmt.Errorf("commit block height=%d: %w", block.Header.Height, err)
+		return nil, fmt.Errorf("commit block height=%d: %w", block.Header.Height, err)
 	}
-	return nil
+	return receipts, nil
 }
 
-func (m *Manager) applyTransactionLocked(tx types.Transaction) error {
+// FilterExecutable dry-runs txs in order as if they were included in a block
+// at height and splits them into those that would succeed and those that
+// would fail. State is left untouched.
+func (m *Manager) FilterExecutable(txs []types.Transaction, height uint64) (ok, failed []types.Transaction, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.stakingLocked(); err != nil {
+		return nil, nil, err
+	}
+	supply, err := m.supplyLocked()
+	if err != nil {
+		return nil, nil, err
+	}
+	snapshot := m.cloneCache()
+	stakingSnapshot := m.staking.clone()
+	defer func() {
+		m.cache = snapshot
+		m.staking = stakingSnapshot
+		m.supply = &supply
+	}()
+
+	for _, tx := range txs {
+		if err := m.applyTransactionLocked(tx, height); err != nil {
+			failed = append(failed, tx)
+			continue
+		}
+		ok = append(ok, tx)
+	}
+	return ok, failed, nil
+}
+
+func (m *Manager) applyTransactionLocked(tx types.Transaction, height uint64) error {
+	switch tx.Type {
+	case types.TxTypeTransfer:
+	case types.TxTypeBond:
+		return m.applyBondLocked(tx)
+	case types.TxTypeUnbond:
+		return m.applyUnbondLocked(tx, height)
+	default:
+		return ErrUnknownTxType
+	}
+
 	sender := m.getOrCreate(tx.From)
 	receiver := m.getOrCreate(tx.To)
 
@@ -145,13 +235,24 @@ func (m *Manager) commitLocked() error {
 			return fmt.Errorf("persist account %s: %w", addr.String(), err)
 		}
 	}
-	return nil
+	if err := m.commitSupplyLocked(); err != nil {
+		return err
+	}
+	return m.commitStakingLocked()
 }
 
 func (m *Manager) SeedAccount(addr types.Address, balance uint64, nonce uint64) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	prev, err := m.loadAccountLocked(addr)
+	if err != nil {
+		return err
+	}
+	if err := m.adjustSupplyLocked(prev.Balance, balance); err != nil {
+		return err
+	}
+
 	acct := &Account{
 		Address: addr,
 		Balance: balance,
@@ -166,5 +267,23 @@ func (m *Manager) SeedAccount(addr types.Address, balance uint64, nonce uint64)
 	if err := m.store.Set(accountKey(addr), payload); err != nil {
 		return fmt.Errorf("persist account %s: %w", addr.String(), err)
 	}
-	return nil
+	return m.commitSupplyLocked()
+}
+
+func (m *Manager) loadAccountLocked(addr types.Address) (Account, error) {
+	if acc, ok := m.cache[addr]; ok {
+		return *acc, nil
+	}
+	data, err := m.store.Get(accountKey(addr))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return Account{Address: addr}, nil
+		}
+		return Account{}, fmt.Errorf("get account %s: %w", addr.String(), err)
+	}
+	var acct Account
+	if err := json.Unmarshal(data, &acct); err != nil {
+		return Account{}, fmt.Errorf("decode account %s: %w", addr.String(), err)
+	}
+	return acct, nil
 }
@@ -73,3 +73,146 @@ func TestApplyBlockRollbackOnFailure(t *testing.T) {
 		t.Fatalf("rollback failed, balance is %d", got.Balance)
 	}
 }
+
+func TestExecuteBlockRecordsFailedTx(t *testing.T) {
+	mgr := NewManager(NewMemoryStore())
+	sender, receiver := types.Address{1}, types.Address{2}
+	mgr.cache[sender] = &Account{Address: sender, Balance: 30}
+
+	block := types.Block{
+		Header: types.BlockHeader{Height: 1},
+		Transactions: []types.Transaction{
+			{From: sender, To: receiver, Amount: 40, Nonce: 0, Timestamp: time.Unix(0, 0)},
+			{From: sender, To: receiver, Amount: 10, Nonce: 0, Timestamp: time.Unix(1, 0)},
+		},
+	}
+	receipts, err := mgr.ExecuteBlock(block)
+	if err != nil {
+		t.Fatalf("execute block: %v", err)
+	}
+	if len(receipts) != 2 || receipts[0].Success || receipts[0].Error == "" || !receipts[1].Success || receipts[1].Index != 1 {
+		t.Fatalf("unexpected receipts: %+v", receipts)
+	}
+	got, _ := mgr.GetAccount(sender)
+	if got.Balance != 20 || got.Nonce != 1 {
+		t.Fatalf("expected only the second transfer applied, got %+v", got)
+	}
+}
+
+func TestFilterExecutableLeavesStateUntouched(t *testing.T) {
+	mgr := NewManager(NewMemoryStore())
+	sender, receiver := types.Address{1}, types.Address{2}
+	mgr.cache[sender] = &Account{Address: sender, Balance: 30}
+
+	txs := []types.Transaction{
+		{From: sender, To: receiver, Amount: 20, Nonce: 0, Timestamp: time.Unix(0, 0)},
+		{From: sender, To: receiver, Amount: 20, Nonce: 1, Timestamp: time.Unix(1, 0)},
+		{From: sender, To: receiver, Amount: 10, Nonce: 1, Timestamp: time.Unix(2, 0)},
+	}
+	ok, failed, err := mgr.FilterExecutable(txs, 1)
+	if err != nil {
+		t.Fatalf("filter: %v", err)
+	}
+	if len(ok) != 2 || ok[1].Amount != 10 || len(failed) != 1 || failed[0].Amount != 20 {
+		t.Fatalf("unexpected split: ok=%+v failed=%+v", ok, failed)
+	}
+	if got, _ := mgr.GetAccount(sender); got.Balance != 30 || got.Nonce != 0 {
+		t.Fatalf("dry run changed state: %+v", got)
+	}
+}
+
+func TestExecuteBlockReceiptsUseTxID(t *testing.T) {
+	mgr := NewManager(NewMemoryStore())
+	tx := types.Transaction{From: types.Address{1}, To: types.Address{2}, Timestamp: time.Unix(0, 0)}
+	receipts, err := mgr.ExecuteBlock(types.Block{Header: types.BlockHeader{Height: 1}, Transactions: []types.Transaction{tx}})
+	if err != nil {
+		t.Fatalf("execute block: %v", err)
+	}
+	if receipts[0].TxHash != tx.CalculateHash() {
+		t.Fatalf("expected receipt keyed by %s, got %s", tx.CalculateHash(), receipts[0].TxHash)
+	}
+}
+
+func TestApplyBlockMintsReward(t *testing.T) {
+	mgr := NewManager(NewMemoryStore())
+	if err := mgr.SeedAccount(types.Address{9}, 1000, 0); err != nil {
+		t.Fatalf("seed account: %v", err)
+	}
+	mgr.SetRewardParams(RewardParams{Policy: FixedReward{Amount: 10}})
+
+	proposer := types.Address{1}
+	if err := mgr.ApplyBlock(types.Block{Header: types.BlockHeader{Height: 1, Proposer: proposer}}); err != nil {
+		t.Fatalf("apply block: %v", err)
+	}
+
+	acct, _ := mgr.GetAccount(proposer)
+	if acct.Balance != 10 {
+		t.Fatalf("expected proposer reward 10, got %d", acct.Balance)
+	}
+	supply, err := mgr.TotalSupply()
+	if err != nil {
+		t.Fatalf("total supply: %v", err)
+	}
+	if supply != 1010 {
+		t.Fatalf("expected supply 1010, got %d", supply)
+	}
+}
+
+func TestRewardSplitWithVoters(t *testing.T) {
+	mgr := NewManager(NewMemoryStore())
+	voter, bystander := types.Address{2}, types.Address{3}
+	mgr.cache[voter] = &Account{Address: voter, Balance: 100}
+	mgr.cache[bystander] = &Account{Address: bystander, Balance: 100}
+	mgr.SetRewardParams(RewardParams{Policy: FixedReward{Amount: 100}, VoterShareBps: 4_000})
+
+	proposer := types.Address{1}
+	block := types.Block{
+		Header: types.BlockHeader{Height: 1, Proposer: proposer, LastVoters: []types.Address{voter}},
+		Transactions: []types.Transaction{
+			{Type: types.TxTypeBond, From: voter, To: voter, Amount: 100},
+			{Type: types.TxTypeBond, From: bystander, To: bystander, Amount: 100},
+		},
+	}
+	if err := mgr.ApplyBlock(block); err != nil {
+		t.Fatalf("apply block: %v", err)
+	}
+
+	if acct, _ := mgr.GetAccount(proposer); acct.Balance != 60 {
+		t.Fatalf("expected proposer share 60, got %d", acct.Balance)
+	}
+	if acct, _ := mgr.GetAccount(voter); acct.Balance != 40 {
+		t.Fatalf("expected voter share 40, got %d", acct.Balance)
+	}
+	if acct, _ := mgr.GetAccount(bystander); acct.Balance != 0 {
+		t.Fatalf("expected nothing for a validator that did not vote, got %d", acct.Balance)
+	}
+}
+
+func TestRewardSplitsEvenlyAmongUnbondedVoters(t *testing.T) {
+	mgr := NewManager(NewMemoryStore())
+	mgr.SetRewardParams(RewardParams{Policy: FixedReward{Amount: 100}, VoterShareBps: 5_000})
+
+	proposer := types.Address{1}
+	voters := []types.Address{{2}, {3}, {4}}
+	if err := mgr.ApplyBlock(types.Block{Header: types.BlockHeader{Height: 1, Proposer: proposer, LastVoters: voters}}); err != nil {
+		t.Fatalf("apply block: %v", err)
+	}
+	for _, voter := range voters {
+		if acct, _ := mgr.GetAccount(voter); acct.Balance != 16 {
+			t.Fatalf("expected voter %s to get 16, got %d", voter, acct.Balance)
+		}
+	}
+	if acct, _ := mgr.GetAccount(proposer); acct.Balance != 52 {
+		t.Fatalf("expected proposer to keep 52 including rounding, got %d", acct.Balance)
+	}
+}
+
+func TestHalvingReward(t *testing.T) {
+	policy := HalvingReward{Initial: 8, Interval: 10}
+	cases := map[uint64]uint64{1: 8, 10: 8, 11: 4, 21: 2, 31: 1, 41: 0}
+	for height, want := range cases {
+		if got := policy.BlockReward(height, 0); got != want {
+			t.Fatalf("height %d: expected reward %d, got %d", height, want, got)
+		}
+	}
+}
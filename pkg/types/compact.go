@@ -0,0 +1,61 @@
+package types
+
+import "crypto/sha256"
+
+// ShortTxID identifies a transaction within one compact block. It is keyed
+// by the block's header hash so colliding IDs cannot be precomputed.
+type ShortTxID [8]byte
+
+// CompactBlock announces a block as its header plus a short ID per
+// transaction; receivers rebuild it from their mempool.
+type CompactBlock struct {
+	Header   BlockHeader `json:"header"`
+	ShortIDs []ShortTxID `json:"short_ids"`
+}
+
+func NewCompactBlock(b *Block) *CompactBlock {
+	cb := &CompactBlock{Header: b.Header, ShortIDs: make([]ShortTxID, len(b.Transactions))}
+	key := b.Header.Hash()
+	for i := range b.Transactions {
+		cb.ShortIDs[i] = shortTxID(key, b.Transactions[i].CalculateHash())
+	}
+	return cb
+}
+
+func shortTxID(key, txHash Hash) ShortTxID {
+	sum := sha256.Sum256(append(key[:], txHash[:]...))
+	var id ShortTxID
+	copy(id[:], sum[:])
+	return id
+}
+
+// Fill places the transactions matching the block's short IDs and returns
+// the transaction slots along with the indexes still missing. Candidates are
+// given by hash, so pooled transactions are not re-hashed, and only matches
+// are looked up. Short IDs matched by more than one candidate are left
+// missing.
+func (cb *CompactBlock) Fill(hashes []Hash, lookup func(Hash) (Transaction, bool)) ([]Transaction, []int) {
+	key := cb.Header.Hash()
+	byID := make(map[ShortTxID]int, len(hashes))
+	for i, hash := range hashes {
+		id := shortTxID(key, hash)
+		if _, dup := byID[id]; dup {
+			byID[id] = -1
+			continue
+		}
+		byID[id] = i
+	}
+
+	txs := make([]Transaction, len(cb.ShortIDs))
+	var missing []int
+	for i, id := range cb.ShortIDs {
+		if j, ok := byID[id]; ok && j >= 0 {
+			if tx, ok := lookup(hashes[j]); ok {
+				txs[i] = tx
+				continue
+			}
+		}
+		missing = append(missing, i)
+	}
+	return txs, missing
+}
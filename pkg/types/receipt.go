@@ -0,0 +1,11 @@
+package types
+
+// Receipt records the outcome of executing one transaction of a block. A
+// failed transaction stays in its block but leaves state untouched.
+type Receipt struct {
+	TxHash  Hash   `json:"tx_hash"`
+	Height  uint64 `json:"height"`
+	Index   int    `json:"index"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
@@ -11,8 +11,23 @@ type Hash [32]byte
 
 type Address [32]byte
 
+type TxType uint8
+
+const (
+	// TxTypeTransfer moves Amount from From to To.
+	TxTypeTransfer TxType = iota
+	// TxTypeBond bonds Amount from From's balance to the validator To.
+	// Bonding to yourself registers you as a validator; bonding to anyone
+	// else is a delegation.
+	TxTypeBond
+	// TxTypeUnbond starts unbonding Amount of From's stake from validator To.
+	// The tokens are returned once the unbonding period has elapsed.
+	TxTypeUnbond
+)
+
 type Transaction struct {
 	Hash      Hash      `json:"hash"`
+	Type      TxType    `json:"type,omitempty"`
 	From      Address   `json:"from"`
 	To        Address   `json:"to"`
 	Amount    uint64    `json:"amount"`
@@ -28,6 +43,21 @@ type BlockHeader struct {
 	TxRoot       Hash      `json:"tx_root"`
 	Proposer     Address   `json:"proposer"`
 	Timestamp    time.Time `json:"timestamp"`
+	// Difficulty, Vote and Seal are only populated by proof-of-authority
+	// engines.
+	Difficulty uint64         `json:"difficulty,omitempty"`
+	Vote       *AuthorityVote `json:"vote,omitempty"`
+	Seal       []byte         `json:"seal,omitempty"`
+	// LastVoters lists, in ascending order, the validators whose votes
+	// committed the previous block. Engines without votes leave it empty.
+	LastVoters []Address `json:"last_voters,omitempty"`
+}
+
+// AuthorityVote is a signer's vote, embedded in a block header, to add or
+// remove Target from the authorized signer set.
+type AuthorityVote struct {
+	Target    Address `json:"target"`
+	Authorize bool    `json:"authorize"`
 }
 
 type Block struct {
@@ -48,15 +78,25 @@ type PeerInfo struct {
 	PublicKey []byte `json:"public_key,omitempty"`
 }
 
+// ID returns tx.Hash, or the calculated hash when it is unset. Receipts and
+// the chain's transaction index both key transactions by it.
+func (tx *Transaction) ID() Hash {
+	if tx.Hash != (Hash{}) {
+		return tx.Hash
+	}
+	return tx.CalculateHash()
+}
+
 func (tx *Transaction) CalculateHash() Hash {
 	payload, _ := json.Marshal(struct {
+		Type   TxType  `json:"type,omitempty"`
 		From   Address `json:"from"`
 		To     Address `json:"to"`
 		Amount uint64  `json:"amount"`
 		Nonce  uint64  `json:"nonce"`
 		Time   int64   `json:"timestamp"`
 	}{
-		From: tx.From, To: tx.To, Amount: tx.Amount, Nonce: tx.Nonce, Time: tx.Timestamp.UnixNano(),
+		Type: tx.Type, From: tx.From, To: tx.To, Amount: tx.Amount, Nonce: tx.Nonce, Time: tx.Timestamp.UnixNano(),
 	})
 
 	return sha256.Sum256(payload)
@@ -78,6 +118,13 @@ func (h *BlockHeader) Hash() Hash {
 	return sha256.Sum256(payload)
 }
 
+// SealHash is the hash a block signer signs: the header without its seal.
+func (h *BlockHeader) SealHash() Hash {
+	unsealed := *h
+	unsealed.Seal = nil
+	return unsealed.Hash()
+}
+
 func (a Address) String() string {
 	return hex.EncodeToString(a[:])
 }
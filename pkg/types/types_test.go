@@ -45,3 +45,37 @@ func TestCalculateTxRootAggregatesTransactions(t *testing.T) {
 		t.Fatal("tx root should not be zero hash when block has transactions")
 	}
 }
+
+func TestCompactBlockFill(t *testing.T) {
+	txs := make([]Transaction, 3)
+	for i := range txs {
+		txs[i] = Transaction{From: Address{byte(i + 1)}, Amount: uint64(i), Timestamp: time.Unix(0, int64(i))}
+	}
+	block := Block{Header: BlockHeader{Height: 7}, Transactions: txs}
+	cb := NewCompactBlock(&block)
+
+	unrelated := Transaction{From: Address{9}, Timestamp: time.Unix(0, 9)}
+	pool := make(map[Hash]Transaction)
+	var hashes []Hash
+	for _, tx := range []Transaction{txs[2], unrelated, txs[0]} {
+		hash := tx.CalculateHash()
+		pool[hash] = tx
+		hashes = append(hashes, hash)
+	}
+	filled, missing := cb.Fill(hashes, func(hash Hash) (Transaction, bool) {
+		tx, ok := pool[hash]
+		return tx, ok
+	})
+	if len(missing) != 1 || missing[0] != 1 {
+		t.Fatalf("expected only index 1 missing, got %v", missing)
+	}
+	if filled[0].From != txs[0].From || filled[2].From != txs[2].From {
+		t.Fatal("transactions placed at the wrong index")
+	}
+
+	// Short IDs depend on the header, so another block's IDs do not match.
+	other := Block{Header: BlockHeader{Height: 8}, Transactions: txs}
+	if NewCompactBlock(&other).ShortIDs[0] == cb.ShortIDs[0] {
+		t.Fatal("expected short IDs to be keyed by the header")
+	}
+}